@@ -1,6 +1,7 @@
 package align
 
 import (
+  "context"
   "fmt"
   "github.com/predictive-edge/dom-cluster/dom"
   //"github.com/davecheney/profile"
@@ -48,7 +49,7 @@ func (na *NodeAlignment) Aligned() []AlignmentInstance {
   return na.aligned
 }
 
-// insertion
+// insertion, of a node only in the first forest
 func (na *NodeAlignment) InsOp(score float64, newNode *dom.Node) {
   na.score += score
   na.aligned = append(na.aligned, AlignmentInstance{
@@ -57,16 +58,16 @@ func (na *NodeAlignment) InsOp(score float64, newNode *dom.Node) {
   })
 }
 
-// substitution
+// substitution, of a node of the first forest with one of the second
 func (na *NodeAlignment) SubOp(score float64, firstNode *dom.Node, secondNode *dom.Node) {
   na.score += score
   na.aligned = append(na.aligned, AlignmentInstance{
-    a: secondNode,
-    b: firstNode,
+    a: firstNode,
+    b: secondNode,
   })
 }
 
-// deletion
+// deletion, of a node only in the second forest
 func (na *NodeAlignment) DelOp(score float64, delNode *dom.Node) {
   na.score += score
   na.aligned = append(na.aligned, AlignmentInstance{
@@ -95,6 +96,13 @@ func (na *NodeAlignment) PrintAlignment() {
 // TODO: we can't ultimately use the matrix approach because ?+* prevents us
 // from knowing beforehand the dimensions of the matrix
 func NodeArrAlign(a,b []*dom.Node) *NodeAlignment {
+  alignment, _ := NodeArrAlignContext(context.Background(), a, b)
+  return alignment
+}
+
+// NodeArrAlign, giving up with ctx's error once ctx is done. the context is
+// checked once per column of the matrix
+func NodeArrAlignContext(ctx context.Context, a,b []*dom.Node) (*NodeAlignment, error) {
   la := len(a)
   lb := len(b)
 
@@ -111,10 +119,14 @@ func NodeArrAlign(a,b []*dom.Node) *NodeAlignment {
   // where at the end of each loop d represents the ith column of the matrix.
   // before the first iteration d represents the 0th column
   for i := 1; i <= lb; i++ {
+    if err := ctx.Err(); err != nil {
+      return nil, err
+    }
+
     // the 0th element of each column is just the total "cost" of b so far
     lastDiag := d[0] // keep track of the diagonal to substitute from
     d[0] = d[0].MakeCopy()
-    d[0].InsOp(float64(b[i-1].TreeWeight()), b[i-1])
+    d[0].DelOp(float64(b[i-1].TreeWeight()), b[i-1])
 
     // we now iterate over the column
     for j := 1; j <= la; j++ {
@@ -157,5 +169,5 @@ func NodeArrAlign(a,b []*dom.Node) *NodeAlignment {
     }
   }
 
-  return d[la]
+  return d[la], nil
 }
@@ -0,0 +1,119 @@
+package align
+
+import (
+  "testing"
+
+  "github.com/predictive-edge/dom-cluster/dom"
+)
+
+// a depth-deep tree whose every level has width children, with the tags
+// cycling through names so that forests of different widths partly align
+func benchTree(depth, width int) *dom.Node {
+  names := []string{"div", "p", "span", "a", "li", "ul", "img"}
+  var build func(d int) *dom.Node
+  build = func(d int) *dom.Node {
+    n := &dom.Node{ NodeName: names[d % len(names)] }
+    if d < depth {
+      for i := 0; i < width; i++ {
+        c := build(d+1)
+        c.NodeName = names[(d+i) % len(names)]
+        n.Children = append(n.Children, c)
+      }
+    }
+    return n
+  }
+  return build(1)
+}
+
+func TestNodeArrAlign(t *testing.T) {
+  node := func(name string) *dom.Node {
+    return &dom.Node{ NodeName: name }
+  }
+  a := []*dom.Node{node("h1"), node("p"), node("div")}
+  b := []*dom.Node{node("h1"), node("div"), node("span")}
+
+  alignment := NodeArrAlign(a, b)
+  // p only in a, span only in b, each of weight 1
+  if alignment.Score() != 2 {
+    t.Errorf("score %f, want 2", alignment.Score())
+  }
+  want := [][2]string{{"h1", "h1"}, {"p", ""}, {"div", "div"}, {"", "span"}}
+  got := [][2]string{}
+  for _,instance := range alignment.Aligned() {
+    pair := [2]string{}
+    if instance.A() != nil {
+      pair[0] = instance.A().NodeName
+    }
+    if instance.B() != nil {
+      pair[1] = instance.B().NodeName
+    }
+    got = append(got, pair)
+  }
+  if len(got) != len(want) {
+    t.Fatalf("aligned %v, want %v", got, want)
+  }
+  for i := range want {
+    if got[i] != want[i] {
+      t.Errorf("aligned %v, want %v", got, want)
+      break
+    }
+  }
+}
+
+// aligns the children of two 4-deep trees, 6 and 7 wide, with their metrics
+// memoized as they are after the first merge against them
+func BenchmarkNodeArrAlign(b *testing.B) {
+  x, y := benchTree(4, 6), benchTree(4, 7)
+  x.TreeWeight()
+  y.TreeWeight()
+  b.ResetTimer()
+  for i := 0; i < b.N; i++ {
+    NodeArrAlign(x.Children, y.Children)
+  }
+}
+
+// the same alignment with the metrics dropped every time, as when every
+// TreeWeight call walked its whole subtree
+func BenchmarkNodeArrAlignCold(b *testing.B) {
+  x, y := benchTree(4, 6), benchTree(4, 7)
+  for i := 0; i < b.N; i++ {
+    x.InvalidateTree()
+    y.InvalidateTree()
+    NodeArrAlign(x.Children, y.Children)
+  }
+}
+
+func TestNodeArrAlignSides(t *testing.T) {
+  node := func(name string) *dom.Node {
+    return &dom.Node{ NodeName: name }
+  }
+  h1, p, div := node("h1"), node("p"), node("div")
+  h1b, span, divb := node("h1"), node("span"), node("div")
+
+  tests := []struct {
+    name string
+    a, b []*dom.Node
+    want [][2]*dom.Node
+  }{
+    {"substituted", []*dom.Node{h1, div}, []*dom.Node{h1b, divb}, [][2]*dom.Node{{h1, h1b}, {div, divb}}},
+    {"mixed", []*dom.Node{h1, p, div}, []*dom.Node{h1b, divb, span},
+      [][2]*dom.Node{{h1, h1b}, {p, nil}, {div, divb}, {nil, span}}},
+    // the first column of the matrix, only nodes of b
+    {"only b", nil, []*dom.Node{h1b, span}, [][2]*dom.Node{{nil, h1b}, {nil, span}}},
+    {"only a", []*dom.Node{h1, p}, nil, [][2]*dom.Node{{h1, nil}, {p, nil}}},
+    {"b before a", []*dom.Node{div}, []*dom.Node{span, divb}, [][2]*dom.Node{{nil, span}, {div, divb}}},
+  }
+  for _,test := range tests {
+    aligned := NodeArrAlign(test.a, test.b).Aligned()
+    if len(aligned) != len(test.want) {
+      t.Errorf("%s: %d aligned, want %d", test.name, len(aligned), len(test.want))
+      continue
+    }
+    for i,instance := range aligned {
+      // A() is always from the first forest and B() from the second
+      if instance.A() != test.want[i][0] || instance.B() != test.want[i][1] {
+        t.Errorf("%s: instance %d is %v <-> %v, want %v <-> %v", test.name, i, instance.A(), instance.B(), test.want[i][0], test.want[i][1])
+      }
+    }
+  }
+}
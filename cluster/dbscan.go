@@ -0,0 +1,93 @@
+package cluster
+
+import (
+  "github.com/predictive-edge/dom-cluster/dom"
+)
+
+// label given to rows that don't belong to any dense region
+const NoiseLabel = -1
+
+// Noise returns the rows labeled as noise
+func (c *Clustering) Noise() []int {
+  noise := []int{}
+  for i,label := range c.Labels {
+    if label == NoiseLabel {
+      noise = append(noise, i)
+    }
+  }
+  return noise
+}
+
+// NoiseEntries returns the entries whose rows were labeled as noise
+func NoiseEntries(entries []*dom.Entry, c *Clustering) []*dom.Entry {
+  noise := []*dom.Entry{}
+  for _,i := range c.Noise() {
+    noise = append(noise, entries[i])
+  }
+  return noise
+}
+
+// rows within eps of row i, including i itself
+func regionQuery(m *DistanceMatrix, i int, eps float64) []int {
+  neighbors := []int{}
+  for j := 0; j < m.Len(); j++ {
+    if m.At(i,j) <= eps {
+      neighbors = append(neighbors, j)
+    }
+  }
+  return neighbors
+}
+
+/*
+DBSCAN clusters rows by density: a row with at least minPts rows (itself
+included) within eps is a core row, clusters are the sets of rows reachable
+through chains of core rows, and anything not reachable from a core row is
+labeled NoiseLabel instead of getting a template of its own.
+
+A sparse matrix works as long as its threshold is at least eps.
+*/
+func DBSCAN(m *DistanceMatrix, eps float64, minPts int) *Clustering {
+  const unvisited = -2
+
+  n := m.Len()
+  c := &Clustering{
+    Labels: make([]int, n),
+  }
+  for i := range c.Labels {
+    c.Labels[i] = unvisited
+  }
+
+  for i := 0; i < n; i++ {
+    if c.Labels[i] != unvisited { continue }
+
+    neighbors := regionQuery(m, i, eps)
+    if len(neighbors) < minPts {
+      c.Labels[i] = NoiseLabel
+      continue
+    }
+
+    label := c.NumClusters
+    c.NumClusters++
+    c.Labels[i] = label
+
+    // expand the cluster outwards from every core row reached
+    queue := neighbors
+    for len(queue) > 0 {
+      j := queue[0]
+      queue = queue[1:]
+
+      // noise reached from a core row becomes a border row of this cluster
+      if c.Labels[j] == NoiseLabel {
+        c.Labels[j] = label
+      }
+      if c.Labels[j] != unvisited { continue }
+      c.Labels[j] = label
+
+      if jNeighbors := regionQuery(m, j, eps); len(jNeighbors) >= minPts {
+        queue = append(queue, jNeighbors...)
+      }
+    }
+  }
+
+  return c
+}
@@ -0,0 +1,83 @@
+package cluster
+
+import (
+  "testing"
+)
+
+func TestDBSCAN(t *testing.T) {
+  tests := []struct {
+    name string
+    m *DistanceMatrix
+    eps float64
+    minPts int
+    labels []int
+  }{
+    {"empty", lineMatrix(), 1, 2, []int{}},
+    {"two groups and noise", lineMatrix(0, 0.1, 0.2, 5, 5.1, 5.2, 20), 0.15, 2,
+      []int{0, 0, 0, 1, 1, 1, NoiseLabel}},
+    // 0.3 is a border row: within eps of a core row but not core itself
+    {"border", lineMatrix(0, 0.1, 0.2, 0.35), 0.15, 3, []int{0, 0, 0, 0}},
+    {"all noise", lineMatrix(0, 1, 2), 0.5, 2, []int{NoiseLabel, NoiseLabel, NoiseLabel}},
+    {"min 1 makes every row core", lineMatrix(0, 1, 2), 0.5, 1, []int{0, 1, 2}},
+    {"zero eps", lineMatrix(1, 1, 2), 0, 2, []int{0, 0, NoiseLabel}},
+    {"chained", lineMatrix(0, 1, 2, 3, 4), 1, 2, []int{0, 0, 0, 0, 0}},
+  }
+  for _,test := range tests {
+    c := DBSCAN(test.m, test.eps, test.minPts)
+    if !samePartition(c.Labels, test.labels) {
+      t.Errorf("%s: labels %v, want the partition %v", test.name, c.Labels, test.labels)
+      continue
+    }
+    noise := 0
+    for i,label := range test.labels {
+      if label == NoiseLabel {
+        noise++
+        if c.Labels[i] != NoiseLabel {
+          t.Errorf("%s: row %d labeled %d, want noise", test.name, i, c.Labels[i])
+        }
+      }
+    }
+    if len(c.Noise()) != noise {
+      t.Errorf("%s: %d noise rows, want %d", test.name, len(c.Noise()), noise)
+    }
+  }
+}
+
+func TestDBSCANSparse(t *testing.T) {
+  entries := testEntries(4, 4)
+  dense := DBSCAN(ComputeDistanceMatrix(entries, 1, DenseMatrix), 0.2, 2)
+  sparse := DBSCAN(ComputeDistanceMatrix(entries, 1, 0.2), 0.2, 2)
+  if !samePartition(dense.Labels, sparse.Labels) {
+    t.Errorf("sparse labels %v, dense %v", sparse.Labels, dense.Labels)
+  }
+  if dense.NumClusters != 2 {
+    t.Errorf("%d clusters of products and listings, want 2", dense.NumClusters)
+  }
+}
+
+// pairs a sparse matrix leaves out are never neighbors, even when eps
+// reaches 1
+func TestDBSCANSparseFill(t *testing.T) {
+  m := newDistanceMatrix(make([]string, 3), 1)
+  m.set(0, 1, 0.5)
+  m.set(0, 2, 3)
+  m.set(1, 2, 3)
+  c := DBSCAN(m, 1, 2)
+  if !samePartition(c.Labels, []int{0, 0, NoiseLabel}) || c.Labels[2] != NoiseLabel {
+    t.Errorf("labels %v, want the last row as noise", c.Labels)
+  }
+}
+
+func TestNoiseEntries(t *testing.T) {
+  entries := testEntries(3, 1)
+  c := DBSCAN(ComputeDistanceMatrix(entries, 1, DenseMatrix), 0.2, 2)
+  noise := NoiseEntries(entries, c)
+  if len(noise) != 1 || noise[0] != entries[3] {
+    t.Fatalf("noise %v, want the lone listing", noise)
+  }
+  // noise gets no template
+  templates := TemplatesFromClustering(entries, c, nil)
+  if len(templates) != 1 || templates[0].NumPages != 3 {
+    t.Errorf("%d templates, want one of the 3 products", len(templates))
+  }
+}
@@ -0,0 +1,255 @@
+package cluster
+
+import (
+  "bytes"
+  "encoding/json"
+  "fmt"
+  "io"
+  "sort"
+
+  "github.com/predictive-edge/dom-cluster/dom"
+)
+
+// kinds of change between two wrappers
+const (
+  DiffAdded = "added" // subtree only in the new wrapper
+  DiffRemoved = "removed" // subtree only in the old wrapper
+  DiffReplaced = "replaced" // the root was replaced by a differently named one
+  DiffResigned = "resigned" // the node's sign changed
+  DiffAttr = "attr" // an attribute was added, removed or changed
+  DiffText = "text" // fixed text changed or became variable
+)
+
+// Change is one difference between an old and a new wrapper
+type Change struct {
+  Kind string `json:"kind"`
+  // paths of the node in each wrapper, empty for a side without it
+  OldPath string `json:"oldPath,omitempty"`
+  NewPath string `json:"newPath,omitempty"`
+  Node string `json:"node"` // label of the (new, if any) node
+  // the subtree for added, removed and replaced changes, old and new
+  // values (sign, attribute or text) for the others
+  Old string `json:"old,omitempty"`
+  New string `json:"new,omitempty"`
+  Attr string `json:"attr,omitempty"`
+}
+
+func (c *Change) String() string {
+  path := c.NewPath
+  if path == "" {
+    path = c.OldPath
+  }
+  desc := ""
+  switch c.Kind {
+  case DiffAdded:
+    desc = c.New
+  case DiffRemoved:
+    desc = c.Old
+  case DiffReplaced:
+    desc = c.Old + " -> " + c.New
+  case DiffResigned:
+    desc = fmt.Sprintf("sign %s -> %s", c.Old, c.New)
+  case DiffAttr:
+    desc = fmt.Sprintf("%s %q -> %q", c.Attr, c.Old, c.New)
+  case DiffText:
+    if c.New == "" {
+      desc = fmt.Sprintf("%q -> variable", c.Old)
+    } else {
+      desc = fmt.Sprintf("%q -> %q", c.Old, c.New)
+    }
+  }
+  return fmt.Sprintf("%-9s %s %s: %s", c.Kind, path, c.Node, desc)
+}
+
+// WrapperDiff lists the changes from an old to a new wrapper in document
+// order
+type WrapperDiff struct {
+  Changes []*Change `json:"changes"`
+}
+
+func (d *WrapperDiff) Empty() bool {
+  return len(d.Changes) == 0
+}
+
+// Count returns the number of changes of each kind
+func (d *WrapperDiff) Count() map[string]int {
+  counts := map[string]int{}
+  for _,c := range d.Changes {
+    counts[c.Kind]++
+  }
+  return counts
+}
+
+func (d *WrapperDiff) String() string {
+  buf := &bytes.Buffer{}
+  for _,c := range d.Changes {
+    buf.WriteString(c.String())
+    buf.WriteString("\n")
+  }
+  return buf.String()
+}
+
+func (d *WrapperDiff) WriteJSON(w io.Writer) error {
+  enc := json.NewEncoder(w)
+  enc.SetIndent("", "  ")
+  return enc.Encode(d)
+}
+
+type wrapperDiffer struct {
+  changes []*Change
+}
+
+func (d *wrapperDiffer) add(kind string, oldPath, newPath dom.Path, node *dom.Node) *Change {
+  c := &Change{
+    Kind: kind,
+    Node: node.Label(),
+  }
+  if oldPath != nil {
+    c.OldPath = oldPath.String()
+  }
+  if newPath != nil {
+    c.NewPath = newPath.String()
+  }
+  d.changes = append(d.changes, c)
+  return c
+}
+
+/*
+aligns two lists of children into old/new pairs, nil for a side without the
+node. Like align.NodeArrAlign, only same-named nodes are paired and a gap
+costs the node's weight, but every gap also costs 1: freely repeating nodes
+weigh nothing, so the aligner is free to remove such a node and add it back
+instead of keeping it, which in a diff would be a change that didn't happen.
+*/
+func alignedPairs(a, b []*dom.Node) [][2]*dom.Node {
+  la, lb := len(a), len(b)
+  // cost[i][j] aligns a[i:] with b[j:]
+  cost := make([][]int, la+1)
+  for i := range cost {
+    cost[i] = make([]int, lb+1)
+  }
+  for i := la; i >= 0; i-- {
+    for j := lb; j >= 0; j-- {
+      switch {
+      case i == la && j == lb:
+        continue
+      case i == la:
+        cost[i][j] = cost[i][j+1] + b[j].TreeWeight() + 1
+        continue
+      case j == lb:
+        cost[i][j] = cost[i+1][j] + a[i].TreeWeight() + 1
+        continue
+      }
+      best := cost[i+1][j] + a[i].TreeWeight() + 1
+      if c := cost[i][j+1] + b[j].TreeWeight() + 1; c < best {
+        best = c
+      }
+      if a[i].NodeName == b[j].NodeName && cost[i+1][j+1] < best {
+        best = cost[i+1][j+1]
+      }
+      cost[i][j] = best
+    }
+  }
+
+  // pairs are preferred, then removals before additions
+  pairs := [][2]*dom.Node{}
+  i, j := 0, 0
+  for i < la || j < lb {
+    switch {
+    case i < la && j < lb && a[i].NodeName == b[j].NodeName && cost[i][j] == cost[i+1][j+1]:
+      pairs = append(pairs, [2]*dom.Node{a[i], b[j]})
+      i++
+      j++
+    case i < la && cost[i][j] == cost[i+1][j] + a[i].TreeWeight() + 1:
+      pairs = append(pairs, [2]*dom.Node{a[i], nil})
+      i++
+    default:
+      pairs = append(pairs, [2]*dom.Node{nil, b[j]})
+      j++
+    }
+  }
+  return pairs
+}
+
+// compares two aligned nodes of the same name, then their aligned children
+func (d *wrapperDiffer) diff(a, b *dom.Node, pathA, pathB dom.Path) {
+  if a.Sign.Normalize() != b.Sign.Normalize() {
+    c := d.add(DiffResigned, pathA, pathB, b)
+    c.Old = a.Sign.String()
+    c.New = b.Sign.String()
+  }
+
+  attrs := []string{}
+  for attr := range a.Attrs {
+    attrs = append(attrs, attr)
+  }
+  for attr := range b.Attrs {
+    if _,exists := a.Attrs[attr]; !exists {
+      attrs = append(attrs, attr)
+    }
+  }
+  sort.Strings(attrs)
+  for _,attr := range attrs {
+    if a.Attrs[attr] != b.Attrs[attr] {
+      c := d.add(DiffAttr, pathA, pathB, b)
+      c.Attr = attr
+      c.Old = a.Attrs[attr]
+      c.New = b.Attrs[attr]
+    }
+  }
+
+  // merging pages with different text drops it, so text that was there
+  // before and now differs or is gone has become variable
+  if a.Text != "" && a.Text != b.Text {
+    c := d.add(DiffText, pathA, pathB, b)
+    c.Old = a.Text
+    c.New = b.Text
+  }
+
+  seenA, seenB := 0, 0
+  for _,pair := range alignedPairs(a.Children, b.Children) {
+    oldNode, newNode := pair[0], pair[1]
+    var childA, childB dom.Path
+    if oldNode != nil {
+      childA = append(append(dom.Path{}, pathA...), seenA)
+      seenA++
+    }
+    if newNode != nil {
+      childB = append(append(dom.Path{}, pathB...), seenB)
+      seenB++
+    }
+
+    switch {
+    case oldNode == nil:
+      d.add(DiffAdded, nil, childB, newNode).New = dom.FormatNotation(newNode)
+    case newNode == nil:
+      d.add(DiffRemoved, childA, nil, oldNode).Old = dom.FormatNotation(oldNode)
+    default:
+      d.diff(oldNode, newNode, childA, childB)
+    }
+  }
+}
+
+/*
+DiffWrappers reports how a wrapper changed, e.g. between yesterday's and
+today's template of the same cluster. Children are aligned much like when
+merging (see alignedPairs), and every aligned pair is compared:
+subtrees only in one wrapper are added or removed, and same-named nodes are
+checked for sign, attribute and text changes. Only differently named roots
+are replaced.
+*/
+func DiffWrappers(oldWrapper, newWrapper *dom.Node) *WrapperDiff {
+  d := &wrapperDiffer{
+    changes: []*Change{},
+  }
+  if oldWrapper.NodeName != newWrapper.NodeName {
+    c := d.add(DiffReplaced, dom.Path{}, dom.Path{}, newWrapper)
+    c.Old = dom.FormatNotation(oldWrapper)
+    c.New = dom.FormatNotation(newWrapper)
+  } else {
+    d.diff(oldWrapper, newWrapper, dom.Path{}, dom.Path{})
+  }
+  return &WrapperDiff{
+    Changes: d.changes,
+  }
+}
@@ -0,0 +1,102 @@
+package cluster
+
+import (
+  "bytes"
+  "encoding/json"
+  "strings"
+  "testing"
+
+  "github.com/predictive-edge/dom-cluster/dom"
+)
+
+func mustNotation(t *testing.T, notation string) *dom.Node {
+  n, err := dom.ParseNotation(notation)
+  if err != nil {
+    t.Fatalf("%s: %s", notation, err)
+  }
+  return n
+}
+
+func TestDiffWrappers(t *testing.T) {
+  tests := []struct {
+    name, old, new string
+    want []string
+  }{
+    {"same", "body(div(p a) ul(li^{+}))", "body(div(p a) ul(li^{+}))", nil},
+    {"added", "body(div(p))", "body(div(p) footer)",
+      []string{"added     /1 footer: footer"}},
+    {"removed", "body(nav div(p))", "body(div(p))",
+      []string{"removed   /0 nav: nav"}},
+    {"resigned", "body(ul(li^{3}))", "body(ul(li^{+}))",
+      []string{"resigned  /0/0 li: sign 3 -> +"}},
+    // freely repeating nodes weigh nothing, but must still be aligned rather
+    // than removed and added back
+    {"repeating", "ul(li^{+} li^{*}(a))", "ul(li^{+} li^{*}(a))", nil},
+    {"repeating resigned", "ul(li^{*} p)", "ul(li^{+} p)",
+      []string{"resigned  /0 li: sign * -> +"}},
+    // differently named nodes are never aligned below the root
+    {"renamed", "ul(li^{+}(a))", "ul(li^{+}(b))",
+      []string{"removed   /0/0 a: a", "added     /0/0 b: b"}},
+    {"attrs", "body(div#a.x(p))", "body(div#b(p))",
+      []string{`attr      /0 div#b: class "x" -> ""`, `attr      /0 div#b: id "a" -> "b"`}},
+    {"root replaced", "body(p)", "main(p)",
+      []string{"replaced  / main: body(p) -> main(p)"}},
+  }
+  for _,test := range tests {
+    diff := DiffWrappers(mustNotation(t, test.old), mustNotation(t, test.new))
+    got := strings.Split(strings.TrimSuffix(diff.String(), "\n"), "\n")
+    if diff.Empty() {
+      got = nil
+    }
+    if strings.Join(got, "\n") != strings.Join(test.want, "\n") {
+      t.Errorf("%s:\n%s\nwant\n%s", test.name, strings.Join(got, "\n"), strings.Join(test.want, "\n"))
+    }
+    if diff.Empty() != (len(test.want) == 0) {
+      t.Errorf("%s: Empty() = %t", test.name, diff.Empty())
+    }
+  }
+}
+
+func TestDiffWrappersText(t *testing.T) {
+  oldWrapper := mustNotation(t, "body(h1(#text) p(#text))")
+  oldWrapper.Children[0].Children[0].Text = "Welcome"
+  oldWrapper.Children[1].Children[0].Text = "Sale"
+  newWrapper := mustNotation(t, "body(h1(#text) p(#text))")
+  newWrapper.Children[0].Children[0].Text = "Hello"
+
+  diff := DiffWrappers(oldWrapper, newWrapper)
+  want := `text      /0/0 #text: "Welcome" -> "Hello"` + "\n" +
+  `text      /1/0 #text: "Sale" -> variable` + "\n"
+  if diff.String() != want {
+    t.Errorf("diff\n%s\nwant\n%s", diff, want)
+  }
+  if counts := diff.Count(); counts[DiffText] != 2 || len(counts) != 1 {
+    t.Errorf("counts %v", counts)
+  }
+
+  // text that was variable and now is fixed isn't a change
+  if d := DiffWrappers(newWrapper, oldWrapper); d.Count()[DiffText] != 1 {
+    t.Errorf("reverse diff\n%s", d)
+  }
+}
+
+func TestDiffWrappersPaths(t *testing.T) {
+  diff := DiffWrappers(mustNotation(t, "body(nav div(p^{2}))"), mustNotation(t, "body(div(p^{+}) aside)"))
+  if diff.Count()[DiffRemoved] != 1 || diff.Count()[DiffAdded] != 1 || diff.Count()[DiffResigned] != 1 {
+    t.Fatalf("diff\n%s", diff)
+  }
+  for _,c := range diff.Changes {
+    if c.Kind == DiffResigned && (c.OldPath != "/1/0" || c.NewPath != "/0/0") {
+      t.Errorf("resigned at %s -> %s, want /1/0 -> /0/0", c.OldPath, c.NewPath)
+    }
+  }
+
+  buf := &bytes.Buffer{}
+  if err := diff.WriteJSON(buf); err != nil {
+    t.Fatal(err)
+  }
+  var decoded WrapperDiff
+  if err := json.Unmarshal(buf.Bytes(), &decoded); err != nil || len(decoded.Changes) != 3 {
+    t.Errorf("json round trip: %d changes, %v", len(decoded.Changes), err)
+  }
+}
@@ -1,39 +1,138 @@
 package cluster
 
 import (
+  "context"
+  "math"
   "math/rand"
   "github.com/predictive-edge/dom-cluster/dom"
+  "github.com/predictive-edge/dom-cluster/urlpattern"
 )
 
 const MergeScoreCutoff = 0.3
 
 type Template struct {
+  Id string // identifies a stored template across runs, see SaveTemplates
   Wrapper *dom.Node
   NumPages int
   Included []*dom.Node
   BaseUri string
   Uris []string
+  UriPattern *urlpattern.Pattern
 }
 
 func NewTemplate(baseTemplate *dom.Node) *Template {
   return &Template{
     Wrapper: baseTemplate,
     NumPages: 1,
+    Included: []*dom.Node{baseTemplate},
   }
 }
 
+// creates a template seeded from a single entry
+func newEntryTemplate(seedEntry *dom.Entry) *Template {
+  t := NewTemplate(seedEntry.Dom)
+  t.BaseUri = seedEntry.Uri
+  t.UriPattern = urlpattern.FromURL(seedEntry.Uri)
+  return t
+}
+
+// Distance merges the entry into the template's wrapper and returns the merged
+// wrapper along with the distance between the two. with a zero
+// opts.UriWeight this is the normalized merge score; otherwise the url
+// pattern distance is mixed in
+func (t *Template) Distance(entry *dom.Entry, opts *Options) (*dom.Node, float64) {
+  newWrapper, score, _ := t.DistanceContext(context.Background(), entry, opts)
+  return newWrapper, score
+}
+
+// Distance, giving up with ctx's error once ctx is done
+func (t *Template) DistanceContext(ctx context.Context, entry *dom.Entry, opts *Options) (*dom.Node, float64, error) {
+  newWrapper, score, err := NodeMergeContext(ctx, t.Wrapper, entry.Dom)
+  if err != nil {
+    return nil, 0, err
+  }
+  if opts == nil || opts.UriWeight <= 0 || t.UriPattern == nil {
+    return newWrapper, score, nil
+  }
+
+  uriDist := t.UriPattern.Distance(urlpattern.FromURL(entry.Uri))
+  return newWrapper, (1-opts.UriWeight)*score + opts.UriWeight*uriDist, nil
+}
+
 func (t *Template) AddEntry(newEntry *dom.Entry) bool {
-  newWrapper, score := NodeMerge(t.Wrapper, newEntry.Dom)
-  if score < MergeScoreCutoff {
-    t.NumPages++
-    t.Wrapper = newWrapper
-    t.Uris = append(t.Uris, newEntry.Uri)
-    return true
+  added, _, _ := t.addEntry(context.Background(), newEntry, DefaultOptions())
+  return added
+}
+
+// also returns the distance of the entry. a canceled merge leaves the
+// template as it was
+func (t *Template) addEntry(ctx context.Context, newEntry *dom.Entry, opts *Options) (bool, float64, error) {
+  newWrapper, score, err := t.DistanceContext(ctx, newEntry, opts)
+  if err != nil {
+    return false, 0, err
+  }
+  if score >= MergeScoreCutoff {
+    return false, score, nil
+  }
+
+  t.include(newEntry, newWrapper)
+  return true, score, nil
+}
+
+// records the entry as a member of the template, whose wrapper becomes the
+// given (already merged) wrapper
+func (t *Template) include(newEntry *dom.Entry, newWrapper *dom.Node) {
+  t.NumPages++
+  t.Wrapper = newWrapper
+  t.Included = append(t.Included, newEntry.Dom)
+  t.Uris = append(t.Uris, newEntry.Uri)
+  newPattern := urlpattern.FromURL(newEntry.Uri)
+  if t.UriPattern == nil {
+    t.UriPattern = newPattern
   } else {
-    return false
+    t.UriPattern = t.UriPattern.Merge(newPattern)
+  }
+}
+
+// MemberUris returns the uris of every page of the template, the seed's
+// first, in the same order as Included
+func (t *Template) MemberUris() []string {
+  return append([]string{t.BaseUri}, t.Uris...)
+}
+
+// Classify finds the template closest to the given entry. returns nil if no
+// template is within MergeScoreCutoff, along with the nearest one's score
+func Classify(templates []*Template, entry *dom.Entry, opts *Options) (*Template, float64) {
+  best, bestScore := Nearest(templates, entry, opts)
+  if bestScore >= MergeScoreCutoff {
+    return nil, bestScore
   }
+  return best, bestScore
+}
+
+// Nearest finds the template closest to the given entry however far it is,
+// nil only if there are no templates
+func Nearest(templates []*Template, entry *dom.Entry, opts *Options) (*Template, float64) {
+  best, bestScore, _ := NearestContext(context.Background(), templates, entry, opts)
+  return best, bestScore
+}
 
-  return score > 0
+// Nearest, giving up with ctx's error once ctx is done. the nearest of the
+// templates compared so far is still returned along with the error
+func NearestContext(ctx context.Context, templates []*Template, entry *dom.Entry, opts *Options) (*Template, float64, error) {
+  var best *Template
+  bestScore := math.Inf(1)
+  for _,t := range templates {
+    _, score, err := t.DistanceContext(ctx, entry, opts)
+    if err != nil {
+      return best, bestScore, err
+    }
+    if score < bestScore {
+      best = t
+      bestScore = score
+    }
+  }
+  return best, bestScore, nil
 }
 
 // returns true with probability p
@@ -41,21 +140,51 @@ func BernoulliChance(p float64) bool {
   return rand.Float64() < p
 }
 
-// pick a random key from the given map
-func randomWrapper(wrappers map[*dom.Node]*dom.Entry) *dom.Node {
+// BernoulliChance drawing from rng, or the global source if rng is nil
+func bernoulliChance(rng *rand.Rand, p float64) bool {
+  if rng == nil {
+    return BernoulliChance(p)
+  }
+  return rng.Float64() < p
+}
+
+// pick a random key from the given map. the keys are visited in entry order
+// rather than map order, so that a seeded rng gives reproducible picks
+func randomWrapper(entries []*dom.Entry, wrappers map[*dom.Node]*dom.Entry, rng *rand.Rand) *dom.Node {
   reservoirCount := 0
   var pickedWrapper *dom.Node
-  for wrapper := range wrappers {
+  for _,entry := range entries {
+    if _,exists := wrappers[entry.Dom]; !exists { continue }
     reservoirCount++
-    if BernoulliChance( 1 / float64(reservoirCount) ) {
-      pickedWrapper = wrapper
+    if bernoulliChance(rng, 1 / float64(reservoirCount) ) {
+      pickedWrapper = entry.Dom
     }
   }
   return pickedWrapper
 }
 
 func DoCluster(entries []*dom.Entry) []*Template {
+  return DoClusterWithOptions(entries, DefaultOptions())
+}
+
+func DoClusterWithOptions(entries []*dom.Entry, opts *Options) []*Template {
+  templates, _ := DoClusterContext(context.Background(), entries, opts)
+  return templates
+}
+
+/*
+DoClusterContext is DoClusterWithOptions, giving up with ctx's error once ctx
+is done, e.g. to bound the time spent on pathological pages. The templates
+built so far are returned along with the error, the one being built when ctx
+was canceled included; entries not yet assigned to any template are left out
+of them. A nil opts means DefaultOptions().
+*/
+func DoClusterContext(ctx context.Context, entries []*dom.Entry, opts *Options) ([]*Template, error) {
+  if opts == nil {
+    opts = DefaultOptions()
+  }
   templates := []*Template{}
+  progress := newProgressReporter(opts.Progress)
 
   unusedWrappers := map[*dom.Node]*dom.Entry{}
   usedWrappers := map[*dom.Node]struct{}{}
@@ -65,33 +194,46 @@ func DoCluster(entries []*dom.Entry) []*Template {
   }
 
   for len(unusedWrappers) > 0 {
-    templateSeed := randomWrapper(unusedWrappers)
+    templateSeed := randomWrapper(entries, unusedWrappers, opts.Rand)
     seedEntry := unusedWrappers[templateSeed]
     usedWrappers[templateSeed] = struct{}{}
     delete(unusedWrappers, templateSeed)
 
-    curTemplate := NewTemplate(templateSeed)
-    curTemplate.BaseUri = seedEntry.Uri
+    curTemplate := newEntryTemplate(seedEntry)
+    progress.seed(curTemplate, seedEntry, len(unusedWrappers))
 
     foundMore := true
     // since distance becomes shorter as the template becomes more general, we
     // need to recheck all elements every time the template is augmented
     for foundMore == true {
       foundMore = false
+      progress.startPass()
       // continue to iterate and add to the template until there are no more
       // things to add
-      for wrapper := range unusedWrappers {
-        if curTemplate.AddEntry(unusedWrappers[wrapper]) {
+      for _,entry := range entries {
+        wrapper := entry.Dom
+        if _,exists := unusedWrappers[wrapper]; !exists { continue }
+        added, score, err := curTemplate.addEntry(ctx, entry, opts)
+        if err != nil {
+          templates = append(templates, curTemplate)
+          progress.done(len(unusedWrappers), err)
+          return templates, err
+        }
+        if added {
           usedWrappers[wrapper] = struct{}{}
           delete(unusedWrappers, wrapper)
           foundMore = true
         }
+        progress.merge(entry, score, added, len(unusedWrappers))
       }
+      progress.endPass(len(unusedWrappers))
     }
 
     templates = append(templates, curTemplate)
+    progress.template(len(unusedWrappers))
   }
 
-  return templates
+  progress.done(0, nil)
+  return templates, nil
 
 }
@@ -0,0 +1,61 @@
+package cluster
+
+import (
+  "math"
+  "testing"
+
+  "github.com/predictive-edge/dom-cluster/dom"
+  "github.com/predictive-edge/dom-cluster/urlpattern"
+)
+
+func TestTemplateDistanceUriWeight(t *testing.T) {
+  entries := testEntries(2, 0)
+  tpl := newEntryTemplate(entries[0])
+  other := &dom.Entry{ Uri: "http://ex.com/about/us", Dom: entries[1].Dom }
+
+  _, domScore := tpl.Distance(other, nil)
+  uriDist := tpl.UriPattern.Distance(urlpattern.FromURL(other.Uri))
+  if uriDist == 0 {
+    t.Fatalf("%s and %s at no url distance", tpl.UriPattern, other.Uri)
+  }
+  tests := []struct {
+    weight, want float64
+  }{
+    {0, domScore},
+    {-1, domScore},
+    {1, uriDist},
+    {0.25, 0.75*domScore + 0.25*uriDist},
+  }
+  for _,test := range tests {
+    _, got := tpl.Distance(other, &Options{ UriWeight: test.weight })
+    if math.Abs(got - test.want) > 1e-9 {
+      t.Errorf("url weight %g: distance %f, want %f", test.weight, got, test.want)
+    }
+  }
+
+  // the same page type under a matching url
+  same := &dom.Entry{ Uri: "http://ex.com/product/77", Dom: entries[1].Dom }
+  _, got := tpl.Distance(same, &Options{ UriWeight: 1 })
+  if got != 0 {
+    t.Errorf("distance %f to a matching url, want 0", got)
+  }
+}
+
+func TestClassify(t *testing.T) {
+  entries := testEntries(3, 1)
+  products := newEntryTemplate(entries[0])
+  listing := entries[3]
+
+  tpl, score := Classify([]*Template{products}, entries[1], nil)
+  if _, want := Nearest([]*Template{products}, entries[1], nil); tpl != products || score != want {
+    t.Errorf("product: template %v score %f, want the products at %f", tpl, score, want)
+  }
+  // too far for the template, but the score is still the nearest one's
+  tpl, score = Classify([]*Template{products}, listing, nil)
+  if _, want := products.Distance(listing, nil); tpl != nil || score != want || score < MergeScoreCutoff {
+    t.Errorf("listing: template %v score %f, want none at %f", tpl, score, want)
+  }
+  if tpl, score := Classify(nil, listing, nil); tpl != nil || !math.IsInf(score, 1) {
+    t.Errorf("no templates: template %v score %f", tpl, score)
+  }
+}
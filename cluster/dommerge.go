@@ -2,7 +2,7 @@ package cluster
 
 import (
   "bytes"
-  "log"
+  "context"
   "fmt"
   "github.com/predictive-edge/dom-cluster/align"
   "github.com/predictive-edge/dom-cluster/dom"
@@ -11,6 +11,12 @@ import (
 
 const EditDistThreshold = 0.3
 
+// merge costs of aligned nodes with the same name but a different id or class
+const (
+  IdMismatchCost = 0.75
+  ClassMismatchCost = 0.5
+)
+
 const MaxUint = ^uint(0)
 const MinUint = 0
 const MaxInt = int(MaxUint >> 1)
@@ -41,20 +47,73 @@ func FilterRegionGroups(s []*regionGroup, fn func(*regionGroup) bool) []*regionG
 // merges two nodes by aligning their children, properly synthesizing their
 // signs, and then recursing on each aligned child pair
 func NodeMerge(a,b *dom.Node) (*dom.Node,float64) {
-  retNode, score := NodeMergeRecurse(a,b)
+  retNode, normScore, _ := NodeMergeContext(context.Background(), a, b)
+  return retNode, normScore
+}
+
+// NodeMerge, giving up with ctx's error once ctx is done. a canceled merge
+// has no result
+func NodeMergeContext(ctx context.Context, a,b *dom.Node) (*dom.Node,float64,error) {
+  retNode, score, err := nodeMergeRecurse(ctx, a, b, nil, false)
+  if err != nil {
+    return nil, 0, err
+  }
 
-  // norm = score / ((total1 + total2)/2)
-  normScore := 2*score / float64(a.TreeWeight() + b.TreeWeight())
+  return retNode, score * normFactor(a, b), nil
+}
 
-  return retNode, normScore
+// normalizes a raw merge score by the mean weight of the trees: norm = score
+// / ((total1 + total2)/2). trees that weigh nothing (e.g. roots of sign +)
+// aren't normalized, rather than scoring Inf or NaN
+func normFactor(a, b *dom.Node) float64 {
+  total := a.TreeWeight() + b.TreeWeight()
+  if total == 0 {
+    return 1
+  }
+  return 2 / float64(total)
 }
 
 
 // the inner recursive function that does the work for NodeMerge
 func NodeMergeRecurse(a,b *dom.Node) (*dom.Node,float64) {
+  retNode, score, _ := nodeMergeRecurse(context.Background(), a, b, nil, false)
+  return retNode, score
+}
+
+/*
+aligns the children of two nodes being merged, returning the aligned pairs
+with a's node (if any) first. If swapped, they are aligned b's first: merges
+have always aligned children that way on every other level, and since the
+aligner breaks ties by the order of its arguments, doing so keeps merge
+results, and so clusterings, unchanged.
+*/
+func alignChildren(ctx context.Context, a, b *dom.Node, swapped bool) ([][2]*dom.Node, float64, error) {
+  first, second := a.Children, b.Children
+  if swapped {
+    first, second = second, first
+  }
+  alignment, err := align.NodeArrAlignContext(ctx, first, second)
+  if err != nil {
+    return nil, 0, err
+  }
+  pairs := [][2]*dom.Node{}
+  for _,instance := range alignment.Aligned() {
+    x, y := instance.A(), instance.B()
+    if swapped {
+      x, y = y, x
+    }
+    pairs = append(pairs, [2]*dom.Node{x, y})
+  }
+  return pairs, alignment.Score(), nil
+}
+
+// NodeMergeRecurse, reporting where its costs come from to rec (see
+// ExplainMerge). rec may be nil. ctx is checked as children are aligned, and
+// swapped is the order to align them in, see alignChildren
+func nodeMergeRecurse(ctx context.Context, a,b *dom.Node, rec *mergeRecorder, swapped bool) (*dom.Node,float64,error) {
   newNode := dom.DefaultNode()
   alignScore := 0.0
-  var newSign int
+  var newSign dom.Sign
 
   // if they are both non-nil, then "merge" the nodes
   if a != nil && b != nil {
@@ -62,72 +121,33 @@ func NodeMergeRecurse(a,b *dom.Node) (*dom.Node,float64) {
       newNode.NodeName = a.NodeName
 
       if a.Attrs["id"] != b.Attrs["id"] {
-        alignScore += 0.75
+        alignScore += IdMismatchCost
+        rec.record(CostId, a, b, IdMismatchCost)
       }
       if a.Attrs["class"] != b.Attrs["class"] {
-        alignScore += 0.5
+        alignScore += ClassMismatchCost
+        rec.record(CostClass, a, b, ClassMismatchCost)
       }
 
-      alignment := align.NodeArrAlign(a.Children, b.Children)
-      /*
-      fmt.Println("==============")
-      alignment.PrintAlignment()
-      fmt.Printf("==== Score %f\n", alignment.Score())
-      */
-      alignScore += alignment.Score()
-      for _,instance := range alignment.Aligned() {
-        merged, mergeScore := NodeMergeRecurse(instance.A(),instance.B())
+      // see WriteAlignmentDOT to inspect alignments
+      pairs, pairsScore, err := alignChildren(ctx, a, b, swapped)
+      if err != nil {
+        return nil, 0, err
+      }
+      alignScore += pairsScore
+      for _,pair := range pairs {
+        rec.enter(pair[0], pair[1])
+        merged, mergeScore, err := nodeMergeRecurse(ctx, pair[0], pair[1], rec, !swapped)
+        if err != nil {
+          return nil, 0, err
+        }
+        rec.aligned(pair[0], pair[1], mergeScore)
+        rec.leave(pair[0], pair[1])
         alignScore += mergeScore
         newNode.Children = append(newNode.Children, merged)
       }
 
-      aSign := a.Sign
-      bSign := b.Sign
-
-      if aSign == 0 { aSign = 1 }
-      if bSign == 0 { bSign = 1 }
-      if aSign < bSign { // a is always larger (special char constants are all negative)
-        tmp := aSign
-        aSign = bSign
-        bSign = tmp
-      }
-
-      // handle signs
-      switch {
-      // 1,1 -> 1
-      // N,N -> N
-      // *,* -> *
-      // +,+ -> +
-      // ?,? -> ?
-      case aSign == bSign:
-        newSign = aSign
-
-      // 1,? -> ?
-      case aSign == 1 && bSign == dom.ZeroOne:
-        newSign = dom.ZeroOne
-
-      // N,? -> *
-      case aSign > 1 && bSign == dom.ZeroOne,
-      // +,? -> *
-        aSign == dom.OnePlus && bSign == dom.ZeroOne,
-      // 1,* -> *
-      // N,* -> *
-        aSign >= 1 && bSign == dom.ZeroPlus,
-      // *,? -> *
-        aSign == dom.ZeroPlus && bSign == dom.ZeroOne,
-      // +,* -> *
-        aSign == dom.OnePlus && bSign == dom.ZeroPlus:
-        newSign = dom.ZeroPlus
-
-      // N,1|N,M -> +
-      case aSign > 1 && bSign >=1,
-      // N,+|1,+ -> +
-        aSign >= 1 && bSign == dom.OnePlus:
-        newSign = dom.OnePlus
-
-      default:
-        log.Fatal("ordering wrong somewhere")
-      }
+      newSign = a.Sign.Join(b.Sign)
     } else { // if we have a mismatch then score it as such
       newNode.NodeName = "##mismatch"
       alignScore += float64(a.TreeWeight() + b.TreeWeight())
@@ -141,33 +161,22 @@ func NodeMergeRecurse(a,b *dom.Node) (*dom.Node,float64) {
       newNode.NodeName = b.NodeName
     }
 
-    var sign int
     var node *dom.Node
     if a == nil {
       node = b
-      sign = b.Sign
     } else {
       node = a
-      sign = a.Sign
     }
-    if sign == 0 { sign = 1 }
 
     newNode.Children = node.Children
 
-    // handle signs
-    switch {
-    // 1|? -> ?
-    case sign == 1 || sign == dom.ZeroOne:
-      newSign = dom.ZeroOne
-    //+|*|N -> *
-    default:
-      newSign = dom.ZeroPlus
-    }
+    // 1|? -> ?, +|*|N -> *
+    newSign = node.Sign.Optional()
   }
 
   newNode.Sign = newSign
 
-  return newNode,alignScore
+  return newNode,alignScore,nil
 }
 
 
@@ -225,7 +234,7 @@ func (nr *nodeRegion) String() string {
   buf := bytes.NewBufferString("region - ")
 
   for _,n := range nr.Nodes {
-    buf.WriteString(fmt.Sprintf("%s.%v^{%d}",n.NodeName,n.Attrs["class"],n.Sign))
+    buf.WriteString(fmt.Sprintf("%s.%v^{%s}",n.NodeName,n.Attrs["class"],n.SignStr()))
   }
 
   return buf.String()
@@ -240,11 +249,38 @@ similar or identical structure, and represents them as a single signed node
 or signed parenthetical node whenever possible
 */
 func NodeToWrapper(node *dom.Node, k int) *dom.Node {
+  node, _ = NodeToWrapperContext(context.Background(), node, k)
+  return node
+}
+
+/*
+NodeToWrapper, giving up with ctx's error once ctx is done. Since the node is
+rewritten in place, a canceled run leaves it partly wrapped: subtrees visited
+before the cancellation are wrapped and the rest are left as they were, which
+is still a valid (if less compact) tree to merge.
+*/
+func NodeToWrapperContext(ctx context.Context, node *dom.Node, k int) (*dom.Node, error) {
+  err := nodeToWrapper(ctx, node, k)
+  if err != nil {
+    // children whose signs were rewritten but that weren't visited before the
+    // cancellation keep metrics computed with their old signs. this is done
+    // once here rather than at every level the error passes through
+    node.InvalidateTree()
+  }
+  return node, err
+}
+
+func nodeToWrapper(ctx context.Context, node *dom.Node, k int) error {
+  if err := ctx.Err(); err != nil {
+    return err
+  }
+
+  var err error
   // since we operate on the node's children to find repeating sibling groups,
   // depths of 1 or 2 are extremely unlike
   if node.TreeDepth() >= 3 {
     // if it's not a paren node, try to find repeating elements
-    if node.NodeName != "##paren" {
+    if !node.IsParen() {
       // find any repeating patterns in this node's children
       // a "group" represents the repeating element of such a pattern
 
@@ -277,20 +313,20 @@ func NodeToWrapper(node *dom.Node, k int) *dom.Node {
                 // first element with sign = len(group.Regions)
                 // TODO: generate a copy of the node in question / create it
                 // by properly merging all the group's regions together
-                c.Sign = len(group.Regions)
+                c.Sign = dom.Sign(len(group.Regions))
                 newChildren = append(newChildren, c)
               } else {
                 // if it's a grouping of non-1-sized regions then just add the
                 // first region as a paren group with sign = group.length
                 newChildren = append(newChildren,
-                dom.NewParenNode(group.Regions[0].Nodes,len(group.Regions)))
+                dom.NewParenNode(group.Regions[0].Nodes,dom.Sign(len(group.Regions))))
               }
             }
           }
         }
       }
       //oldChildren := node.Children
-      node.Children = newChildren
+      node.SetChildren(newChildren)
       //postlen := len(node.Children)
 
       //fmt.Println("==========")
@@ -301,19 +337,29 @@ func NodeToWrapper(node *dom.Node, k int) *dom.Node {
 
     }
     for _,c := range node.Children {
-      NodeToWrapper(c, k)
+      if err = nodeToWrapper(ctx, c, k); err != nil {
+        break
+      }
     }
   }
 
-  return node
+  // children (and their signs) below have been rewritten since the metrics
+  // were computed at the top of this call
+  node.Invalidate()
+
+  return err
 }
 
 func listToTagArr (nodeList []*dom.Node, k int) []string {
   retArr := []string{}
+  collect := dom.Walker{
+    Pre: func(c *dom.Cursor) dom.WalkAction {
+      retArr = append(retArr, c.Node.NodeName)
+      return dom.Continue
+    },
+  }
   for _,node := range nodeList {
-    node.CallPreOrder(func (curNode *dom.Node) {
-      retArr = append(retArr, curNode.NodeName)
-    })
+    dom.Walk(node, collect)
   }
   return retArr
 }
@@ -0,0 +1,153 @@
+package cluster
+
+import (
+  "context"
+  "fmt"
+  "hash/fnv"
+  "math/rand"
+  "testing"
+
+  "github.com/predictive-edge/dom-cluster/dom"
+)
+
+// a context canceled after its Err has been checked a given number of times
+type countdownContext struct {
+  context.Context
+  left int
+}
+
+func (c *countdownContext) Err() error {
+  if c.left <= 0 {
+    return context.Canceled
+  }
+  c.left--
+  return nil
+}
+
+// the memoized weight and depth of every node under n, in pre-order
+func memoizedMetrics(n *dom.Node) [][2]int {
+  metrics := [][2]int{}
+  n.CallPreOrder(func(c *dom.Node) {
+    metrics = append(metrics, [2]int{c.TreeWeight(), c.TreeDepth()})
+  })
+  return metrics
+}
+
+func TestNodeToWrapperCanceled(t *testing.T) {
+  src := `<html><body><div><ul>` + repeat("<li><a>x</a><span>y</span></li>", 4) + `</ul>
+    <table>` + repeat("<tr><td><b>1</b></td><td><i>2</i></td></tr>", 3) + `</table></div>
+    <section>` + repeat("<div><img><p>z</p></div>", 5) + `</section></body></html>`
+  full := NodeToWrapper(dom.ParseHTMLString(src), 10)
+
+  for checks := 0; ; checks++ {
+    page := dom.ParseHTMLString(src)
+    page.TreeWeight()
+    wrapper, err := NodeToWrapperContext(&countdownContext{ Context: context.Background(), left: checks }, page, 10)
+    got := memoizedMetrics(wrapper)
+
+    // what the metrics of the partly wrapped tree should be
+    wrapper.InvalidateTree()
+    want := memoizedMetrics(wrapper)
+    for i := range want {
+      if got[i] != want[i] {
+        t.Errorf("canceled after %d checks: node %d has weight, depth %v, want %v", checks, i, got[i], want[i])
+        break
+      }
+    }
+
+    if err == nil {
+      if !dom.Equal(wrapper, full, nil) {
+        t.Errorf("finished after %d checks with a different wrapper", checks)
+      }
+      break
+    }
+    if err != context.Canceled {
+      t.Fatalf("canceled after %d checks: %v", checks, err)
+    }
+  }
+}
+
+func TestDoClusterCanceled(t *testing.T) {
+  entries := testEntries(3, 3)
+  ctx, cancel := context.WithCancel(context.Background())
+  cancel()
+  templates, err := DoClusterContext(ctx, entries, DefaultOptions())
+  if err != context.Canceled {
+    t.Errorf("error %v, want %v", err, context.Canceled)
+  }
+  // the seed of the template being built is kept
+  if len(templates) != 1 || templates[0].NumPages != 1 {
+    t.Errorf("%d templates, want the one seeded", len(templates))
+  }
+
+  templates, err = DoClusterContext(context.Background(), entries, DefaultOptions())
+  pages := 0
+  for _,t := range templates {
+    pages += t.NumPages
+  }
+  if err != nil || pages != len(entries) {
+    t.Errorf("uncanceled run: %d of %d pages, %v", pages, len(entries), err)
+  }
+
+  // no options are the default ones
+  templates, err = DoClusterContext(context.Background(), entries, nil)
+  if err != nil || len(templates) != 2 {
+    t.Errorf("no options: %d templates, %v", len(templates), err)
+  }
+}
+
+// a random tree of the given depth, of few names so that siblings often
+// align with each other
+func randomTree(r *rand.Rand, depth int) *dom.Node {
+  names := []string{"div", "p", "span", "a", "li"}
+  signs := []dom.Sign{1, 1, 1, 2, dom.OnePlus, dom.ZeroPlus, dom.ZeroOne}
+  n := &dom.Node{
+    NodeName: names[r.Intn(len(names))],
+    Attrs: map[string]string{},
+    Sign: signs[r.Intn(len(signs))],
+  }
+  if depth > 0 {
+    for i := r.Intn(4); i > 0; i-- {
+      n.Children = append(n.Children, randomTree(r, depth-1))
+    }
+  }
+  return n
+}
+
+// merges whose results depend on the order children are aligned in, with
+// the results they had before the aligner returned nodes on their own sides
+func TestNodeMergeAlignOrder(t *testing.T) {
+  tests := []struct {
+    a, b, want string
+    score float64
+  }{
+    {"li(div^{+}(span))", "li(div(p))", "li(div^{+}(p^{?} span^{?}))", 1},
+    {"a(a^{?}(p li^{+}))", "a(a^{*}(a))", "a(a^{*}(a^{?} p^{?} li^{*}))", 1},
+    {"p(div^{2}(p))", "p(div^{?}(div))", "p(div^{*}(div^{?} p^{?}))", 0.5},
+    {"li^{+}(li(div))", "li(li^{?}(a a))", "li^{+}(li^{?}(a^{?} a^{?} div^{?}))", 1.5},
+    {"a(a(p^{*} div) li(div^{?}) li)", "a(p(div div) li^{*}(span^{?}))", "a(a^{?}(p^{*} div) p^{?}(div div) li^{*}(span^{?} div^{?}) li^{?})", 1.6},
+  }
+  for _,test := range tests {
+    merged, score := NodeMerge(mustNotation(t, test.a), mustNotation(t, test.b))
+    if got := dom.FormatNotation(merged); got != test.want || score != test.score {
+      t.Errorf("%s + %s = %s (%g), want %s (%g)", test.a, test.b, got, score, test.want, test.score)
+    }
+  }
+}
+
+// every merge of many random trees is unchanged, see TestNodeMergeAlignOrder
+func TestNodeMergeUnchanged(t *testing.T) {
+  r := rand.New(rand.NewSource(1))
+  h := fnv.New64a()
+  for i := 0; i < 5000; i++ {
+    a, b := randomTree(r, 3), randomTree(r, 3)
+    a.Sign, b.Sign = 1, 1
+    b.NodeName = a.NodeName
+    merged, score := NodeMerge(a, b)
+    fmt.Fprintf(h, "%s %.6f\n", dom.FormatNotation(merged), score)
+  }
+  // of the merges before the aligner returned nodes on their own sides
+  if sum := h.Sum64(); sum != 0xa231efef81c7d1e1 {
+    t.Errorf("merge results changed, hash %#x", sum)
+  }
+}
@@ -0,0 +1,100 @@
+package cluster
+
+import (
+  "context"
+  "fmt"
+  "io"
+
+  "github.com/predictive-edge/dom-cluster/dom"
+)
+
+// how a node fared when aligning two trees
+type alignOutcome int
+
+const (
+  alignMatched alignOutcome = iota
+  alignMismatched // aligned with a differently named node
+  alignInserted // only in the first tree
+  alignDeleted // only in the second tree
+)
+
+var alignOutcomeStyles = map[alignOutcome]string{
+  alignMismatched: "style=\"rounded,filled\", fillcolor=orange",
+  alignInserted: "style=\"rounded,filled\", fillcolor=palegreen",
+  alignDeleted: "style=\"rounded,filled\", fillcolor=lightpink",
+}
+
+// the outcome of every node of two trees, and the pairs aligned with each
+// other, following the same recursion as NodeMergeRecurse
+type treeAlignment struct {
+  outcomes map[*dom.Node]alignOutcome
+  pairs [][2]*dom.Node
+}
+
+func (t *treeAlignment) markSubtree(n *dom.Node, outcome alignOutcome) {
+  n.CallPreOrder(func(c *dom.Node) {
+    t.outcomes[c] = outcome
+  })
+}
+
+func (t *treeAlignment) align(a, b *dom.Node, swapped bool) {
+  switch {
+  case a != nil && b != nil && a.NodeName == b.NodeName:
+    t.outcomes[a] = alignMatched
+    t.outcomes[b] = alignMatched
+    t.pairs = append(t.pairs, [2]*dom.Node{a, b})
+    pairs, _, _ := alignChildren(context.Background(), a, b, swapped)
+    for _,pair := range pairs {
+      t.align(pair[0], pair[1], !swapped)
+    }
+  case a != nil && b != nil:
+    t.markSubtree(a, alignMismatched)
+    t.markSubtree(b, alignMismatched)
+    t.pairs = append(t.pairs, [2]*dom.Node{a, b})
+  case a != nil:
+    t.markSubtree(a, alignInserted)
+  case b != nil:
+    t.markSubtree(b, alignDeleted)
+  }
+}
+
+/*
+WriteAlignmentDOT draws the NodeMerge of two trees as a graphviz graph: the
+trees side by side, with dashed edges between aligned nodes. Matched nodes
+are left plain, nodes aligned with a differently named one are orange,
+subtrees only in a (inserted) green and subtrees only in b (deleted) red.
+*/
+func WriteAlignmentDOT(w io.Writer, a, b *dom.Node) error {
+  t := &treeAlignment{
+    outcomes: map[*dom.Node]alignOutcome{},
+  }
+  t.align(a, b, false)
+  _, score := NodeMerge(a, b)
+
+  g := dom.NewDOTGraph("alignment")
+  g.Line("label=%s;", g.Quote(fmt.Sprintf("merge score %.4f\ngreen: inserted (only in a), red: deleted (only in b), orange: mismatched", score)))
+  g.Line("newrank=true;")
+  style := func(n *dom.Node) string {
+    return alignOutcomeStyles[t.outcomes[n]]
+  }
+  g.Line("subgraph cluster_a {")
+  g.Line("label=\"a\";")
+  aIds := g.Tree(a, style)
+  g.Line("}")
+  g.Line("subgraph cluster_b {")
+  g.Line("label=\"b\";")
+  bIds := g.Tree(b, style)
+  g.Line("}")
+
+  for _,pair := range t.pairs {
+    color := "gray"
+    if t.outcomes[pair[0]] == alignMismatched {
+      color = "orange"
+    }
+    g.Line("%s -> %s [style=dashed, dir=none, constraint=false, color=%s];",
+      aIds[pair[0]], bIds[pair[1]], color)
+  }
+
+  _,err := g.WriteTo(w)
+  return err
+}
@@ -0,0 +1,93 @@
+package cluster
+
+import (
+  "bytes"
+  "fmt"
+  "regexp"
+  "strings"
+  "testing"
+
+  "github.com/predictive-edge/dom-cluster/dom"
+)
+
+// the label of each node id in graphviz source, and its attributes
+func dotNodes(src string) map[string]string {
+  nodes := map[string]string{}
+  for _,m := range regexp.MustCompile(`(?m)^(n\d+) \[label="([^"]*)"(.*)\];$`).FindAllStringSubmatch(src, -1) {
+    nodes[m[1]] = m[2] + m[3]
+  }
+  return nodes
+}
+
+func TestWriteAlignmentDOT(t *testing.T) {
+  a := mustNotation(t, "body(h1 nav(a) div(p))")
+  b := mustNotation(t, "body(h1 div(p) footer)")
+  buf := &bytes.Buffer{}
+  if err := WriteAlignmentDOT(buf, a, b); err != nil {
+    t.Fatal(err)
+  }
+  src := buf.String()
+  nodes := dotNodes(src)
+
+  // a's nodes come first, then b's
+  want := map[string]string{
+    "n0": "body",
+    "n1": "h1",
+    "n2": `nav, style="rounded,filled", fillcolor=palegreen`,
+    "n3": `a, style="rounded,filled", fillcolor=palegreen`,
+    "n4": "div",
+    "n5": "p",
+    "n6": "body",
+    "n7": "h1",
+    "n8": "div",
+    "n9": "p",
+    "n10": `footer, style="rounded,filled", fillcolor=lightpink`,
+  }
+  for id,label := range want {
+    if nodes[id] != label {
+      t.Errorf("%s is %q, want %q", id, nodes[id], label)
+    }
+  }
+
+  // dashed edges between the matched nodes only
+  edges := regexp.MustCompile(`(?m)^(n\d+) -> (n\d+) \[style=dashed.*color=(\w+)\];$`).FindAllStringSubmatch(src, -1)
+  pairs := []string{}
+  for _,e := range edges {
+    pairs = append(pairs, e[1] + "-" + e[2] + " " + e[3])
+  }
+  if got := strings.Join(pairs, ", "); got != "n0-n6 gray, n1-n7 gray, n4-n8 gray, n5-n9 gray" {
+    t.Errorf("aligned %s", got)
+  }
+
+  _, score := NodeMerge(a, b)
+  if want := fmt.Sprintf(`label="merge score %.4f\n`, score); !strings.Contains(src, want) {
+    t.Errorf("no %s in\n%s", want, src)
+  }
+}
+
+func TestWriteAlignmentDOTMismatch(t *testing.T) {
+  a := &dom.Node{ NodeName: "ul", Children: []*dom.Node{
+    { NodeName: "li", Children: []*dom.Node{{ NodeName: "a" }} },
+  }}
+  // different roots are aligned with each other, mismatched
+  b := &dom.Node{ NodeName: "ol", Attrs: map[string]string{ "class": `q"x` } }
+  buf := &bytes.Buffer{}
+  if err := WriteAlignmentDOT(buf, a, b); err != nil {
+    t.Fatal(err)
+  }
+  src := buf.String()
+  nodes := dotNodes(src)
+  orange := `, style="rounded,filled", fillcolor=orange`
+  for id,label := range map[string]string{"n0": "ul", "n1": "li", "n2": "a"} {
+    if nodes[id] != label + orange {
+      t.Errorf("%s is %q, want %q", id, nodes[id], label + orange)
+    }
+  }
+  // quotes in selectors are escaped
+  if want := `n3 [label="ol.q\"x", style="rounded,filled", fillcolor=orange];`; !strings.Contains(src, want) {
+    t.Errorf("no %s in\n%s", want, src)
+  }
+  if !strings.Contains(src, "n0 -> n3 [style=dashed, dir=none, constraint=false, color=orange];") {
+    t.Errorf("roots not aligned in\n%s", src)
+  }
+}
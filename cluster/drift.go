@@ -0,0 +1,267 @@
+package cluster
+
+import (
+  "bufio"
+  "bytes"
+  "encoding/json"
+  "fmt"
+  "io"
+  "sort"
+
+  "github.com/predictive-edge/dom-cluster/dom"
+)
+
+// width of the bins of TemplateStats.Histogram. the last bin also holds
+// every larger score
+const (
+  DriftHistogramBin = 0.1
+  DriftHistogramBins = 10
+)
+
+// the bin of a score in a histogram of DriftHistogramBins bins
+func histogramBin(score float64) int {
+  bin := int(score / DriftHistogramBin)
+  if bin >= DriftHistogramBins {
+    bin = DriftHistogramBins - 1
+  }
+  return bin
+}
+
+// TemplateStats describes how one day's pages matched a stored template
+type TemplateStats struct {
+  Pages int `json:"pages"` // pages nearest to the template
+  Matched int `json:"matched"` // of those, pages within MergeScoreCutoff
+  MatchRate float64 `json:"matchRate"`
+  MeanScore float64 `json:"meanScore"` // over all the template's pages
+  Histogram []int `json:"histogram"` // of their scores
+}
+
+// DayStats is one run of the monitor, e.g. a day's crawl
+type DayStats struct {
+  Date string `json:"date"`
+  Pages int `json:"pages"`
+  Templates map[string]*TemplateStats `json:"templates"` // by template id
+}
+
+/*
+ClassifyDay matches a day's pages against stored templates. Every page is
+counted towards its nearest template, whether or not it is within
+MergeScoreCutoff of it, so that a template whose pages changed shows up as
+a falling match rate and a rising mean score rather than as pages vanishing.
+*/
+func ClassifyDay(date string, templates []*Template, entries []*dom.Entry, opts *Options) *DayStats {
+  day := &DayStats{
+    Date: date,
+    Pages: len(entries),
+    Templates: map[string]*TemplateStats{},
+  }
+  for _,t := range templates {
+    day.Templates[t.Id] = &TemplateStats{
+      Histogram: make([]int, DriftHistogramBins),
+    }
+  }
+
+  for _,entry := range entries {
+    t, score := Nearest(templates, entry, opts)
+    if t == nil {
+      continue
+    }
+    stats := day.Templates[t.Id]
+    stats.Pages++
+    if score < MergeScoreCutoff {
+      stats.Matched++
+    }
+    stats.MeanScore += score
+    stats.Histogram[histogramBin(score)]++
+  }
+
+  for _,stats := range day.Templates {
+    if stats.Pages > 0 {
+      stats.MatchRate = float64(stats.Matched) / float64(stats.Pages)
+      stats.MeanScore /= float64(stats.Pages)
+    }
+  }
+  return day
+}
+
+// ReadHistory reads the days recorded by AppendHistory, one json object per
+// line, oldest first. blank lines are skipped
+func ReadHistory(r io.Reader) ([]*DayStats, error) {
+  history := []*DayStats{}
+  br := bufio.NewReader(r)
+  for lineNum := 1; ; lineNum++ {
+    line, err := br.ReadBytes('\n')
+    if len(bytes.TrimSpace(line)) > 0 {
+      var day DayStats
+      if jsonErr := json.Unmarshal(line, &day); jsonErr != nil {
+        return nil, fmt.Errorf("history line %d: %s", lineNum, jsonErr)
+      }
+      history = append(history, &day)
+    }
+    if err == io.EOF {
+      return history, nil
+    }
+    if err != nil {
+      return nil, err
+    }
+  }
+}
+
+// AppendHistory writes a day as one line of a history file
+func AppendHistory(w io.Writer, day *DayStats) error {
+  data, err := json.Marshal(day)
+  if err != nil {
+    return err
+  }
+  _,err = w.Write(append(data, '\n'))
+  return err
+}
+
+// DriftThresholds decide when a template has degraded. a zero threshold is
+// disabled
+type DriftThresholds struct {
+  MinMatchRate float64 // lowest acceptable match rate
+  MaxMeanScore float64 // highest acceptable mean score
+  // largest acceptable fall in match rate and rise in mean score from the
+  // baseline, the average of the previous BaselineDays days
+  MaxMatchRateDrop float64
+  MaxMeanScoreRise float64
+  BaselineDays int
+  // templates with fewer pages on a day aren't judged, since their rates
+  // are too noisy
+  MinPages int
+}
+
+func DefaultDriftThresholds() *DriftThresholds {
+  return &DriftThresholds{
+    MinMatchRate: 0.8,
+    MaxMeanScore: MergeScoreCutoff,
+    MaxMatchRateDrop: 0.15,
+    MaxMeanScoreRise: 0.1,
+    BaselineDays: 7,
+    MinPages: 5,
+  }
+}
+
+// metrics a DriftEvent can be about
+const (
+  MetricMatchRate = "matchRate"
+  MetricMeanScore = "meanScore"
+)
+
+// DriftEvent is an alert that a template's pages no longer match it well
+type DriftEvent struct {
+  Event string `json:"event"` // always "drift"
+  Date string `json:"date"`
+  Template string `json:"template"`
+  Metric string `json:"metric"`
+  Value float64 `json:"value"`
+  Threshold float64 `json:"threshold"`
+  // the baseline value, for alerts relative to it
+  Baseline *float64 `json:"baseline,omitempty"`
+}
+
+func (e *DriftEvent) String() string {
+  msg := fmt.Sprintf("%s: template %s %s is %.4f, past %.4f", e.Date, e.Template, e.Metric, e.Value, e.Threshold)
+  if e.Baseline != nil {
+    msg += fmt.Sprintf(" (baseline %.4f)", *e.Baseline)
+  }
+  return msg
+}
+
+// averages a template's match rate and mean score over the days it had
+// enough pages on
+func baseline(history []*DayStats, id string, minPages int) (float64, float64, bool) {
+  rate, score := 0.0, 0.0
+  days := 0
+  for _,day := range history {
+    stats, exists := day.Templates[id]
+    if !exists || stats.Pages == 0 || stats.Pages < minPages {
+      continue
+    }
+    rate += stats.MatchRate
+    score += stats.MeanScore
+    days++
+  }
+  if days == 0 {
+    return 0, 0, false
+  }
+  return rate / float64(days), score / float64(days), true
+}
+
+/*
+CheckDrift judges a day against the thresholds and the days before it in
+history (which should not include the day itself), returning an event for
+every template and metric past its threshold, ordered by template id.
+
+A template with a baseline but no pages on the day, including one missing
+from the day altogether, is judged on a match rate of zero: its pages stopped
+matching it entirely.
+*/
+func CheckDrift(day *DayStats, history []*DayStats, th *DriftThresholds) []*DriftEvent {
+  if th.BaselineDays > 0 && len(history) > th.BaselineDays {
+    history = history[len(history)-th.BaselineDays:]
+  }
+
+  seen := map[string]bool{}
+  ids := []string{}
+  addId := func(id string) {
+    if !seen[id] {
+      seen[id] = true
+      ids = append(ids, id)
+    }
+  }
+  for id := range day.Templates {
+    addId(id)
+  }
+  for _,past := range history {
+    for id := range past.Templates {
+      addId(id)
+    }
+  }
+  sort.Strings(ids)
+
+  events := []*DriftEvent{}
+  alert := func(id, metric string, value, threshold float64, base *float64) {
+    events = append(events, &DriftEvent{
+      Event: "drift",
+      Date: day.Date,
+      Template: id,
+      Metric: metric,
+      Value: value,
+      Threshold: threshold,
+      Baseline: base,
+    })
+  }
+
+  checkMatchRate := func(id string, rate float64) {
+    if th.MinMatchRate > 0 && rate < th.MinMatchRate {
+      alert(id, MetricMatchRate, rate, th.MinMatchRate, nil)
+    } else if baseRate, _, ok := baseline(history, id, th.MinPages); ok && th.MaxMatchRateDrop > 0 &&
+    rate < baseRate - th.MaxMatchRateDrop {
+      alert(id, MetricMatchRate, rate, baseRate - th.MaxMatchRateDrop, &baseRate)
+    }
+  }
+
+  for _,id := range ids {
+    stats := day.Templates[id]
+    if stats == nil || stats.Pages == 0 {
+      // there is no score to judge, only the pages that no longer come
+      if _, _, ok := baseline(history, id, th.MinPages); ok {
+        checkMatchRate(id, 0)
+      }
+      continue
+    }
+    if stats.Pages < th.MinPages {
+      continue
+    }
+    checkMatchRate(id, stats.MatchRate)
+    if th.MaxMeanScore > 0 && stats.MeanScore > th.MaxMeanScore {
+      alert(id, MetricMeanScore, stats.MeanScore, th.MaxMeanScore, nil)
+    } else if _, baseScore, ok := baseline(history, id, th.MinPages); ok && th.MaxMeanScoreRise > 0 &&
+    stats.MeanScore > baseScore + th.MaxMeanScoreRise {
+      alert(id, MetricMeanScore, stats.MeanScore, baseScore + th.MaxMeanScoreRise, &baseScore)
+    }
+  }
+  return events
+}
@@ -0,0 +1,204 @@
+package cluster
+
+import (
+  "bytes"
+  "fmt"
+  "reflect"
+  "strings"
+  "testing"
+)
+
+func TestHistogramBin(t *testing.T) {
+  tests := []struct {
+    score float64
+    want int
+  }{
+    {0, 0},
+    {0.05, 0},
+    {0.15, 1},
+    {0.95, 9},
+    // larger scores go into the last bin
+    {1, 9},
+    {3.5, 9},
+  }
+  for _,test := range tests {
+    if got := histogramBin(test.score); got != test.want {
+      t.Errorf("histogramBin(%g) = %d, want %d", test.score, got, test.want)
+    }
+  }
+}
+
+func TestClassifyDay(t *testing.T) {
+  entries := testEntries(4, 3)
+  products := newEntryTemplate(entries[0])
+  products.Id = "products"
+  listings := newEntryTemplate(entries[4])
+  listings.Id = "listings"
+  templates := []*Template{products, listings}
+
+  day := ClassifyDay("2024-01-02", templates, entries, DefaultOptions())
+  if day.Date != "2024-01-02" || day.Pages != 7 {
+    t.Errorf("date %s, %d pages, want 2024-01-02 and 7", day.Date, day.Pages)
+  }
+  for id,want := range map[string]int{"products": 4, "listings": 3} {
+    stats := day.Templates[id]
+    if stats == nil {
+      t.Errorf("%s: no stats", id)
+      continue
+    }
+    if stats.Pages != want || stats.Matched != want || stats.MatchRate != 1 {
+      t.Errorf("%s: %d pages, %d matched, rate %f, want %d matched of %d", id, stats.Pages, stats.Matched, stats.MatchRate, want, want)
+    }
+    if stats.MeanScore < 0 || stats.MeanScore >= MergeScoreCutoff {
+      t.Errorf("%s: mean score %f", id, stats.MeanScore)
+    }
+    total := 0
+    for _,count := range stats.Histogram {
+      total += count
+    }
+    if len(stats.Histogram) != DriftHistogramBins || total != want {
+      t.Errorf("%s: histogram %v", id, stats.Histogram)
+    }
+  }
+
+  // every page counts towards its nearest template, even a poor match
+  day = ClassifyDay("2024-01-03", []*Template{products}, entries, DefaultOptions())
+  stats := day.Templates["products"]
+  if stats.Pages != 7 || stats.Matched != 4 {
+    t.Errorf("%d pages, %d matched, want 7 and 4", stats.Pages, stats.Matched)
+  }
+
+  // a template without pages has zero rates rather than NaN
+  day = ClassifyDay("2024-01-04", templates, entries[:4], DefaultOptions())
+  if stats := day.Templates["listings"]; stats.Pages != 0 || stats.MatchRate != 0 || stats.MeanScore != 0 {
+    t.Errorf("empty template: %+v", stats)
+  }
+}
+
+func TestHistoryRoundTrip(t *testing.T) {
+  days := []*DayStats{
+    {Date: "2024-01-01", Pages: 3, Templates: map[string]*TemplateStats{
+      "a": {Pages: 3, Matched: 2, MatchRate: 2.0/3, MeanScore: 0.2, Histogram: []int{1, 2}},
+    }},
+    {Date: "2024-01-02", Pages: 0, Templates: map[string]*TemplateStats{}},
+  }
+  buf := &bytes.Buffer{}
+  for _,day := range days {
+    if err := AppendHistory(buf, day); err != nil {
+      t.Fatal(err)
+    }
+  }
+  if lines := strings.Count(buf.String(), "\n"); lines != 2 {
+    t.Errorf("%d lines, want 2", lines)
+  }
+  history, err := ReadHistory(buf)
+  if err != nil {
+    t.Fatal(err)
+  }
+  if !reflect.DeepEqual(history, days) {
+    t.Errorf("read %+v, wrote %+v", history, days)
+  }
+
+  // a missing final newline is fine, a bad line isn't
+  if history, err := ReadHistory(strings.NewReader(`{"date":"x"}`)); err != nil || len(history) != 1 {
+    t.Errorf("no newline: %d days, %v", len(history), err)
+  }
+  // nor are blank lines, as left by hand edits or windows line endings
+  history, err = ReadHistory(strings.NewReader("{\"date\":\"x\"}\r\n\r\n  \n{\"date\":\"y\"}\n\n"))
+  if err != nil || len(history) != 2 || history[1].Date != "y" {
+    t.Errorf("blank lines: %d days, %v", len(history), err)
+  }
+  _,err = ReadHistory(strings.NewReader("{\"date\":\"x\"}\nnot json\n"))
+  if err == nil || !strings.Contains(err.Error(), "line 2") {
+    t.Errorf("bad line: %v", err)
+  }
+}
+
+func TestCheckDrift(t *testing.T) {
+  stats := func(pages int, rate, score float64) *DayStats {
+    return &DayStats{
+      Date: "d",
+      Templates: map[string]*TemplateStats{
+        "a": {Pages: pages, MatchRate: rate, MeanScore: score},
+      },
+    }
+  }
+  history := func(days ...*DayStats) []*DayStats {
+    return days
+  }
+
+  tests := []struct {
+    name string
+    day *DayStats
+    history []*DayStats
+    want []string // metric and threshold of each event
+  }{
+    {"healthy", stats(10, 0.95, 0.1), nil, nil},
+    {"low match rate", stats(10, 0.5, 0.1), nil, []string{"matchRate 0.8000"}},
+    {"high mean score", stats(10, 0.95, 0.5), nil, []string{"meanScore 0.3000"}},
+    {"both", stats(10, 0.5, 0.5), nil, []string{"matchRate 0.8000", "meanScore 0.3000"}},
+    {"too few pages", stats(4, 0.1, 0.9), nil, nil},
+    {"no pages", stats(0, 0, 0), nil, nil},
+
+    // relative to the baseline
+    {"rate drop", stats(10, 0.82, 0.1), history(stats(10, 1, 0.1), stats(10, 1, 0.1)),
+      []string{"matchRate 0.8500 (baseline 1.0000)"}},
+    {"small rate drop", stats(10, 0.9, 0.1), history(stats(10, 1, 0.1)), nil},
+    {"score rise", stats(10, 1, 0.25), history(stats(10, 1, 0.1), stats(10, 1, 0.1)),
+      []string{"meanScore 0.2000 (baseline 0.1000)"}},
+    // days with too few pages aren't part of the baseline
+    {"noisy baseline day", stats(10, 0.82, 0.1), history(stats(2, 0.1, 0.1), stats(10, 0.9, 0.1)), nil},
+    // only the last BaselineDays days are
+    {"old baseline", stats(10, 0.82, 0.1),
+      history(stats(10, 0, 0.1), stats(10, 1, 0.1), stats(10, 1, 0.1), stats(10, 1, 0.1),
+      stats(10, 1, 0.1), stats(10, 1, 0.1), stats(10, 1, 0.1), stats(10, 1, 0.1)),
+      []string{"matchRate 0.8500 (baseline 1.0000)"}},
+    // a template the history doesn't know has no baseline
+    {"new template", stats(10, 0.82, 0.25), history(&DayStats{Templates: map[string]*TemplateStats{}}), nil},
+
+    // a template whose pages stopped coming has lost all of them
+    {"no pages after a baseline", stats(0, 0, 0), history(stats(10, 1, 0.1)),
+      []string{"matchRate 0.8000"}},
+    {"gone after a baseline", &DayStats{Date: "d", Templates: map[string]*TemplateStats{}}, history(stats(10, 1, 0.1)),
+      []string{"matchRate 0.8000"}},
+    {"gone after a noisy baseline", &DayStats{Date: "d", Templates: map[string]*TemplateStats{}}, history(stats(2, 1, 0.1)), nil},
+  }
+  for _,test := range tests {
+    got := []string{}
+    for _,event := range CheckDrift(test.day, test.history, DefaultDriftThresholds()) {
+      if event.Event != "drift" || event.Template != "a" || event.Date != "d" {
+        t.Errorf("%s: event %+v", test.name, event)
+      }
+      desc := fmt.Sprintf("%s %.4f", event.Metric, event.Threshold)
+      if event.Baseline != nil {
+        desc += fmt.Sprintf(" (baseline %.4f)", *event.Baseline)
+      }
+      got = append(got, desc)
+    }
+    if strings.Join(got, ", ") != strings.Join(test.want, ", ") {
+      t.Errorf("%s: events %v, want %v", test.name, got, test.want)
+    }
+  }
+
+  // without an absolute threshold, a lost template drops from its baseline
+  th := DefaultDriftThresholds()
+  th.MinMatchRate = 0
+  events := CheckDrift(&DayStats{Date: "d"}, history(stats(10, 1, 0.1)), th)
+  if len(events) != 1 || events[0].Value != 0 || events[0].Baseline == nil || *events[0].Baseline != 1 {
+    t.Errorf("lost template: events %v", events)
+  }
+}
+
+func TestCheckDriftOrder(t *testing.T) {
+  day := &DayStats{Date: "d", Templates: map[string]*TemplateStats{}}
+  for _,id := range []string{"c", "a", "b"} {
+    day.Templates[id] = &TemplateStats{Pages: 10, MatchRate: 0.5}
+  }
+  ids := []string{}
+  for _,event := range CheckDrift(day, nil, DefaultDriftThresholds()) {
+    ids = append(ids, event.Template)
+  }
+  if !reflect.DeepEqual(ids, []string{"a", "b", "c"}) {
+    t.Errorf("events for %v, want a, b, c", ids)
+  }
+}
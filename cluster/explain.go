@@ -0,0 +1,202 @@
+package cluster
+
+import (
+  "bytes"
+  "context"
+  "fmt"
+  "sort"
+
+  "github.com/predictive-edge/dom-cluster/dom"
+)
+
+// kinds of merge cost
+const (
+  CostId = "id" // same node with a different id
+  CostClass = "class" // same node with a different class
+  CostInsert = "insert" // subtree only in a
+  CostDelete = "delete" // subtree only in b
+  CostMismatch = "mismatch" // differently named subtrees aligned together
+)
+
+// Cost is one contribution to the score of a merge
+type Cost struct {
+  Kind string
+  // where the nodes involved are in a and b, nil for a side without one
+  PathA, PathB dom.Path
+  LabelA, LabelB string
+  WeightA, WeightB int // subtree weights of the nodes involved
+  Cost float64 // raw, as summed by NodeMergeRecurse
+  Normalized float64 // share of the normalized NodeMerge score
+}
+
+func (c *Cost) String() string {
+  side := func(name string, path dom.Path, label string, weight int) string {
+    if path == nil {
+      return ""
+    }
+    return fmt.Sprintf(" %s:%s %s (weight %d)", name, path, label, weight)
+  }
+  return fmt.Sprintf("%8.3f %6.4f %-8s", c.Cost, c.Normalized, c.Kind) +
+  side("a", c.PathA, c.LabelA, c.WeightA) + side("b", c.PathB, c.LabelB, c.WeightB)
+}
+
+// Explanation breaks the score of a NodeMerge down into its costs
+type Explanation struct {
+  Score float64 // normalized, as returned by NodeMerge
+  RawScore float64
+  Costs []*Cost // in document order
+}
+
+// Top returns the n largest costs, all of them if n <= 0
+func (e *Explanation) Top(n int) []*Cost {
+  top := append([]*Cost{}, e.Costs...)
+  sort.SliceStable(top, func(i, j int) bool {
+    return top[i].Cost > top[j].Cost
+  })
+  if n > 0 && len(top) > n {
+    top = top[:n]
+  }
+  return top
+}
+
+// ByKind totals the normalized costs of each kind
+func (e *Explanation) ByKind() map[string]float64 {
+  totals := map[string]float64{}
+  for _,c := range e.Costs {
+    totals[c.Kind] += c.Normalized
+  }
+  return totals
+}
+
+// String summarizes the explanation with its n largest costs
+func (e *Explanation) String(n int) string {
+  buf := &bytes.Buffer{}
+  fmt.Fprintf(buf, "score %.4f (raw %.3f, cutoff %.2f)\n", e.Score, e.RawScore, MergeScoreCutoff)
+  totals := e.ByKind()
+  for _,kind := range []string{CostId, CostClass, CostInsert, CostDelete, CostMismatch} {
+    if total, exists := totals[kind]; exists {
+      fmt.Fprintf(buf, "  %-8s %.4f\n", kind, total)
+    }
+  }
+  fmt.Fprintf(buf, "top costs (raw, normalized, kind, nodes):\n")
+  for _,c := range e.Top(n) {
+    fmt.Fprintf(buf, "%s\n", c)
+  }
+  return buf.String()
+}
+
+// tracks the paths of the nodes being merged and collects their costs
+type mergeRecorder struct {
+  pathA, pathB dom.Path
+  // per level of the recursion, children of a and b aligned so far
+  seenA, seenB []int
+  costs []*Cost
+}
+
+func newMergeRecorder() *mergeRecorder {
+  return &mergeRecorder{
+    pathA: dom.Path{},
+    pathB: dom.Path{},
+    seenA: []int{0},
+    seenB: []int{0},
+  }
+}
+
+func (r *mergeRecorder) record(kind string, a, b *dom.Node, cost float64) {
+  if r == nil {
+    return
+  }
+  c := &Cost{
+    Kind: kind,
+    Cost: cost,
+  }
+  if a != nil {
+    c.PathA = append(dom.Path{}, r.pathA...)
+    c.LabelA = a.Label()
+    c.WeightA = a.TreeWeight()
+  }
+  if b != nil {
+    c.PathB = append(dom.Path{}, r.pathB...)
+    c.LabelB = b.Label()
+    c.WeightB = b.TreeWeight()
+  }
+  r.costs = append(r.costs, c)
+}
+
+// descends into a pair of aligned nodes, either of which may be nil
+func (r *mergeRecorder) enter(a, b *dom.Node) {
+  if r == nil {
+    return
+  }
+  level := len(r.seenA) - 1
+  if a != nil {
+    r.pathA = append(r.pathA, r.seenA[level])
+  }
+  if b != nil {
+    r.pathB = append(r.pathB, r.seenB[level])
+  }
+  r.seenA = append(r.seenA, 0)
+  r.seenB = append(r.seenB, 0)
+}
+
+func (r *mergeRecorder) leave(a, b *dom.Node) {
+  if r == nil {
+    return
+  }
+  r.seenA = r.seenA[:len(r.seenA)-1]
+  r.seenB = r.seenB[:len(r.seenB)-1]
+  level := len(r.seenA) - 1
+  if a != nil {
+    r.pathA = r.pathA[:len(r.pathA)-1]
+    r.seenA[level]++
+  }
+  if b != nil {
+    r.pathB = r.pathB[:len(r.pathB)-1]
+    r.seenB[level]++
+  }
+}
+
+/*
+records the cost NodeArrAlign charged for a pair of aligned nodes, plus
+what merging them cost if they are mismatched. the cost of each alignment
+operation is recomputed here and must follow NodeArrAlign.
+*/
+func (r *mergeRecorder) aligned(a, b *dom.Node, mergeScore float64) {
+  if r == nil {
+    return
+  }
+  switch {
+  case a != nil && b != nil && a.NodeName != b.NodeName:
+    subCost := float64(a.TreeWeight() + b.TreeWeight() + 1)
+    r.record(CostMismatch, a, b, subCost + mergeScore)
+  case a != nil && b == nil:
+    r.record(CostInsert, a, nil, float64(a.TreeWeight()))
+  case a == nil && b != nil:
+    r.record(CostDelete, nil, b, float64(b.TreeWeight()))
+  }
+}
+
+/*
+ExplainMerge merges two trees like NodeMerge, also returning every cost that
+went into the score: id and class penalties, subtrees inserted (only in a)
+or deleted (only in b), and mismatched subtrees, each with the paths and
+subtree weights of its nodes. The costs add up to the raw score, and their
+normalized shares to the returned score.
+*/
+func ExplainMerge(a, b *dom.Node) (*dom.Node, *Explanation) {
+  rec := newMergeRecorder()
+  merged, raw, _ := nodeMergeRecurse(context.Background(), a, b, rec, false)
+  if a.NodeName != b.NodeName {
+    rec.record(CostMismatch, a, b, raw)
+  }
+
+  norm := normFactor(a, b)
+  for _,c := range rec.costs {
+    c.Normalized = c.Cost * norm
+  }
+  return merged, &Explanation{
+    Score: raw * norm,
+    RawScore: raw,
+    Costs: rec.costs,
+  }
+}
@@ -0,0 +1,102 @@
+package cluster
+
+import (
+  "math"
+  "reflect"
+  "strconv"
+  "testing"
+
+  "github.com/predictive-edge/dom-cluster/dom"
+)
+
+func TestExplainMerge(t *testing.T) {
+  tests := []struct {
+    name, a, b string
+    kinds []string // of the costs, in document order
+  }{
+    {"same", "body(div(p) ul(li^{2}))", "body(div(p) ul(li^{2}))", []string{}},
+    {"inserted and deleted", "body(h1 nav(a) div(p))", "body(h1 div(p) footer)",
+      []string{CostInsert, CostDelete}},
+    {"id and class", "body(div#a.x(p) div#c(p))", "body(div#b.y(p) div#c.z(p))",
+      []string{CostId, CostClass, CostClass}},
+    {"nested", "body(div(ul(li li) p))", "body(div(ul(li) span))",
+      []string{CostInsert, CostDelete, CostInsert}},
+    {"root mismatch", "body(p)", "main(p div)", []string{CostMismatch}},
+    {"root mismatch with ids", "body#a(p)", "main#b(p)", []string{CostMismatch}},
+    // trees weighing nothing aren't normalized
+    {"no weight", "li#a.x^{+}", "li#b^{+}", []string{CostId, CostClass}},
+    {"no weight, same", "li^{+}(a)", "li^{*}(a)", []string{}},
+  }
+  for _,test := range tests {
+    a, b := mustNotation(t, test.a), mustNotation(t, test.b)
+    merged, e := ExplainMerge(a, b)
+
+    wantMerged, wantScore := NodeMerge(mustNotation(t, test.a), mustNotation(t, test.b))
+    if math.IsNaN(e.Score) || math.IsInf(e.Score, 0) || e.Score != wantScore {
+      t.Errorf("%s: score %f, NodeMerge's %f", test.name, e.Score, wantScore)
+    }
+    if dom.FormatNotation(merged) != dom.FormatNotation(wantMerged) {
+      t.Errorf("%s: merged %s, NodeMerge's %s", test.name, dom.FormatNotation(merged), dom.FormatNotation(wantMerged))
+    }
+
+    // the costs add up to the raw score, and their normalized shares to the
+    // score
+    raw, normalized := 0.0, 0.0
+    kinds := []string{}
+    for _,c := range e.Costs {
+      raw += c.Cost
+      normalized += c.Normalized
+      kinds = append(kinds, c.Kind)
+    }
+    if math.Abs(raw - e.RawScore) > 1e-9 || math.Abs(normalized - e.Score) > 1e-9 {
+      t.Errorf("%s: costs add up to %f (%f normalized), scores %f (%f)", test.name, raw, normalized, e.RawScore, e.Score)
+    }
+    if !reflect.DeepEqual(kinds, test.kinds) {
+      t.Errorf("%s: costs %v, want %v", test.name, kinds, test.kinds)
+    }
+  }
+}
+
+func TestExplainMergeCosts(t *testing.T) {
+  a := mustNotation(t, "body(h1 div#a.x(p) nav(a))")
+  b := mustNotation(t, "main(h1 div#b.x(p))")
+  _, e := ExplainMerge(a, b)
+  if len(e.Costs) != 1 || e.Costs[0].Kind != CostMismatch {
+    t.Fatalf("costs %v", e.Costs)
+  }
+  // mismatched roots cost both trees' weight
+  if c := e.Costs[0]; c.Cost != float64(a.TreeWeight() + b.TreeWeight()) || c.LabelA != "body" || c.LabelB != "main" {
+    t.Errorf("root mismatch %s", c)
+  }
+
+  b = mustNotation(t, "body(h1 div#b.x(p))")
+  _, e = ExplainMerge(a, b)
+  want := []string{
+    "id a:/1 b:/1 0.75",
+    "insert a:/2 b: 2.00",
+  }
+  got := []string{}
+  for _,c := range e.Costs {
+    got = append(got, c.Kind + " a:" + pathString(c.PathA) + " b:" + pathString(c.PathB) + " " + formatCost(c.Cost))
+  }
+  if !reflect.DeepEqual(got, want) {
+    t.Errorf("costs %v, want %v", got, want)
+  }
+  if top := e.Top(1); len(top) != 1 || top[0].Kind != CostInsert {
+    t.Errorf("top cost %v", top)
+  }
+  if byKind := e.ByKind(); math.Abs(byKind[CostId] + byKind[CostInsert] - e.Score) > 1e-9 {
+    t.Errorf("by kind %v, score %f", byKind, e.Score)
+  }
+}
+
+func pathString(p dom.Path) string {
+  if p == nil {
+    return ""
+  }
+  return p.String()
+}
+
+func formatCost(c float64) string {
+  return strconv.FormatFloat(c, 'f', 2, 64)
+}
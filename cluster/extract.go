@@ -0,0 +1,157 @@
+package cluster
+
+import (
+  "strings"
+
+  "github.com/predictive-edge/dom-cluster/dom"
+)
+
+// attributes extracted along with text, per node name
+var extractAttrs = map[string]string{
+  "a": "href",
+  "img": "src",
+}
+
+// Field holds the values a page has at one node of a wrapper
+type Field struct {
+  Path string `json:"path"` // of the wrapper node
+  XPath string `json:"xpath,omitempty"`
+  Attr string `json:"attr,omitempty"` // empty for text
+  Values []string `json:"values"` // one per occurrence in the page
+}
+
+// Extraction is the data Extract pulled out of a page
+type Extraction struct {
+  Fields []*Field `json:"fields"` // in wrapper order
+  // page nodes matched to the wrapper and skipped over, subtrees of skipped
+  // nodes included
+  Matched int `json:"matched"`
+  Skipped int `json:"skipped"`
+}
+
+type extractor struct {
+  wrapper *dom.Node
+  paths map[*dom.Node]dom.Path
+  fields map[string]*Field
+  order []*Field
+  result *Extraction
+}
+
+func (e *extractor) emit(w *dom.Node, attr string, value string) {
+  path := e.paths[w]
+  key := path.String() + "@" + attr
+  field, exists := e.fields[key]
+  if !exists {
+    field = &Field{
+      Path: path.String(),
+      Attr: attr,
+      Values: []string{},
+    }
+    field.XPath, _ = e.wrapper.XPath(path)
+    e.fields[key] = field
+    e.order = append(e.order, field)
+  }
+  field.Values = append(field.Values, value)
+}
+
+// pulls the data out of a page node matched to wrapper node w
+func (e *extractor) extract(w, p *dom.Node) {
+  e.result.Matched++
+  if w.NodeName == "#text" {
+    if text := strings.TrimSpace(p.Text); text != "" {
+      e.emit(w, "", text)
+    }
+    return
+  }
+  if attr, exists := extractAttrs[w.NodeName]; exists {
+    if value, exists := p.Attrs[attr]; exists {
+      e.emit(w, attr, value)
+    }
+  }
+  pos := e.match(w.Children, p.Children, 0)
+  for _,skipped := range p.Children[pos:] {
+    e.result.Skipped += skipped.NodeCount()
+  }
+}
+
+// the name of the first element a wrapper node matches, looking into parens
+func firstName(w *dom.Node) string {
+  for w.IsParen() && len(w.Children) > 0 {
+    w = w.Children[0]
+  }
+  return w.NodeName
+}
+
+/*
+greedily matches a wrapper's children against page nodes from pos on,
+returning the position after the last one used. each wrapper node takes as
+many consecutive page nodes of its name as its sign allows; a required node
+not found at pos is looked for further on, skipping the page nodes before
+it.
+*/
+func (e *extractor) match(wrapperNodes []*dom.Node, page []*dom.Node, pos int) int {
+  for _,w := range wrapperNodes {
+    name := firstName(w)
+    min, max := w.Sign.MinCount(), w.Sign.MaxCount()
+
+    if min > 0 && (pos >= len(page) || page[pos].NodeName != name) {
+      for skip := pos; skip < len(page); skip++ {
+        if page[skip].NodeName == name {
+          for _,skipped := range page[pos:skip] {
+            e.result.Skipped += skipped.NodeCount()
+          }
+          pos = skip
+          break
+        }
+      }
+    }
+
+    for count := 0; count < max && pos < len(page) && page[pos].NodeName == name; count++ {
+      if w.IsParen() {
+        next := e.match(w.Children, page, pos)
+        if next == pos {
+          break
+        }
+        pos = next
+      } else {
+        e.extract(w, page[pos])
+        pos++
+      }
+    }
+  }
+  return pos
+}
+
+/*
+Extract pulls the text of a page, and the links and image sources, out
+along the nodes of a template's wrapper. The page is matched greedily rather
+than aligned: every wrapper node takes as many consecutive page nodes of its
+name as its sign allows, so a repeated node yields one value per occurrence.
+The page should be filtered like the pages the wrapper was built from, but
+not wrapped itself.
+*/
+func Extract(wrapper *dom.Node, page *dom.Node) *Extraction {
+  e := &extractor{
+    wrapper: wrapper,
+    paths: map[*dom.Node]dom.Path{},
+    fields: map[string]*Field{},
+    result: &Extraction{},
+  }
+  dom.Walk(wrapper, dom.Walker{
+    Pre: func(c *dom.Cursor) dom.WalkAction {
+      e.paths[c.Node] = c.Path()
+      return dom.Continue
+    },
+  })
+
+  if wrapper.NodeName == page.NodeName {
+    e.extract(wrapper, page)
+  } else {
+    e.result.Skipped = page.NodeCount()
+  }
+  e.result.Fields = e.order
+  if e.result.Fields == nil {
+    e.result.Fields = []*Field{}
+  }
+  return e.result
+}
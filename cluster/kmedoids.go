@@ -0,0 +1,241 @@
+package cluster
+
+import (
+  "github.com/predictive-edge/dom-cluster/dom"
+)
+
+// Clustering is a flat assignment of distance matrix rows (and so entries) to
+// clusters numbered 0 to NumClusters-1
+type Clustering struct {
+  Labels []int
+  NumClusters int
+}
+
+// Members returns the row indices belonging to each cluster
+func (c *Clustering) Members() [][]int {
+  members := make([][]int, c.NumClusters)
+  for i,label := range c.Labels {
+    if label >= 0 {
+      members[label] = append(members[label], i)
+    }
+  }
+  return members
+}
+
+// MedoidClustering is a clustering where every cluster is centered on one of
+// its own members
+type MedoidClustering struct {
+  Clustering
+  Medoids []int // row index of each cluster's medoid
+  Cost float64 // total distance of every row to its medoid
+  Silhouette float64
+}
+
+// assigns every row to its nearest medoid, returning the total cost
+func assignToMedoids(m *DistanceMatrix, medoids []int, labels []int) float64 {
+  cost := 0.0
+  for i := range labels {
+    best := 0
+    for c := 1; c < len(medoids); c++ {
+      if m.At(i,medoids[c]) < m.At(i,medoids[best]) {
+        best = c
+      }
+    }
+    labels[i] = best
+    cost += m.At(i,medoids[best])
+  }
+  return cost
+}
+
+/*
+KMedoids partitions the matrix rows into k clusters around real rows using
+PAM: medoids are picked greedily (BUILD), then any medoid/non-medoid swap that
+lowers the total distance is applied until none is left (SWAP). This is
+deterministic, unlike DoCluster.
+*/
+func KMedoids(m *DistanceMatrix, k int) *MedoidClustering {
+  n := m.Len()
+  // no rows, no clusters
+  if n == 0 {
+    return &MedoidClustering{
+      Clustering: Clustering{
+        Labels: []int{},
+      },
+      Medoids: []int{},
+    }
+  }
+  if k > n {
+    k = n
+  }
+  if k < 1 {
+    k = 1
+  }
+
+  // BUILD: the first medoid minimizes the total distance to every row, each
+  // subsequent one is the row that reduces that total the most
+  medoids := []int{}
+  isMedoid := make([]bool, n)
+  nearest := make([]float64, n) // distance of each row to its nearest medoid
+  for len(medoids) < k {
+    bestRow := -1
+    bestCost := 0.0
+    for cand := 0; cand < n; cand++ {
+      if isMedoid[cand] { continue }
+      cost := 0.0
+      for i := 0; i < n; i++ {
+        d := m.At(i,cand)
+        if len(medoids) > 0 && nearest[i] < d {
+          d = nearest[i]
+        }
+        cost += d
+      }
+      if bestRow < 0 || cost < bestCost {
+        bestRow = cand
+        bestCost = cost
+      }
+    }
+    medoids = append(medoids, bestRow)
+    isMedoid[bestRow] = true
+    for i := 0; i < n; i++ {
+      if len(medoids) == 1 || m.At(i,bestRow) < nearest[i] {
+        nearest[i] = m.At(i,bestRow)
+      }
+    }
+  }
+
+  // SWAP
+  labels := make([]int, n)
+  cost := assignToMedoids(m, medoids, labels)
+  trialLabels := make([]int, n)
+  for improved := true; improved; {
+    improved = false
+    for c := range medoids {
+      for cand := 0; cand < n; cand++ {
+        if isMedoid[cand] { continue }
+        old := medoids[c]
+        medoids[c] = cand
+        trialCost := assignToMedoids(m, medoids, trialLabels)
+        if trialCost < cost {
+          cost = trialCost
+          isMedoid[old] = false
+          isMedoid[cand] = true
+          copy(labels, trialLabels)
+          improved = true
+        } else {
+          medoids[c] = old
+        }
+      }
+    }
+  }
+
+  result := &MedoidClustering{
+    Clustering: Clustering{
+      Labels: labels,
+      NumClusters: len(medoids),
+    },
+    Medoids: medoids,
+    Cost: cost,
+  }
+  result.Silhouette = Silhouette(m, &result.Clustering)
+  return result
+}
+
+// KMedoidsAuto runs KMedoids for every k in [kMin,kMax] and keeps the
+// clustering with the highest silhouette
+func KMedoidsAuto(m *DistanceMatrix, kMin, kMax int) *MedoidClustering {
+  if kMin < 2 {
+    kMin = 2
+  }
+  if kMax > m.Len()-1 {
+    kMax = m.Len()-1
+  }
+  if kMax < kMin {
+    return KMedoids(m, 1)
+  }
+
+  var best *MedoidClustering
+  for k := kMin; k <= kMax; k++ {
+    result := KMedoids(m, k)
+    if best == nil || result.Silhouette > best.Silhouette {
+      best = result
+    }
+  }
+  return best
+}
+
+/*
+Silhouette returns the mean silhouette coefficient of the clustering, from -1
+(rows are closer to other clusters than their own) to 1 (tight, well
+separated clusters). Rows in singleton clusters and unassigned (negatively
+labeled) rows count as 0.
+*/
+func Silhouette(m *DistanceMatrix, c *Clustering) float64 {
+  n := m.Len()
+  if n == 0 || c.NumClusters < 2 {
+    return 0
+  }
+  members := c.Members()
+
+  total := 0.0
+  for i := 0; i < n; i++ {
+    own := c.Labels[i]
+    if own < 0 || len(members[own]) <= 1 {
+      continue
+    }
+
+    a := 0.0
+    for _,j := range members[own] {
+      a += m.At(i,j)
+    }
+    a /= float64(len(members[own])-1)
+
+    b := -1.0
+    for label,cluster := range members {
+      if label == own || len(cluster) == 0 { continue }
+      mean := 0.0
+      for _,j := range cluster {
+        mean += m.At(i,j)
+      }
+      mean /= float64(len(cluster))
+      if b < 0 || mean < b {
+        b = mean
+      }
+    }
+    if b < 0 {
+      continue
+    }
+
+    if a < b {
+      total += 1 - a/b
+    } else if a > b {
+      total += b/a - 1
+    }
+  }
+  return total / float64(n)
+}
+
+/*
+TemplatesFromClustering builds one template per cluster. The template is
+seeded from seeds[label] if given (e.g. the cluster's medoid), otherwise from
+the cluster's first member, and every other member is merged into its wrapper
+regardless of MergeScoreCutoff. Rows with a negative label get no template.
+*/
+func TemplatesFromClustering(entries []*dom.Entry, c *Clustering, seeds []int) []*Template {
+  templates := []*Template{}
+  for label,members := range c.Members() {
+    if len(members) == 0 { continue }
+    seed := members[0]
+    if seeds != nil {
+      seed = seeds[label]
+    }
+
+    t := newEntryTemplate(entries[seed])
+    for _,i := range members {
+      if i == seed { continue }
+      newWrapper, _ := NodeMerge(t.Wrapper, entries[i].Dom)
+      t.include(entries[i], newWrapper)
+    }
+    templates = append(templates, t)
+  }
+  return templates
+}
@@ -0,0 +1,99 @@
+package cluster
+
+import (
+  "math"
+  "testing"
+)
+
+// a dense matrix of the distances between points on a line
+func lineMatrix(points ...float64) *DistanceMatrix {
+  m := newDistanceMatrix(make([]string, len(points)), DenseMatrix)
+  for i := range points {
+    for j := i+1; j < len(points); j++ {
+      m.set(i, j, math.Abs(points[i] - points[j]))
+    }
+  }
+  return m
+}
+
+// reports whether rows with the same label in got have the same label in
+// want and vice versa, whatever the numbering
+func samePartition(got, want []int) bool {
+  if len(got) != len(want) {
+    return false
+  }
+  for i := range got {
+    for j := range got {
+      if (got[i] == got[j]) != (want[i] == want[j]) {
+        return false
+      }
+    }
+  }
+  return true
+}
+
+func TestKMedoids(t *testing.T) {
+  m := lineMatrix(0, 0.1, 0.2, 5, 5.1, 10, 10.2, 10.1)
+  result := KMedoids(m, 3)
+  if result.NumClusters != 3 {
+    t.Fatalf("%d clusters, want 3", result.NumClusters)
+  }
+  if want := []int{0, 0, 0, 1, 1, 2, 2, 2}; !samePartition(result.Labels, want) {
+    t.Errorf("labels %v, want the partition %v", result.Labels, want)
+  }
+  // the middle point of each group of three, either point of the pair
+  medoids := map[int]bool{}
+  for _,medoid := range result.Medoids {
+    medoids[medoid] = true
+  }
+  if !medoids[1] || !medoids[7] || !medoids[3] && !medoids[4] {
+    t.Errorf("medoids %v, want 1, 3 or 4 and 7", result.Medoids)
+  }
+  if cost := 0.1 + 0.1 + 0.1 + 0.1 + 0.1; math.Abs(result.Cost - cost) > 1e-9 {
+    t.Errorf("cost %f, want %f", result.Cost, cost)
+  }
+  if result.Silhouette < 0.9 {
+    t.Errorf("silhouette %f of well separated clusters", result.Silhouette)
+  }
+}
+
+func TestKMedoidsEdgeCases(t *testing.T) {
+  tests := []struct {
+    name string
+    m *DistanceMatrix
+    k int
+    clusters int
+  }{
+    {"empty", lineMatrix(), 3, 0},
+    {"empty k 1", lineMatrix(), 1, 0},
+    {"single", lineMatrix(4), 2, 1},
+    {"k above n", lineMatrix(1, 2, 3), 10, 3},
+    {"k below 1", lineMatrix(1, 2, 3), 0, 1},
+    {"identical rows", lineMatrix(1, 1, 1, 1), 2, 2},
+  }
+  for _,test := range tests {
+    result := KMedoids(test.m, test.k)
+    if result.NumClusters != test.clusters || len(result.Medoids) != test.clusters {
+      t.Errorf("%s: %d clusters, %d medoids, want %d", test.name, result.NumClusters, len(result.Medoids), test.clusters)
+    }
+    if len(result.Labels) != test.m.Len() {
+      t.Errorf("%s: %d labels for %d rows", test.name, len(result.Labels), test.m.Len())
+    }
+    if math.IsNaN(result.Silhouette) || math.IsNaN(result.Cost) {
+      t.Errorf("%s: silhouette %f, cost %f", test.name, result.Silhouette, result.Cost)
+    }
+  }
+}
+
+func TestKMedoidsAuto(t *testing.T) {
+  result := KMedoidsAuto(lineMatrix(0, 0.1, 5, 5.1, 10, 10.1), 2, 5)
+  if result.NumClusters != 3 {
+    t.Errorf("picked k=%d, want 3", result.NumClusters)
+  }
+  for _,points := range [][]float64{{}, {1}, {1, 2}} {
+    result := KMedoidsAuto(lineMatrix(points...), 2, 5)
+    if len(result.Labels) != len(points) {
+      t.Errorf("%d points: %d labels", len(points), len(result.Labels))
+    }
+  }
+}
@@ -0,0 +1,388 @@
+package cluster
+
+import (
+  "bufio"
+  "context"
+  "encoding/binary"
+  "encoding/csv"
+  "errors"
+  "io"
+  "io/ioutil"
+  "math"
+  "runtime"
+  "strconv"
+  "sync"
+  "github.com/predictive-edge/dom-cluster/dom"
+)
+
+// threshold of a dense matrix, which keeps every pair. any threshold >= 0,
+// zero included, makes a sparse matrix
+const DenseMatrix = -1.0
+
+// magic bytes at the start of the binary matrix format
+const matrixMagic = "DCMX"
+const matrixVersion = 1
+
+/*
+DistanceMatrix holds the normalized NodeMerge score between every pair of
+entries. NodeMerge is treated as symmetric, so only the upper triangle is
+computed and stored.
+
+A sparse matrix (Threshold >= 0) only keeps pairs scoring at or below its
+threshold; every other pair reads as Fill, which is always past the
+threshold (and at least 1) so that the pairs left out never look closer than
+the ones kept. A dense matrix has the Threshold DenseMatrix.
+*/
+type DistanceMatrix struct {
+  Uris []string
+  Threshold float64
+  Fill float64
+
+  dense []float64 // upper triangle, row-major, nil if sparse
+  sparse []map[int]float64 // sparse[i][j] for i < j
+}
+
+func newDistanceMatrix(uris []string, threshold float64) *DistanceMatrix {
+  n := len(uris)
+  m := &DistanceMatrix{
+    Uris: uris,
+    Threshold: threshold,
+    // finite, so the sums kmedoids takes over distances stay comparable
+    Fill: math.Max(1, 2*threshold),
+  }
+  if threshold >= 0 {
+    m.sparse = make([]map[int]float64, n)
+    for i := range m.sparse {
+      m.sparse[i] = map[int]float64{}
+    }
+  } else {
+    m.dense = make([]float64, n*(n-1)/2)
+  }
+  return m
+}
+
+func (m *DistanceMatrix) Len() int {
+  return len(m.Uris)
+}
+
+func (m *DistanceMatrix) IsSparse() bool {
+  return m.sparse != nil
+}
+
+// index of (i,j), i < j, in the packed upper triangle
+func (m *DistanceMatrix) triIndex(i,j int) int {
+  n := m.Len()
+  return i*(2*n-i-1)/2 + (j-i-1)
+}
+
+// At returns the distance between entries i and j
+func (m *DistanceMatrix) At(i,j int) float64 {
+  if i == j {
+    return 0
+  }
+  if i > j {
+    i, j = j, i
+  }
+  if m.sparse != nil {
+    if v, exists := m.sparse[i][j]; exists {
+      return v
+    }
+    return m.Fill
+  }
+  return m.dense[m.triIndex(i,j)]
+}
+
+func (m *DistanceMatrix) set(i,j int, v float64) {
+  if i > j {
+    i, j = j, i
+  }
+  if m.sparse != nil {
+    if v <= m.Threshold {
+      m.sparse[i][j] = v
+    }
+  } else {
+    m.dense[m.triIndex(i,j)] = v
+  }
+}
+
+// Pairs calls fn for every stored pair i < j, in row-major order. for dense
+// matrices this is every pair
+func (m *DistanceMatrix) Pairs(fn func(i,j int, v float64)) {
+  n := m.Len()
+  for i := 0; i < n; i++ {
+    for j := i+1; j < n; j++ {
+      if m.sparse != nil {
+        if v, exists := m.sparse[i][j]; exists {
+          fn(i,j,v)
+        }
+      } else {
+        fn(i,j,m.dense[m.triIndex(i,j)])
+      }
+    }
+  }
+}
+
+/*
+ComputeDistanceMatrix runs NodeMerge over every pair of entries using the
+given number of worker goroutines (<= 0 means one per cpu). A threshold of
+DenseMatrix produces a dense matrix, any other a sparse one.
+
+Each worker owns whole rows of the upper triangle, so no locking is needed
+while filling it in. The entries' trees are shared by the workers, so their
+lazily memoized metrics are computed before the workers start.
+*/
+func ComputeDistanceMatrix(entries []*dom.Entry, workers int, threshold float64) *DistanceMatrix {
+  m, _ := ComputeDistanceMatrixContext(context.Background(), entries, workers, threshold)
+  return m
+}
+
+// ComputeDistanceMatrix, giving up with ctx's error once ctx is done. the
+// workers stop merging as soon as they notice, and a canceled matrix has no
+// result
+func ComputeDistanceMatrixContext(ctx context.Context, entries []*dom.Entry, workers int, threshold float64) (*DistanceMatrix, error) {
+  uris := make([]string, len(entries))
+  for i,entry := range entries {
+    uris[i] = entry.Uri
+  }
+  m := newDistanceMatrix(uris, threshold)
+
+  if workers <= 0 {
+    workers = runtime.NumCPU()
+  }
+
+  // the workers share the trees, and NodeMerge fills in their metrics lazily.
+  // fill them in before any worker starts, so the workers only ever read the
+  // trees; otherwise concurrent TreeWeight calls race on the memo fields
+  for _,entry := range entries {
+    entry.Dom.TreeWeight()
+  }
+  rows := make(chan int)
+  errs := make([]error, workers) // the first error of each worker
+  var wg sync.WaitGroup
+  for w := 0; w < workers; w++ {
+    wg.Add(1)
+    go func(w int) {
+      defer wg.Done()
+      // once failed, a worker still takes rows so the sender never blocks
+      for i := range rows {
+        for j := i+1; j < len(entries) && errs[w] == nil; j++ {
+          _, score, err := NodeMergeContext(ctx, entries[i].Dom, entries[j].Dom)
+          if err != nil {
+            errs[w] = err
+            break
+          }
+          m.set(i,j,score)
+        }
+      }
+    }(w)
+  }
+  canceled := false
+  for i := 0; i < len(entries) && !canceled; i++ {
+    select {
+    case rows <- i:
+    case <-ctx.Done():
+      canceled = true
+    }
+  }
+  close(rows)
+  wg.Wait()
+
+  for _,err := range errs {
+    if err != nil {
+      return nil, err
+    }
+  }
+  // rows left unsent with every worker idle
+  if canceled {
+    return nil, ctx.Err()
+  }
+  return m, nil
+}
+
+/*
+WriteCSV writes the matrix as csv. Dense matrices are written as a full
+square matrix with a header row and column of uris; sparse matrices are
+written as an edge list of "uri_a,uri_b,score" rows.
+*/
+func (m *DistanceMatrix) WriteCSV(w io.Writer) error {
+  cw := csv.NewWriter(w)
+  formatScore := func(v float64) string {
+    return strconv.FormatFloat(v, 'g', 6, 64)
+  }
+
+  if m.sparse != nil {
+    if err := cw.Write([]string{"uri_a","uri_b","score"}); err != nil {
+      return err
+    }
+    var err error
+    m.Pairs(func(i,j int, v float64) {
+      if err == nil {
+        err = cw.Write([]string{m.Uris[i], m.Uris[j], formatScore(v)})
+      }
+    })
+    if err != nil {
+      return err
+    }
+  } else {
+    if err := cw.Write(append([]string{""}, m.Uris...)); err != nil {
+      return err
+    }
+    row := make([]string, m.Len()+1)
+    for i,uri := range m.Uris {
+      row[0] = uri
+      for j := range m.Uris {
+        row[j+1] = formatScore(m.At(i,j))
+      }
+      if err := cw.Write(row); err != nil {
+        return err
+      }
+    }
+  }
+
+  cw.Flush()
+  return cw.Error()
+}
+
+/*
+WriteBinary writes the matrix in a compact little-endian format:
+
+  "DCMX" version:u8 sparse:u8 n:u32 threshold:f64 fill:f64
+  n x (len:u32 uri)
+  dense:  n(n-1)/2 x score:f32 (upper triangle, row-major)
+  sparse: count:u64, count x (i:u32 j:u32 score:f32)
+*/
+func (m *DistanceMatrix) WriteBinary(w io.Writer) error {
+  bw := bufio.NewWriter(w)
+  put := func(v interface{}) {
+    binary.Write(bw, binary.LittleEndian, v)
+  }
+
+  bw.WriteString(matrixMagic)
+  var sparse uint8
+  if m.sparse != nil {
+    sparse = 1
+  }
+  put(uint8(matrixVersion))
+  put(sparse)
+  put(uint32(m.Len()))
+  put(m.Threshold)
+  put(m.Fill)
+  for _,uri := range m.Uris {
+    put(uint32(len(uri)))
+    bw.WriteString(uri)
+  }
+
+  if m.sparse != nil {
+    count := uint64(0)
+    for _,row := range m.sparse {
+      count += uint64(len(row))
+    }
+    put(count)
+    m.Pairs(func(i,j int, v float64) {
+      put(uint32(i))
+      put(uint32(j))
+      put(float32(v))
+    })
+  } else {
+    for _,v := range m.dense {
+      put(float32(v))
+    }
+  }
+
+  return bw.Flush()
+}
+
+// ReadBinaryMatrix reads a matrix written by WriteBinary
+func ReadBinaryMatrix(r io.Reader) (*DistanceMatrix, error) {
+  br := bufio.NewReader(r)
+  get := func(v interface{}) error {
+    return binary.Read(br, binary.LittleEndian, v)
+  }
+
+  magic := make([]byte, len(matrixMagic))
+  if _, err := io.ReadFull(br, magic); err != nil {
+    return nil, err
+  }
+  if string(magic) != matrixMagic {
+    return nil, errors.New("not a distance matrix file")
+  }
+  var version, sparse uint8
+  var n uint32
+  var threshold, fill float64
+  for _,v := range []interface{}{&version, &sparse, &n, &threshold, &fill} {
+    if err := get(v); err != nil {
+      return nil, err
+    }
+  }
+  if version != matrixVersion {
+    return nil, errors.New("unsupported distance matrix version " + strconv.Itoa(int(version)))
+  }
+
+  if sparse == 1 && threshold < 0 {
+    return nil, errors.New("sparse distance matrix without a threshold")
+  }
+
+  // n and the uri lengths come from the file, so nothing is allocated up
+  // front from them: the slices only grow as the data is actually there
+  uris := []string{}
+  for i := uint32(0); i < n; i++ {
+    var l uint32
+    if err := get(&l); err != nil {
+      return nil, err
+    }
+    buf, err := ioutil.ReadAll(io.LimitReader(br, int64(l)))
+    if err != nil {
+      return nil, err
+    }
+    if len(buf) != int(l) {
+      return nil, io.ErrUnexpectedEOF
+    }
+    uris = append(uris, string(buf))
+  }
+
+  var m *DistanceMatrix
+  if sparse == 1 {
+    m = newDistanceMatrix(uris, threshold)
+  } else {
+    m = &DistanceMatrix{ Uris: uris, Threshold: DenseMatrix }
+  }
+  m.Fill = fill
+
+  var v float32
+  if m.sparse != nil {
+    var count uint64
+    if err := get(&count); err != nil {
+      return nil, err
+    }
+    for k := uint64(0); k < count; k++ {
+      var i, j uint32
+      for _,p := range []interface{}{&i, &j, &v} {
+        if err := get(p); err != nil {
+          return nil, err
+        }
+      }
+      if i >= n || j >= n {
+        return nil, errors.New("distance matrix pair out of range")
+      }
+      if i > j {
+        i, j = j, i
+      }
+      m.sparse[i][int(j)] = float64(v)
+    }
+  } else {
+    size := uint64(n)*uint64(n-1)/2
+    capacity := size
+    if capacity > 1<<16 {
+      capacity = 1<<16
+    }
+    m.dense = make([]float64, 0, capacity)
+    for k := uint64(0); k < size; k++ {
+      if err := get(&v); err != nil {
+        return nil, err
+      }
+      m.dense = append(m.dense, float64(v))
+    }
+  }
+
+  return m, nil
+}
@@ -0,0 +1,207 @@
+package cluster
+
+import (
+  "bytes"
+  "context"
+  "encoding/binary"
+  "fmt"
+  "io"
+  "math"
+  "testing"
+
+  "github.com/predictive-edge/dom-cluster/dom"
+)
+
+// wrapped entries of two page types, product pages and listings, with
+// varying numbers of repeated items
+func testEntries(products, listings int) []*dom.Entry {
+  entries := []*dom.Entry{}
+  for i := 0; i < products; i++ {
+    html := fmt.Sprintf(`<html><head><title>p</title></head><body><div><a>home</a></div>
+      <div><h1>product %d</h1><p>$%d</p><ul>%s</ul></div></body></html>`, i, i, repeat("<li><a>rel</a></li>", 2 + i%3))
+    entries = append(entries, &dom.Entry{
+      Uri: fmt.Sprintf("http://ex.com/product/%d", i),
+      Dom: NodeToWrapper(dom.ParseHTMLString(html), 10),
+    })
+  }
+  for i := 0; i < listings; i++ {
+    html := fmt.Sprintf(`<html><body><nav><a>x</a></nav><section>%s</section><footer>f</footer></body></html>`,
+    repeat("<div><img><span>item</span></div>", 3 + i%4))
+    entries = append(entries, &dom.Entry{
+      Uri: fmt.Sprintf("http://ex.com/category/cat-%d", i),
+      Dom: NodeToWrapper(dom.ParseHTMLString(html), 10),
+    })
+  }
+  return entries
+}
+
+func repeat(s string, n int) string {
+  return string(bytes.Repeat([]byte(s), n))
+}
+
+func TestDistanceMatrixDense(t *testing.T) {
+  entries := testEntries(4, 4)
+  m := ComputeDistanceMatrix(entries, 1, DenseMatrix)
+  if m.IsSparse() {
+    t.Fatal("DenseMatrix threshold made a sparse matrix")
+  }
+  for i := range entries {
+    if m.At(i, i) != 0 {
+      t.Errorf("At(%d,%d) = %f, want 0", i, i, m.At(i, i))
+    }
+    for j := range entries {
+      if m.At(i, j) != m.At(j, i) {
+        t.Errorf("At(%d,%d) = %f but At(%d,%d) = %f", i, j, m.At(i, j), j, i, m.At(j, i))
+      }
+    }
+  }
+  if m.At(0, 1) >= MergeScoreCutoff || m.At(0, 5) < MergeScoreCutoff {
+    t.Errorf("products %f apart, product and listing %f apart", m.At(0, 1), m.At(0, 5))
+  }
+}
+
+func TestDistanceMatrixSparse(t *testing.T) {
+  entries := testEntries(3, 3)
+  dense := ComputeDistanceMatrix(entries, 1, DenseMatrix)
+
+  for _,threshold := range []float64{0, 0.2, 2} {
+    m := ComputeDistanceMatrix(entries, 1, threshold)
+    if !m.IsSparse() {
+      t.Fatalf("threshold %f made a dense matrix", threshold)
+    }
+    for i := range entries {
+      for j := range entries {
+        want := dense.At(i, j)
+        if i != j && want > threshold {
+          want = m.Fill
+        }
+        if got := m.At(i, j); got != want {
+          t.Errorf("threshold %f: At(%d,%d) = %f, want %f", threshold, i, j, got, want)
+        }
+      }
+    }
+  }
+}
+
+func TestDistanceMatrixFill(t *testing.T) {
+  for _,threshold := range []float64{0, 0.5, 1, 2.5} {
+    m := newDistanceMatrix(make([]string, 3), threshold)
+    m.set(0, 1, threshold)
+    if m.Fill <= threshold || m.Fill < 1 {
+      t.Errorf("threshold %g: fill %g", threshold, m.Fill)
+    }
+    // the pair left out is farther than the one kept
+    if m.At(0, 2) <= m.At(0, 1) {
+      t.Errorf("threshold %g: left out pair at %g, kept pair at %g", threshold, m.At(0, 2), m.At(0, 1))
+    }
+  }
+}
+
+// run with -race: the workers share the trees, whose metrics are memoized
+// lazily
+func TestDistanceMatrixWorkers(t *testing.T) {
+  serial := ComputeDistanceMatrix(testEntries(12, 12), 1, DenseMatrix)
+  parallel := ComputeDistanceMatrix(testEntries(12, 12), 8, DenseMatrix)
+  for i := 0; i < serial.Len(); i++ {
+    for j := 0; j < serial.Len(); j++ {
+      if serial.At(i, j) != parallel.At(i, j) {
+        t.Errorf("At(%d,%d) = %f with 1 worker, %f with 8", i, j, serial.At(i, j), parallel.At(i, j))
+      }
+    }
+  }
+}
+
+func TestDistanceMatrixCanceled(t *testing.T) {
+  entries := testEntries(2, 2)
+  ctx, cancel := context.WithCancel(context.Background())
+  cancel()
+  for _,workers := range []int{1, 4} {
+    if m, err := ComputeDistanceMatrixContext(ctx, entries, workers, DenseMatrix); err != context.Canceled || m != nil {
+      t.Errorf("%d workers: matrix %v, error %v, want %v", workers, m, err, context.Canceled)
+    }
+  }
+
+  // canceled at every point of the merges, a single worker gives up with
+  // no matrix until it is left enough checks to finish
+  want := ComputeDistanceMatrix(entries, 1, DenseMatrix)
+  for checks := 0; ; checks++ {
+    m, err := ComputeDistanceMatrixContext(&countdownContext{ Context: context.Background(), left: checks }, entries, 1, DenseMatrix)
+    if err != nil {
+      if err != context.Canceled || m != nil {
+        t.Fatalf("canceled after %d checks: matrix %v, error %v", checks, m, err)
+      }
+      continue
+    }
+    for i := range entries {
+      for j := range entries {
+        if m.At(i, j) != want.At(i, j) {
+          t.Errorf("finished after %d checks: At(%d,%d) = %f, want %f", checks, i, j, m.At(i, j), want.At(i, j))
+        }
+      }
+    }
+    if checks == 0 {
+      t.Error("finished without checking the context")
+    }
+    break
+  }
+}
+
+func TestDistanceMatrixBinary(t *testing.T) {
+  entries := testEntries(3, 3)
+  for _,threshold := range []float64{DenseMatrix, 0, 0.5} {
+    m := ComputeDistanceMatrix(entries, 2, threshold)
+    buf := &bytes.Buffer{}
+    if err := m.WriteBinary(buf); err != nil {
+      t.Fatal(err)
+    }
+    read, err := ReadBinaryMatrix(buf)
+    if err != nil {
+      t.Fatalf("threshold %f: %s", threshold, err)
+    }
+    if read.IsSparse() != m.IsSparse() || read.Threshold != m.Threshold {
+      t.Errorf("threshold %f: read back sparse %v threshold %f", threshold, read.IsSparse(), read.Threshold)
+    }
+    for i := range entries {
+      for j := range entries {
+        // scores are stored as float32
+        if diff := read.At(i, j) - m.At(i, j); diff > 1e-6 || diff < -1e-6 {
+          t.Errorf("threshold %f: At(%d,%d) read back as %f, was %f", threshold, i, j, read.At(i, j), m.At(i, j))
+        }
+      }
+    }
+  }
+
+  if _, err := ReadBinaryMatrix(bytes.NewBufferString("nope")); err == nil {
+    t.Error("read a matrix from garbage")
+  }
+}
+
+// sizes in a truncated or corrupt file must not be trusted for allocating
+func TestReadBinaryMatrixSizes(t *testing.T) {
+  header := func(sparse uint8, n uint32, lengths ...uint32) []byte {
+    buf := &bytes.Buffer{}
+    buf.WriteString(matrixMagic)
+    for _,v := range []interface{}{uint8(matrixVersion), sparse, n, 0.5, 1.0} {
+      binary.Write(buf, binary.LittleEndian, v)
+    }
+    for _,l := range lengths {
+      binary.Write(buf, binary.LittleEndian, l)
+    }
+    return buf.Bytes()
+  }
+  tests := []struct {
+    name string
+    data []byte
+  }{
+    {"huge n", header(0, math.MaxUint32)},
+    {"huge uri", append(header(0, 2, math.MaxUint32), "http://ex.com/"...)},
+    // the uris are all there, the scores of the 5e9 pairs aren't
+    {"huge dense", header(0, 100000, make([]uint32, 100000)...)},
+    {"huge sparse count", append(header(1, 1, 0), 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff)},
+  }
+  for _,test := range tests {
+    if _, err := ReadBinaryMatrix(bytes.NewReader(test.data)); err != io.ErrUnexpectedEOF && err != io.EOF {
+      t.Errorf("%s: error %v, want an unexpected end of file", test.name, err)
+    }
+  }
+}
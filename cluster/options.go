@@ -0,0 +1,27 @@
+package cluster
+
+import (
+  "math/rand"
+)
+
+// Options controls how entries are compared against templates during
+// clustering and classification
+type Options struct {
+  // weight of the url pattern distance in the combined distance, from 0
+  // (dom merge score only) to 1 (url pattern only)
+  UriWeight float64
+
+  // source of randomness for picking template seeds. nil uses the global
+  // math/rand source
+  Rand *rand.Rand
+
+  // if set, called as DoCluster progresses, see Progress. RunStats.Observe
+  // collects a summary of the run
+  Progress func(*Progress)
+}
+
+func DefaultOptions() *Options {
+  return &Options{
+    UriWeight: 0,
+  }
+}
@@ -0,0 +1,229 @@
+package cluster
+
+import (
+  "encoding/json"
+  "fmt"
+  "io"
+  "sort"
+  "time"
+
+  "github.com/predictive-edge/dom-cluster/dom"
+)
+
+// kinds of Progress event
+const (
+  ProgressSeed = "seed" // a template was seeded from a page
+  ProgressMerge = "merge" // a page was merged with the current template
+  ProgressPass = "pass" // a pass over the remaining pages ended
+  ProgressTemplate = "template" // the current template is complete
+  ProgressDone = "done" // the run ended, see Err
+)
+
+// Progress is a snapshot of a DoCluster run, passed to Options.Progress
+type Progress struct {
+  Event string
+  Templates int // created so far, the current one included
+  Remaining int // pages not in any template yet
+  Merges int // attempted so far
+  Template *Template // the current template
+  Pass int // over the remaining pages for the current template, from 1
+  PassTime time.Duration // for pass events, how long the pass took
+  Elapsed time.Duration // since the run started
+
+  // for seed and merge events, the page and for merges its distance to the
+  // template and whether it was added
+  Entry *dom.Entry
+  Score float64
+  Added bool
+
+  Err error // for done events, why the run stopped early if it did
+}
+
+func (p *Progress) String() string {
+  return fmt.Sprintf("%s: %d templates, %d pages remaining, %d merges, pass %d, %s elapsed",
+  p.Event, p.Templates, p.Remaining, p.Merges, p.Pass, p.Elapsed.Round(time.Millisecond))
+}
+
+// keeps the state of a run reported to a Progress hook. a nil fn reports
+// nothing
+type progressReporter struct {
+  fn func(*Progress)
+  p Progress
+  start, passStart time.Time
+}
+
+func newProgressReporter(fn func(*Progress)) *progressReporter {
+  return &progressReporter{
+    fn: fn,
+    start: time.Now(),
+  }
+}
+
+func (r *progressReporter) report(event string) {
+  if r.fn == nil {
+    return
+  }
+  r.p.Event = event
+  r.p.Elapsed = time.Since(r.start)
+  p := r.p
+  r.fn(&p)
+}
+
+func (r *progressReporter) seed(t *Template, entry *dom.Entry, remaining int) {
+  r.p.Templates++
+  r.p.Template = t
+  r.p.Pass = 0
+  r.p.Remaining = remaining
+  r.p.Entry = entry
+  r.p.Score, r.p.Added = 0, true
+  r.report(ProgressSeed)
+}
+
+func (r *progressReporter) startPass() {
+  r.p.Pass++
+  r.passStart = time.Now()
+}
+
+func (r *progressReporter) merge(entry *dom.Entry, score float64, added bool, remaining int) {
+  r.p.Merges++
+  r.p.Remaining = remaining
+  r.p.Entry = entry
+  r.p.Score, r.p.Added = score, added
+  r.report(ProgressMerge)
+}
+
+func (r *progressReporter) endPass(remaining int) {
+  r.p.Remaining = remaining
+  r.p.Entry = nil
+  r.p.PassTime = time.Since(r.passStart)
+  r.report(ProgressPass)
+  r.p.PassTime = 0
+}
+
+func (r *progressReporter) template(remaining int) {
+  r.p.Remaining = remaining
+  r.report(ProgressTemplate)
+}
+
+func (r *progressReporter) done(remaining int, err error) {
+  r.p.Remaining = remaining
+  r.p.Entry = nil
+  r.p.Err = err
+  r.report(ProgressDone)
+}
+
+// number of pages listed in RunStats.LargestPages
+const RunStatsLargestPages = 10
+
+// converts a duration to fractional milliseconds for json
+func millis(d time.Duration) float64 {
+  return float64(d) / float64(time.Millisecond)
+}
+
+// TemplateRunStats describes how one template was built
+type TemplateRunStats struct {
+  BaseUri string `json:"baseUri"`
+  Pages int `json:"pages"`
+  Merges int `json:"merges"`
+  PassMs []float64 `json:"passMs"` // time taken by each pass
+  ElapsedMs float64 `json:"elapsedMs"`
+}
+
+// PageSize is a page and its node count
+type PageSize struct {
+  Uri string `json:"uri"`
+  Nodes int `json:"nodes"`
+}
+
+/*
+RunStats summarizes a DoCluster run. Create it with NewRunStats and have it
+observe the run by calling Observe from Options.Progress. The score
+histograms are binned like TemplateStats.Histogram, one over every merge
+attempted and one over the merges that added a page.
+*/
+type RunStats struct {
+  Pages int `json:"pages"`
+  Templates int `json:"templates"`
+  Remaining int `json:"remaining"` // pages left out by a canceled run
+  Merges int `json:"merges"` // attempted
+  Added int `json:"added"` // merges within MergeScoreCutoff
+  Passes int `json:"passes"`
+  ElapsedMs float64 `json:"elapsedMs"`
+  Error string `json:"error,omitempty"` // why the run stopped early
+  ScoreHistogram []int `json:"scoreHistogram"`
+  AddedScoreHistogram []int `json:"addedScoreHistogram"`
+  PerTemplate []*TemplateRunStats `json:"perTemplate"`
+  // of the pages as clustered, i.e. wrapped
+  LargestPages []*PageSize `json:"largestPages"`
+
+  cur *TemplateRunStats
+  curStart time.Duration
+}
+
+// NewRunStats prepares the summary of a run over entries
+func NewRunStats(entries []*dom.Entry) *RunStats {
+  s := &RunStats{
+    Pages: len(entries),
+    ScoreHistogram: make([]int, DriftHistogramBins),
+    AddedScoreHistogram: make([]int, DriftHistogramBins),
+    PerTemplate: []*TemplateRunStats{},
+    LargestPages: []*PageSize{},
+  }
+  for _,entry := range entries {
+    s.LargestPages = append(s.LargestPages, &PageSize{
+      Uri: entry.Uri,
+      Nodes: entry.Dom.NodeCount(),
+    })
+  }
+  sort.SliceStable(s.LargestPages, func(i, j int) bool {
+    return s.LargestPages[i].Nodes > s.LargestPages[j].Nodes
+  })
+  if len(s.LargestPages) > RunStatsLargestPages {
+    s.LargestPages = s.LargestPages[:RunStatsLargestPages]
+  }
+  return s
+}
+
+// Observe records a progress event of the run
+func (s *RunStats) Observe(p *Progress) {
+  switch p.Event {
+  case ProgressSeed:
+    s.cur = &TemplateRunStats{
+      BaseUri: p.Entry.Uri,
+      Pages: 1,
+      PassMs: []float64{},
+    }
+    s.curStart = p.Elapsed
+    s.PerTemplate = append(s.PerTemplate, s.cur)
+  case ProgressMerge:
+    s.cur.Merges++
+    s.ScoreHistogram[histogramBin(p.Score)]++
+    if p.Added {
+      s.Added++
+      s.cur.Pages++
+      s.AddedScoreHistogram[histogramBin(p.Score)]++
+    }
+  case ProgressPass:
+    s.Passes++
+    s.cur.PassMs = append(s.cur.PassMs, millis(p.PassTime))
+  case ProgressTemplate:
+    s.cur.ElapsedMs = millis(p.Elapsed - s.curStart)
+  case ProgressDone:
+    if s.cur != nil && s.cur.ElapsedMs == 0 {
+      s.cur.ElapsedMs = millis(p.Elapsed - s.curStart)
+    }
+    s.Templates = p.Templates
+    s.Remaining = p.Remaining
+    s.Merges = p.Merges
+    s.ElapsedMs = millis(p.Elapsed)
+    if p.Err != nil {
+      s.Error = p.Err.Error()
+    }
+  }
+}
+
+func (s *RunStats) WriteJSON(w io.Writer) error {
+  enc := json.NewEncoder(w)
+  enc.SetIndent("", "  ")
+  return enc.Encode(s)
+}
@@ -0,0 +1,138 @@
+package cluster
+
+import (
+  "bytes"
+  "context"
+  "encoding/json"
+  "reflect"
+  "testing"
+)
+
+func TestNewRunStats(t *testing.T) {
+  entries := testEntries(6, 6)
+  s := NewRunStats(entries)
+  if s.Pages != 12 || len(s.LargestPages) != RunStatsLargestPages {
+    t.Fatalf("%d pages, %d largest, want 12 and %d", s.Pages, len(s.LargestPages), RunStatsLargestPages)
+  }
+  sizes := map[string]int{}
+  for _,entry := range entries {
+    sizes[entry.Uri] = entry.Dom.NodeCount()
+  }
+  for i,page := range s.LargestPages {
+    if page.Nodes != sizes[page.Uri] {
+      t.Errorf("%s: %d nodes, want %d", page.Uri, page.Nodes, sizes[page.Uri])
+    }
+    if i > 0 && page.Nodes > s.LargestPages[i-1].Nodes {
+      t.Errorf("page %d larger than the one before", i)
+    }
+  }
+  // the pages left out are no larger than those listed
+  for uri,nodes := range sizes {
+    listed := false
+    for _,page := range s.LargestPages {
+      listed = listed || page.Uri == uri
+    }
+    if !listed && nodes > s.LargestPages[len(s.LargestPages)-1].Nodes {
+      t.Errorf("%s with %d nodes left out", uri, nodes)
+    }
+  }
+
+  if s := NewRunStats(entries[:3]); len(s.LargestPages) != 3 {
+    t.Errorf("%d largest of 3 pages", len(s.LargestPages))
+  }
+  if s := NewRunStats(nil); s.Pages != 0 || len(s.LargestPages) != 0 || len(s.ScoreHistogram) != DriftHistogramBins {
+    t.Errorf("no pages: %+v", s)
+  }
+}
+
+func TestRunStats(t *testing.T) {
+  entries := testEntries(4, 4)
+  s := NewRunStats(entries)
+  events := map[string]int{}
+  last := ""
+  opts := DefaultOptions()
+  opts.Progress = func(p *Progress) {
+    events[p.Event]++
+    last = p.Event
+    s.Observe(p)
+  }
+  templates := DoClusterWithOptions(entries, opts)
+
+  if last != ProgressDone || events[ProgressDone] != 1 {
+    t.Errorf("last event %s, %d done events", last, events[ProgressDone])
+  }
+  if events[ProgressSeed] != len(templates) || events[ProgressTemplate] != len(templates) {
+    t.Errorf("%d seeds and %d template events for %d templates", events[ProgressSeed], events[ProgressTemplate], len(templates))
+  }
+  if s.Templates != len(templates) || len(s.PerTemplate) != len(templates) {
+    t.Errorf("%d templates, %d per template, want %d", s.Templates, len(s.PerTemplate), len(templates))
+  }
+  if s.Merges != events[ProgressMerge] || s.Passes != events[ProgressPass] {
+    t.Errorf("%d merges, %d passes, want %d and %d", s.Merges, s.Passes, events[ProgressMerge], events[ProgressPass])
+  }
+  if s.Added != len(entries) - len(templates) || s.Remaining != 0 || s.Error != "" {
+    t.Errorf("%d added, %d remaining, error %q", s.Added, s.Remaining, s.Error)
+  }
+
+  histogramTotal := func(histogram []int) int {
+    total := 0
+    for _,count := range histogram {
+      total += count
+    }
+    return total
+  }
+  if histogramTotal(s.ScoreHistogram) != s.Merges || histogramTotal(s.AddedScoreHistogram) != s.Added {
+    t.Errorf("histograms %v and %v", s.ScoreHistogram, s.AddedScoreHistogram)
+  }
+
+  passes := 0
+  for i,ts := range s.PerTemplate {
+    if ts.BaseUri != templates[i].BaseUri || ts.Pages != templates[i].NumPages {
+      t.Errorf("template %d: %s with %d pages, want %s with %d", i, ts.BaseUri, ts.Pages, templates[i].BaseUri, templates[i].NumPages)
+    }
+    if ts.ElapsedMs < 0 || ts.ElapsedMs > s.ElapsedMs {
+      t.Errorf("template %d: %fms of %fms", i, ts.ElapsedMs, s.ElapsedMs)
+    }
+    passes += len(ts.PassMs)
+  }
+  if passes != s.Passes {
+    t.Errorf("%d pass times, %d passes", passes, s.Passes)
+  }
+
+  buf := &bytes.Buffer{}
+  if err := s.WriteJSON(buf); err != nil {
+    t.Fatal(err)
+  }
+  var read RunStats
+  if err := json.Unmarshal(buf.Bytes(), &read); err != nil {
+    t.Fatal(err)
+  }
+  want := *s
+  want.cur, want.curStart = nil, 0
+  if !reflect.DeepEqual(&read, &want) {
+    t.Errorf("read back\n%+v\nwant\n%+v", read, want)
+  }
+}
+
+func TestRunStatsCanceled(t *testing.T) {
+  entries := testEntries(3, 3)
+  s := NewRunStats(entries)
+  opts := DefaultOptions()
+  opts.Progress = s.Observe
+  // canceled a few merges into the run
+  templates, err := DoClusterContext(&countdownContext{ Context: context.Background(), left: 3 }, entries, opts)
+  if err != context.Canceled {
+    t.Fatalf("error %v, want %v", err, context.Canceled)
+  }
+
+  pages := 0
+  for _,tpl := range templates {
+    pages += tpl.NumPages
+  }
+  if s.Error != context.Canceled.Error() || s.Remaining != len(entries) - pages || s.Remaining == 0 {
+    t.Errorf("error %q, %d remaining, want %d", s.Error, s.Remaining, len(entries) - pages)
+  }
+  if s.Templates != len(templates) || len(s.PerTemplate) != len(templates) {
+    t.Errorf("%d templates, %d per template, want %d", s.Templates, len(s.PerTemplate), len(templates))
+  }
+}
@@ -0,0 +1,71 @@
+package cluster
+
+import (
+  "github.com/predictive-edge/dom-cluster/dom"
+)
+
+// kinds of variable slot in a wrapper
+const (
+  SlotOptional = "optional" // ?, the node may be missing
+  SlotRepeated = "repeated" // + or *, the node occurs a varying number of times
+  SlotMismatch = "mismatch" // pages have differently named nodes here
+)
+
+// Slot is a place where the pages of a template differ in structure
+type Slot struct {
+  Kind string
+  Path dom.Path
+  Node *dom.Node
+  // selectors of the slot in a page. for a paren node these select its
+  // first element; empty if the slot has no element to select
+  XPath string
+  CSS string
+}
+
+func slotKind(n *dom.Node) string {
+  switch {
+  case n.NodeName == "##mismatch":
+    return SlotMismatch
+  case n.Sign.IsFixedCount():
+    return ""
+  case n.Sign.MaxCount() > 1:
+    return SlotRepeated
+  }
+  return SlotOptional
+}
+
+/*
+VariableSlots lists the slots of a wrapper in document order: nodes whose
+sign isn't a fixed count, and ##mismatch nodes. Since wrappers don't keep
+text, slots are purely structural; nothing below a mismatch is listed.
+*/
+func VariableSlots(wrapper *dom.Node) []Slot {
+  slots := []Slot{}
+  dom.Walk(wrapper, dom.Walker{
+    Pre: func(c *dom.Cursor) dom.WalkAction {
+      kind := slotKind(c.Node)
+      if kind == "" {
+        return dom.Continue
+      }
+      slot := Slot{
+        Kind: kind,
+        Path: c.Path(),
+        Node: c.Node,
+      }
+      // selectors can't point at a paren node itself
+      selectPath := append(dom.Path{}, slot.Path...)
+      for n := c.Node; n.IsParen() && len(n.Children) > 0; n = n.Children[0] {
+        selectPath = append(selectPath, 0)
+      }
+      slot.XPath, _ = wrapper.XPath(selectPath)
+      slot.CSS, _ = wrapper.CSSSelector(selectPath)
+      slots = append(slots, slot)
+
+      if kind == SlotMismatch {
+        return dom.SkipChildren
+      }
+      return dom.Continue
+    },
+  })
+  return slots
+}
@@ -0,0 +1,134 @@
+package cluster
+
+import (
+  "context"
+  "fmt"
+  "math/rand"
+  "github.com/predictive-edge/dom-cluster/dom"
+)
+
+// consensus clusters join pages that shared a template in more than this
+// fraction of runs
+const ConsensusCutoff = 0.5
+
+// Stability summarizes how consistently DoCluster groups entries across runs
+// with different seeds
+type Stability struct {
+  Runs int
+
+  // 1 - the fraction of runs in which each pair of entries shared a template,
+  // so it can be exported and clustered like any other distance matrix
+  CoAssociation *DistanceMatrix
+
+  // pages joined wherever they shared a template in a majority of runs
+  Consensus *Clustering
+
+  // per entry, how strongly it is tied to its consensus cluster, from 0
+  // (assignment varies from run to run) to 1 (always grouped the same way)
+  Scores []float64
+}
+
+/*
+ClusterStability runs DoClusterWithOptions once per seed in seed, seed+1, ...
+and accumulates how often each pair of entries lands in the same template.
+
+The consensus clustering is the single-linkage cut of the co-association
+matrix at ConsensusCutoff (evidence accumulation). An entry's score is its
+mean co-association with the rest of its consensus cluster, or for a
+singleton, 1 - its highest co-association with any other entry. There must
+be at least one run.
+*/
+func ClusterStability(entries []*dom.Entry, runs int, seed int64, opts *Options) (*Stability, error) {
+  return ClusterStabilityContext(context.Background(), entries, runs, seed, opts)
+}
+
+// ClusterStability, giving up with ctx's error once ctx is done. runs cut
+// short would skew the co-association, so a canceled run has no result
+func ClusterStabilityContext(ctx context.Context, entries []*dom.Entry, runs int, seed int64, opts *Options) (*Stability, error) {
+  if runs < 1 {
+    return nil, fmt.Errorf("%d stability runs, need at least 1", runs)
+  }
+  if opts == nil {
+    opts = DefaultOptions()
+  }
+  n := len(entries)
+  index := map[*dom.Node]int{}
+  for i,entry := range entries {
+    index[entry.Dom] = i
+  }
+
+  together := make([]int, n*(n-1)/2)
+  coassoc := newDistanceMatrix(make([]string, n), DenseMatrix)
+  for i,entry := range entries {
+    coassoc.Uris[i] = entry.Uri
+  }
+
+  for run := 0; run < runs; run++ {
+    runOpts := *opts
+    runOpts.Rand = rand.New(rand.NewSource(seed + int64(run)))
+
+    templates, err := DoClusterContext(ctx, entries, &runOpts)
+    if err != nil {
+      return nil, err
+    }
+    for _,t := range templates {
+      for a := 0; a < len(t.Included); a++ {
+        for b := a+1; b < len(t.Included); b++ {
+          i, j := index[t.Included[a]], index[t.Included[b]]
+          if i > j {
+            i, j = j, i
+          }
+          together[coassoc.triIndex(i,j)]++
+        }
+      }
+    }
+  }
+  for k,count := range together {
+    coassoc.dense[k] = 1 - float64(count)/float64(runs)
+  }
+
+  result := &Stability{
+    Runs: runs,
+    CoAssociation: coassoc,
+    // with minPts 1 every row is a core row, so dbscan reduces to the
+    // connected components of pairs within eps
+    Consensus: DBSCAN(coassoc, 1 - ConsensusCutoff - 1e-9, 1),
+    Scores: make([]float64, n),
+  }
+
+  members := result.Consensus.Members()
+  for i := 0; i < n; i++ {
+    cluster := members[result.Consensus.Labels[i]]
+    if len(cluster) == 1 {
+      maxTogether := 0.0
+      for j := 0; j < n; j++ {
+        if j != i && 1 - coassoc.At(i,j) > maxTogether {
+          maxTogether = 1 - coassoc.At(i,j)
+        }
+      }
+      result.Scores[i] = 1 - maxTogether
+      continue
+    }
+
+    sum := 0.0
+    for _,j := range cluster {
+      if j != i {
+        sum += 1 - coassoc.At(i,j)
+      }
+    }
+    result.Scores[i] = sum / float64(len(cluster)-1)
+  }
+
+  return result, nil
+}
+
+// Unstable returns the entries whose stability score is below minScore
+func (s *Stability) Unstable(minScore float64) []int {
+  unstable := []int{}
+  for i,score := range s.Scores {
+    if score < minScore {
+      unstable = append(unstable, i)
+    }
+  }
+  return unstable
+}
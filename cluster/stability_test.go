@@ -0,0 +1,60 @@
+package cluster
+
+import (
+  "context"
+  "math"
+  "testing"
+)
+
+func TestClusterStability(t *testing.T) {
+  entries := testEntries(4, 4)
+  s, err := ClusterStability(entries, 3, 1, nil)
+  if err != nil {
+    t.Fatal(err)
+  }
+  if s.Runs != 3 || s.CoAssociation.Len() != len(entries) || len(s.Scores) != len(entries) {
+    t.Fatalf("%d runs, %d rows, %d scores", s.Runs, s.CoAssociation.Len(), len(s.Scores))
+  }
+  want := []int{0, 0, 0, 0, 1, 1, 1, 1}
+  if !samePartition(s.Consensus.Labels, want) {
+    t.Errorf("consensus %v, want the partition %v", s.Consensus.Labels, want)
+  }
+  for i,score := range s.Scores {
+    if math.IsNaN(score) || score < 0 || score > 1 {
+      t.Errorf("entry %d scored %f", i, score)
+    }
+  }
+}
+
+func TestClusterStabilityRuns(t *testing.T) {
+  entries := testEntries(2, 2)
+  for _,runs := range []int{0, -1} {
+    if s, err := ClusterStability(entries, runs, 1, nil); err == nil {
+      t.Errorf("%d runs: no error, scores %v", runs, s.Scores)
+    }
+  }
+
+  s, err := ClusterStability(entries, 1, 1, nil)
+  if err != nil {
+    t.Fatal(err)
+  }
+  // a single run agrees with itself
+  for i,score := range s.Scores {
+    if score != 1 {
+      t.Errorf("entry %d scored %f after one run, want 1", i, score)
+    }
+  }
+
+  if s, err := ClusterStability(nil, 2, 1, nil); err != nil || len(s.Scores) != 0 {
+    t.Errorf("no entries: %v, %v", s, err)
+  }
+}
+
+func TestClusterStabilityCanceled(t *testing.T) {
+  entries := testEntries(2, 2)
+  ctx, cancel := context.WithCancel(context.Background())
+  cancel()
+  if s, err := ClusterStabilityContext(ctx, entries, 2, 1, nil); err != context.Canceled || s != nil {
+    t.Errorf("stability %v, error %v, want %v", s, err, context.Canceled)
+  }
+}
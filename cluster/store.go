@@ -0,0 +1,89 @@
+package cluster
+
+import (
+  "encoding/json"
+  "fmt"
+  "io"
+
+  "github.com/predictive-edge/dom-cluster/dom"
+  "github.com/predictive-edge/dom-cluster/urlpattern"
+)
+
+// version of the stored templates format, bumped on incompatible changes
+const templatesVersion = 1
+
+type storedTemplate struct {
+  Id string `json:"id"`
+  BaseUri string `json:"baseUri"`
+  Uris []string `json:"uris"`
+  NumPages int `json:"numPages"`
+  UriPattern *urlpattern.Pattern `json:"uriPattern,omitempty"`
+  Wrapper *dom.Node `json:"wrapper"`
+}
+
+type templatesFile struct {
+  Version int `json:"version"`
+  Templates []*storedTemplate `json:"templates"`
+}
+
+/*
+SaveTemplates writes templates as json, to be loaded again with
+LoadTemplates, e.g. to classify later crawls against them. Templates without
+an Id are given one ("t1", "t2", ... by position) so that results can be
+tracked across runs. The member pages' doms (Included) aren't stored.
+*/
+func SaveTemplates(w io.Writer, templates []*Template) error {
+  file := &templatesFile{
+    Version: templatesVersion,
+    Templates: []*storedTemplate{},
+  }
+  for i,t := range templates {
+    if t.Id == "" {
+      t.Id = fmt.Sprintf("t%d", i+1)
+    }
+    file.Templates = append(file.Templates, &storedTemplate{
+      Id: t.Id,
+      BaseUri: t.BaseUri,
+      Uris: t.Uris,
+      NumPages: t.NumPages,
+      UriPattern: t.UriPattern,
+      Wrapper: t.Wrapper,
+    })
+  }
+  enc := json.NewEncoder(w)
+  enc.SetIndent("", " ")
+  return enc.Encode(file)
+}
+
+// LoadTemplates reads templates written by SaveTemplates. their Included
+// is empty
+func LoadTemplates(r io.Reader) ([]*Template, error) {
+  var file templatesFile
+  if err := json.NewDecoder(r).Decode(&file); err != nil {
+    return nil, err
+  }
+  if file.Version != templatesVersion {
+    return nil, fmt.Errorf("unsupported templates version %d", file.Version)
+  }
+
+  templates := []*Template{}
+  ids := map[string]bool{}
+  for i,stored := range file.Templates {
+    if stored.Wrapper == nil {
+      return nil, fmt.Errorf("template %d has no wrapper", i)
+    }
+    if ids[stored.Id] {
+      return nil, fmt.Errorf("duplicate template id %q", stored.Id)
+    }
+    ids[stored.Id] = true
+    templates = append(templates, &Template{
+      Id: stored.Id,
+      Wrapper: stored.Wrapper,
+      NumPages: stored.NumPages,
+      BaseUri: stored.BaseUri,
+      Uris: stored.Uris,
+      UriPattern: stored.UriPattern,
+    })
+  }
+  return templates, nil
+}
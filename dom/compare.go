@@ -0,0 +1,132 @@
+package dom
+
+import (
+  "encoding/binary"
+  "hash"
+  "hash/fnv"
+  "sort"
+)
+
+// CompareOptions controls which parts of a node Equal and Hash look at.
+// node names, paren-ness, signs and the shape of the tree are always compared
+type CompareOptions struct {
+  IgnoreText bool
+  IgnoreAttrs bool
+}
+
+// Clone returns a deep copy of the tree under n. the cached metrics are
+// copied along, since they only depend on the (identical) subtree
+func (n *Node) Clone() *Node {
+  if n == nil {
+    return nil
+  }
+
+  clone := *n
+  if n.Attrs != nil {
+    clone.Attrs = make(map[string]string, len(n.Attrs))
+    for k,v := range n.Attrs {
+      clone.Attrs[k] = v
+    }
+  }
+  if n.Children != nil {
+    clone.Children = make([]*Node, len(n.Children))
+    for i,c := range n.Children {
+      clone.Children[i] = c.Clone()
+    }
+  }
+  return &clone
+}
+
+func attrsEqual(a, b map[string]string) bool {
+  if len(a) != len(b) {
+    return false
+  }
+  for k,v := range a {
+    if bv, exists := b[k]; !exists || bv != v {
+      return false
+    }
+  }
+  return true
+}
+
+/*
+Equal compares two trees structurally. Signs are compared after
+normalization, so NoSign equals 1, and a ##paren node loaded from json
+equals one built with NewParenNode. A nil opts compares everything.
+*/
+func Equal(a, b *Node, opts *CompareOptions) bool {
+  if a == nil || b == nil {
+    return a == b
+  }
+  if opts == nil {
+    opts = &CompareOptions{}
+  }
+
+  if a.NodeName != b.NodeName || a.TagName != b.TagName ||
+  a.IsParen() != b.IsParen() ||
+  a.Sign.Normalize() != b.Sign.Normalize() ||
+  len(a.Children) != len(b.Children) {
+    return false
+  }
+  if !opts.IgnoreText && a.Text != b.Text {
+    return false
+  }
+  if !opts.IgnoreAttrs && !attrsEqual(a.Attrs, b.Attrs) {
+    return false
+  }
+
+  for i := range a.Children {
+    if !Equal(a.Children[i], b.Children[i], opts) {
+      return false
+    }
+  }
+  return true
+}
+
+func (n *Node) writeHash(h hash.Hash64, opts *CompareOptions) {
+  writeString := func(s string) {
+    binary.Write(h, binary.LittleEndian, uint32(len(s)))
+    h.Write([]byte(s))
+  }
+
+  var paren uint8
+  if n.IsParen() {
+    paren = 1
+  }
+  binary.Write(h, binary.LittleEndian, paren)
+  writeString(n.NodeName)
+  writeString(n.TagName)
+  binary.Write(h, binary.LittleEndian, int64(n.Sign.Normalize()))
+
+  if !opts.IgnoreText {
+    writeString(n.Text)
+  }
+  if !opts.IgnoreAttrs {
+    keys := make([]string, 0, len(n.Attrs))
+    for k := range n.Attrs {
+      keys = append(keys, k)
+    }
+    sort.Strings(keys)
+    binary.Write(h, binary.LittleEndian, uint32(len(keys)))
+    for _,k := range keys {
+      writeString(k)
+      writeString(n.Attrs[k])
+    }
+  }
+
+  binary.Write(h, binary.LittleEndian, uint32(len(n.Children)))
+  for _,c := range n.Children {
+    c.writeHash(h, opts)
+  }
+}
+
+// Hash is a canonical hash of the tree under n, consistent with Equal: trees
+// that are Equal under opts have the same Hash under opts
+func (n *Node) Hash(opts *CompareOptions) uint64 {
+  if opts == nil {
+    opts = &CompareOptions{}
+  }
+  h := fnv.New64a()
+  n.writeHash(h, opts)
+  return h.Sum64()
+}
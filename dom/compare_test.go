@@ -0,0 +1,98 @@
+package dom
+
+import (
+  "testing"
+)
+
+func comparePage() *Node {
+  return ParseHTMLString(`<body><div id=a class=x><p>one</p><p>two</p></div><!-- c --></body>`)
+}
+
+func TestClone(t *testing.T) {
+  page := comparePage()
+  page.TreeWeight()
+  clone := page.Clone()
+  if !Equal(page, clone, nil) || page.Hash(nil) != clone.Hash(nil) {
+    t.Fatal("clone differs from the original")
+  }
+  if clone.TreeWeight() != page.TreeWeight() || clone.TreeDepth() != page.TreeDepth() {
+    t.Errorf("clone metrics %d/%d, want %d/%d", clone.TreeWeight(), clone.TreeDepth(), page.TreeWeight(), page.TreeDepth())
+  }
+
+  // nothing is shared
+  div := clone.Children[0].Children[0]
+  div.Attrs["id"] = "b"
+  div.Children[0].Children[0].Text = "changed"
+  div.SetChildren(div.Children[:1])
+  clone.InvalidateTree()
+  if got := FormatNotation(page); got != "html(body(div#a.x(p(#text) p(#text)) #comment))" {
+    t.Errorf("original changed with its clone: %s", got)
+  }
+  if page.Children[0].Children[0].Children[0].Children[0].Text != "one" {
+    t.Error("original text changed with its clone")
+  }
+  if page.TreeWeight() == clone.TreeWeight() {
+    t.Error("original metrics changed with its clone")
+  }
+
+  var nilNode *Node
+  if nilNode.Clone() != nil {
+    t.Error("clone of nil isn't nil")
+  }
+}
+
+func TestEqualAndHash(t *testing.T) {
+  tests := []struct {
+    name string
+    change func(n *Node)
+    ignoreText, ignoreAttrs bool // changes that are equal when ignoring these
+  }{
+    {"text", func(n *Node) { n.Children[0].Children[0].Children[0].Children[0].Text = "uno" }, true, false},
+    {"attr value", func(n *Node) { n.Children[0].Children[0].Attrs["id"] = "b" }, false, true},
+    {"added attr", func(n *Node) { n.Children[0].Children[0].Attrs["title"] = "" }, false, true},
+    {"comment text", func(n *Node) { n.Children[0].Children[1].Text = "other" }, true, false},
+    {"node name", func(n *Node) { n.Children[0].Children[0].NodeName = "span" }, false, false},
+    {"sign", func(n *Node) { n.Children[0].Children[0].Sign = OnePlus }, false, false},
+    {"dropped child", func(n *Node) { n.Children[0].SetChildren(n.Children[0].Children[:1]) }, false, false},
+    {"swapped children", func(n *Node) {
+      c := n.Children[0].Children
+      n.Children[0].SetChildren([]*Node{c[1], c[0]})
+    }, false, false},
+  }
+  all := &CompareOptions{}
+  noText := &CompareOptions{ IgnoreText: true }
+  noAttrs := &CompareOptions{ IgnoreAttrs: true }
+  for _,test := range tests {
+    a, b := comparePage(), comparePage()
+    test.change(b)
+    if Equal(a, b, all) || a.Hash(all) == b.Hash(all) {
+      t.Errorf("%s: equal, or same hash, after the change", test.name)
+    }
+    if got := Equal(a, b, noText); got != test.ignoreText || (a.Hash(noText) == b.Hash(noText)) != got {
+      t.Errorf("%s: equal ignoring text %t, want %t, hashes agreeing", test.name, got, test.ignoreText)
+    }
+    if got := Equal(a, b, noAttrs); got != test.ignoreAttrs || (a.Hash(noAttrs) == b.Hash(noAttrs)) != got {
+      t.Errorf("%s: equal ignoring attrs %t, want %t, hashes agreeing", test.name, got, test.ignoreAttrs)
+    }
+  }
+}
+
+func TestEqualNormalizesSigns(t *testing.T) {
+  a := &Node{ NodeName: "li", Sign: NoSign }
+  b := &Node{ NodeName: "li", Sign: 1 }
+  if !Equal(a, b, nil) || a.Hash(nil) != b.Hash(nil) {
+    t.Error("NoSign and 1 differ")
+  }
+
+  // a paren node loaded from json equals a built one
+  built := NewParenNode([]*Node{{ NodeName: "dt" }, { NodeName: "dd" }}, OnePlus)
+  loaded := &Node{ NodeName: built.NodeName, TagName: built.TagName, Sign: OnePlus,
+    Children: []*Node{{ NodeName: "dt" }, { NodeName: "dd" }} }
+  if !Equal(built, loaded, nil) || built.Hash(nil) != loaded.Hash(nil) {
+    t.Errorf("built paren %+v differs from loaded %+v", built, loaded)
+  }
+
+  if Equal(nil, a, nil) || !Equal(nil, nil, nil) {
+    t.Error("nil trees compared wrong")
+  }
+}
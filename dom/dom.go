@@ -6,16 +6,6 @@ import (
   "fmt"
 )
 
-// non-numeric values for Node.Sign
-const (
-  NoSign = iota // equivalent to 1, since Sign defaults to 0 when unmarshaling
-  OnePlus = -iota
-  ZeroPlus = -iota
-  ZeroOne = -iota
-  Fixed = -iota
-)
-
-
 type Entry struct {
   Uri string `json:"url"`
   Dom *Node `json:"dom"`
@@ -32,10 +22,15 @@ type Node struct {
 
   Children []*Node `json:"children"`
 
-  Sign int `json:"sign"`
+  Sign Sign `json:"sign"`
 
+  // memoized subtree metrics, valid while hasMetrics is set. anything that
+  // changes Children or Sign of a node must Invalidate it (and every
+  // ancestor whose metrics were already computed)
   treeDepth int
   treeWeight int
+  hasMetrics bool
+
   isParen bool
 
 }
@@ -46,7 +41,7 @@ func DefaultNode() *Node {
   }
 }
 
-func NewParenNode(children []*Node, sign int) *Node {
+func NewParenNode(children []*Node, sign Sign) *Node {
   return &Node {
     NodeName: "##paren",
     Children: children,
@@ -55,75 +50,122 @@ func NewParenNode(children []*Node, sign int) *Node {
   }
 }
 
-func (n *Node) TreeDepth() int {
-  if n.treeDepth > 0 { return n.treeDepth }
-
-  myDepth := 1 // the depth that this node counts for (0 for paren, 1 otherwise)
-  if len(n.Children) > 0 {
-    maxChildrenDepth := 0
-    for _,child := range n.Children {
-      if child.TreeDepth() > maxChildrenDepth {
-        maxChildrenDepth = child.TreeDepth()
+// IsParen reports whether n is a paren node, whether it was built with
+// NewParenNode or loaded from json as a ##paren node
+func (n *Node) IsParen() bool {
+  return n.isParen || n.NodeName == "##paren"
+}
+
+// computes depth and weight of the whole subtree in post-order, reusing any
+// memoized subtrees. every descendant ends up memoized, so that once the
+// root's metrics are computed the tree can be read concurrently
+func (n *Node) computeMetrics() {
+  if n.hasMetrics { return }
+
+  Walk(n, Walker{
+    Pre: func(c *Cursor) WalkAction {
+      if c.Node.hasMetrics {
+        return SkipChildren
       }
-    }
-    n.treeDepth = myDepth + maxChildrenDepth
-  } else {
-    n.treeDepth = myDepth
-  }
-  return n.treeDepth
+      return Continue
+    },
+    Post: func(c *Cursor) WalkAction {
+      c.Node.setMetrics()
+      return Continue
+    },
+  })
 }
 
-func (n *Node) TreeWeight() int {
-  if n.treeWeight > 0 { return n.treeWeight }
+// computes the metrics of a single node from its (memoized) children
+func (n *Node) setMetrics() {
+  if n.hasMetrics { return }
 
-  // if the sign allows this node to not exist, then its weight/alignment
-  // cost is always zero
-  if n.Sign == ZeroPlus || n.Sign == OnePlus {
-    n.treeWeight = 0
-    return 0
+  maxChildrenDepth := 0
+  childrenWeight := 0
+  for _,c := range n.Children {
+    if c.treeDepth > maxChildrenDepth {
+      maxChildrenDepth = c.treeDepth
+    }
+    childrenWeight += c.treeWeight
   }
 
+  // the depth that this node counts for
+  n.treeDepth = 1 + maxChildrenDepth
+
   myWeight := 1 // the weight that this node counts for
 
   // if this is a paren node then it itself doesn't count for any weight
-  if n.isParen {
+  if n.IsParen() {
     myWeight = 0
   }
 
   // weight = this node's weight + all child weights
-  for _,c := range n.Children {
-    myWeight += c.TreeWeight()
-  }
+  myWeight += childrenWeight
 
   // constant repeated elements have the equivalent constant-factor multiples
   // on weight
   if n.Sign > 1 {
-    myWeight *= n.Sign
+    myWeight *= int(n.Sign)
+  }
+
+  // if the sign allows this node to repeat freely, then its weight/alignment
+  // cost is always zero
+  if n.Sign == ZeroPlus || n.Sign == OnePlus {
+    myWeight = 0
   }
 
-  return myWeight
+  n.treeWeight = myWeight
+  n.hasMetrics = true
 }
 
-func (n *Node) CallPreOrder(fn func(*Node)) {
-  fn(n)
-  for _,child := range n.Children {
-    child.CallPreOrder(fn)
+func (n *Node) TreeDepth() int {
+  n.computeMetrics()
+  return n.treeDepth
+}
+
+func (n *Node) TreeWeight() int {
+  n.computeMetrics()
+  return n.treeWeight
+}
+
+// Invalidate drops the memoized metrics of n, which must be done after
+// changing its Children or Sign. ancestors are not reached, so callers
+// mutating deep inside a tree should invalidate bottom-up or use
+// InvalidateTree on the root
+func (n *Node) Invalidate() {
+  n.hasMetrics = false
+}
+
+// InvalidateTree drops the memoized metrics of every node under n
+func (n *Node) InvalidateTree() {
+  n.hasMetrics = false
+  for _,c := range n.Children {
+    c.InvalidateTree()
   }
 }
 
+// SetChildren replaces the children of n, invalidating its metrics
+func (n *Node) SetChildren(children []*Node) {
+  n.Children = children
+  n.Invalidate()
+}
+
+func (n *Node) CallPreOrder(fn func(*Node)) {
+  Walk(n, Walker{
+    Pre: func(c *Cursor) WalkAction {
+      fn(c.Node)
+      return Continue
+    },
+  })
+}
+
+// like Sign.String, except that an unset sign prints as 0 as it always has
+// in node dumps
 func (n *Node) SignStr() string {
-  switch {
-  case n.Sign >= 0:
-    return fmt.Sprintf("%d",n.Sign)
-  case n.Sign == OnePlus:
-    return "+"
-  case n.Sign == ZeroPlus:
-    return "*"
-  case n.Sign == ZeroOne:
-    return "?"
-  default:
-    return "_"
+  if n.Sign == NoSign {
+    return "0"
   }
+  return n.Sign.String()
 }
 
 func (n *Node) String() string {
@@ -0,0 +1,80 @@
+package dom
+
+import (
+  "testing"
+)
+
+func leaf(name string) *Node {
+  return &Node{ NodeName: name }
+}
+
+func TestTreeMetrics(t *testing.T) {
+  root := &Node{ NodeName: "ul", Children: []*Node{leaf("li"), leaf("li")} }
+  if got := root.TreeWeight(); got != 3 {
+    t.Errorf("weight %d, want 3", got)
+  }
+  if got := root.TreeDepth(); got != 2 {
+    t.Errorf("depth %d, want 2", got)
+  }
+
+  paren := NewParenNode([]*Node{leaf("dt"), leaf("dd")}, 2)
+  if got := paren.TreeWeight(); got != 4 {
+    t.Errorf("paren weight %d, want 4", got)
+  }
+  repeated := &Node{ NodeName: "li", Sign: OnePlus, Children: []*Node{leaf("a")} }
+  if got := repeated.TreeWeight(); got != 0 {
+    t.Errorf("+ weight %d, want 0", got)
+  }
+}
+
+func TestSetChildrenInvalidates(t *testing.T) {
+  list := &Node{ NodeName: "ul", Children: []*Node{leaf("li")} }
+  root := &Node{ NodeName: "div", Children: []*Node{list} }
+  if got := root.TreeWeight(); got != 3 {
+    t.Fatalf("weight %d, want 3", got)
+  }
+
+  list.SetChildren([]*Node{leaf("li"), leaf("li"), &Node{ NodeName: "li", Children: []*Node{leaf("a")} }})
+  if got := list.TreeWeight(); got != 5 {
+    t.Errorf("weight after SetChildren %d, want 5", got)
+  }
+  if got := list.TreeDepth(); got != 3 {
+    t.Errorf("depth after SetChildren %d, want 3", got)
+  }
+  // ancestors aren't reached by SetChildren
+  if got := root.TreeWeight(); got != 3 {
+    t.Errorf("root weight %d, want the stale 3 until invalidated", got)
+  }
+  root.Invalidate()
+  if got := root.TreeWeight(); got != 6 {
+    t.Errorf("root weight after Invalidate %d, want 6", got)
+  }
+  if got := root.TreeDepth(); got != 4 {
+    t.Errorf("root depth after Invalidate %d, want 4", got)
+  }
+}
+
+func TestSignRewriteInvalidates(t *testing.T) {
+  item := &Node{ NodeName: "li", Children: []*Node{leaf("a")} }
+  root := &Node{ NodeName: "ul", Children: []*Node{item} }
+  if got := root.TreeWeight(); got != 3 {
+    t.Fatalf("weight %d, want 3", got)
+  }
+
+  item.Sign = 3
+  item.Invalidate()
+  root.Invalidate()
+  if got := item.TreeWeight(); got != 6 {
+    t.Errorf("weight of li^{3} %d, want 6", got)
+  }
+  if got := root.TreeWeight(); got != 7 {
+    t.Errorf("root weight %d, want 7", got)
+  }
+
+  // InvalidateTree reaches everything below, however deep the rewrite
+  item.Children[0].Sign = ZeroPlus
+  root.InvalidateTree()
+  if got := root.TreeWeight(); got != 4 {
+    t.Errorf("root weight after InvalidateTree %d, want 4", got)
+  }
+}
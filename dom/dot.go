@@ -0,0 +1,119 @@
+package dom
+
+import (
+  "bytes"
+  "fmt"
+  "io"
+  "strings"
+)
+
+// Label is n's node name followed by its #id and .class selectors, as in the
+// template notation but unescaped and without the sign
+func (n *Node) Label() string {
+  label := n.NodeName
+  if id,exists := n.Attrs["id"]; exists && id != "" {
+    label += "#" + id
+  }
+  if classes,exists := n.Attrs["class"]; exists {
+    for _,class := range strings.Fields(classes) {
+      label += "." + class
+    }
+  }
+  return label
+}
+
+// quotes a string as a graphviz id
+func dotQuote(str string) string {
+  str = strings.Replace(str, "\\", "\\\\", -1)
+  str = strings.Replace(str, "\"", "\\\"", -1)
+  str = strings.Replace(str, "\n", "\\n", -1)
+  return "\"" + str + "\""
+}
+
+/*
+DOTGraph accumulates the graphviz source of a directed graph of trees. Nodes
+are drawn as boxes labeled with their selectors, paren nodes as dashed
+circles and ##mismatch nodes (from merging differently named nodes) filled
+red. Signs other than 1 label the edge into their node.
+*/
+type DOTGraph struct {
+  buf bytes.Buffer
+  nextId int
+}
+
+func NewDOTGraph(name string) *DOTGraph {
+  g := &DOTGraph{}
+  g.Line("digraph %s {", dotQuote(name))
+  g.Line("node [shape=box, style=rounded, fontname=\"monospace\"];")
+  g.Line("edge [fontname=\"monospace\"];")
+  return g
+}
+
+// Line adds a line of graphviz source, e.g. a graph attribute or an edge
+func (g *DOTGraph) Line(format string, args ...interface{}) {
+  fmt.Fprintf(&g.buf, format, args...)
+  g.buf.WriteString("\n")
+}
+
+// Quote quotes a string for use in Line
+func (g *DOTGraph) Quote(str string) string {
+  return dotQuote(str)
+}
+
+func nodeDOTAttrs(n *Node) string {
+  switch {
+  case n.IsParen():
+    return "label=\"( )\", shape=circle, style=dashed"
+  case n.NodeName == "##mismatch":
+    return "label=\"##mismatch\", style=\"rounded,filled\", fillcolor=lightcoral"
+  }
+  return "label=" + dotQuote(n.Label())
+}
+
+/*
+Tree adds the tree under n, returning the graphviz id given to each of its
+nodes. If style is non-nil, the attributes it returns for a node (e.g.
+`fillcolor=green`) are added to that node's.
+*/
+func (g *DOTGraph) Tree(n *Node, style func(*Node) string) map[*Node]string {
+  ids := map[*Node]string{}
+  Walk(n, Walker{
+    Pre: func(c *Cursor) WalkAction {
+      id := fmt.Sprintf("n%d", g.nextId)
+      g.nextId++
+      ids[c.Node] = id
+
+      attrs := nodeDOTAttrs(c.Node)
+      if style != nil {
+        if extra := style(c.Node); extra != "" {
+          attrs += ", " + extra
+        }
+      }
+      g.Line("%s [%s];", id, attrs)
+
+      if parent := c.Parent(); parent != nil {
+        if sign := c.Node.Sign.Normalize(); sign != 1 {
+          g.Line("%s -> %s [label=%s];", ids[parent], id, dotQuote(sign.String()))
+        } else {
+          g.Line("%s -> %s;", ids[parent], id)
+        }
+      }
+      return Continue
+    },
+  })
+  return ids
+}
+
+// WriteTo closes the graph and writes its source to w
+func (g *DOTGraph) WriteTo(w io.Writer) (int64, error) {
+  g.Line("}")
+  return g.buf.WriteTo(w)
+}
+
+// WriteDOT writes the tree under n, usually a wrapper, as a graphviz graph
+func WriteDOT(w io.Writer, n *Node) error {
+  g := NewDOTGraph("wrapper")
+  g.Tree(n, nil)
+  _,err := g.WriteTo(w)
+  return err
+}
@@ -0,0 +1,84 @@
+package dom
+
+import (
+  "bytes"
+  "strings"
+  "testing"
+)
+
+func TestWriteDOT(t *testing.T) {
+  ul := &Node{
+    NodeName: "ul",
+    Attrs: map[string]string{ "id": "menu", "class": "nav  top" },
+    Sign: 1,
+    Children: []*Node{
+      { NodeName: "li", Sign: OnePlus },
+      { NodeName: "li", Sign: ZeroOne },
+      { NodeName: "li", Sign: 2 },
+      NewParenNode([]*Node{leaf("dt"), leaf("dd")}, ZeroPlus),
+    },
+  }
+  root := &Node{
+    NodeName: "body",
+    Children: []*Node{ul, { NodeName: "##mismatch", Sign: 1 }},
+  }
+
+  buf := &bytes.Buffer{}
+  if err := WriteDOT(buf, root); err != nil {
+    t.Fatal(err)
+  }
+  got := buf.String()
+  // ids are given in pre-order
+  want := []string{
+    `digraph "wrapper" {`,
+    `n0 [label="body"];`,
+    `n1 [label="ul#menu.nav.top"];`,
+    `n0 -> n1;`,
+    `n2 [label="li"];`,
+    `n1 -> n2 [label="+"];`,
+    `n1 -> n3 [label="?"];`,
+    `n1 -> n4 [label="2"];`,
+    `n5 [label="( )", shape=circle, style=dashed];`,
+    `n1 -> n5 [label="*"];`,
+    `n6 [label="dt"];`,
+    `n5 -> n6;`,
+    `n8 [label="##mismatch", style="rounded,filled", fillcolor=lightcoral];`,
+    `n0 -> n8;`,
+  }
+  for _,line := range want {
+    if !strings.Contains(got, "\n" + line + "\n") && !strings.HasPrefix(got, line + "\n") {
+      t.Errorf("no line %s in\n%s", line, got)
+    }
+  }
+  if !strings.HasSuffix(got, "\n}\n") {
+    t.Errorf("graph not closed:\n%s", got)
+  }
+}
+
+func TestDOTQuote(t *testing.T) {
+  tests := []struct {
+    in, want string
+  }{
+    {`div`, `"div"`},
+    {`a"b`, `"a\"b"`},
+    {`a\b`, `"a\\b"`},
+    {`a\"b`, `"a\\\"b"`},
+    {"a\nb", `"a\nb"`},
+  }
+  for _,test := range tests {
+    if got := dotQuote(test.in); got != test.want {
+      t.Errorf("dotQuote(%q) = %s, want %s", test.in, got, test.want)
+    }
+  }
+
+  // attribute values end up quoted in labels
+  n := &Node{
+    NodeName: "div",
+    Attrs: map[string]string{ "id": `x"y`, "class": `a\b` },
+  }
+  buf := &bytes.Buffer{}
+  WriteDOT(buf, n)
+  if want := `n0 [label="div#x\"y.a\\b"];`; !strings.Contains(buf.String(), want) {
+    t.Errorf("no %s in\n%s", want, buf.String())
+  }
+}
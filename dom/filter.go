@@ -0,0 +1,246 @@
+package dom
+
+import (
+  "bytes"
+  "encoding/json"
+  "io"
+  "io/ioutil"
+  "sort"
+  "strconv"
+  "strings"
+)
+
+// reasons a node can be dropped by Filter, as used in FilterStats
+const (
+  DropTag = "tag"
+  DropAttr = "attr"
+  DropClass = "class"
+  DropWhitespace = "whitespace"
+  DropHidden = "hidden"
+  DropPixel = "pixel"
+)
+
+// AttrRule drops nodes whose Attr attribute contains any of Contains (case
+// insensitively), or that have the attribute at all if Contains is empty.
+// an empty Tag matches every node
+type AttrRule struct {
+  Tag string `json:"tag,omitempty"`
+  Attr string `json:"attr"`
+  Contains []string `json:"contains,omitempty"`
+}
+
+// FilterConfig selects what Filter strips from a page before it is wrapped
+type FilterConfig struct {
+  DenyTags []string `json:"denyTags"` // node names dropped along with their subtree
+  DenyAttrs []AttrRule `json:"denyAttrs"`
+  DenyClasses []string `json:"denyClasses"` // elements with any of these classes are dropped
+  PruneWhitespaceText bool `json:"pruneWhitespaceText"` // drop #text nodes that are only whitespace
+  RemoveHidden bool `json:"removeHidden"` // drop hidden, aria-hidden and display:none elements
+  RemoveTrackingPixels bool `json:"removeTrackingPixels"` // drop images of at most 1x1 pixels
+}
+
+func DefaultFilterConfig() *FilterConfig {
+  return &FilterConfig{
+    DenyTags: []string{"script", "style", "noscript", "template", "#comment"},
+    DenyAttrs: []AttrRule{
+      {
+        Tag: "iframe",
+        Attr: "src",
+        Contains: []string{"doubleclick.net", "googlesyndication.com",
+          "adservice.", "amazon-adsystem.com", "/ads/"},
+      },
+      {
+        Attr: "id",
+        Contains: []string{"google_ads_"},
+      },
+    },
+    DenyClasses: []string{},
+    PruneWhitespaceText: true,
+    RemoveHidden: true,
+    RemoveTrackingPixels: true,
+  }
+}
+
+/*
+ReadFilterConfig reads a json filter config such as
+
+  {"denyTags": ["script", "style"], "denyClasses": ["ad-banner"]}
+
+Fields missing from the json keep their DefaultFilterConfig value, and lists
+that are given replace the default ones rather than adding to them.
+*/
+func ReadFilterConfig(r io.Reader) (*FilterConfig, error) {
+  data, err := ioutil.ReadAll(r)
+  if err != nil {
+    return nil, err
+  }
+  var given map[string]json.RawMessage
+  if err := json.Unmarshal(data, &given); err != nil {
+    return nil, err
+  }
+
+  cfg := DefaultFilterConfig()
+  // otherwise json would decode each given rule over the default one at its
+  // index, mixing the two
+  if _,exists := given["denyTags"]; exists {
+    cfg.DenyTags = nil
+  }
+  if _,exists := given["denyAttrs"]; exists {
+    cfg.DenyAttrs = nil
+  }
+  if _,exists := given["denyClasses"]; exists {
+    cfg.DenyClasses = nil
+  }
+  dec := json.NewDecoder(bytes.NewReader(data))
+  dec.DisallowUnknownFields()
+  if err := dec.Decode(cfg); err != nil {
+    return nil, err
+  }
+  return cfg, nil
+}
+
+// FilterStats reports how much of a page Filter dropped
+type FilterStats struct {
+  Before int // nodes in the page before filtering
+  After int
+  Dropped map[string]int // nodes dropped (subtrees included) per reason
+}
+
+// DroppedFraction of the page's nodes that were filtered out
+func (s FilterStats) DroppedFraction() float64 {
+  if s.Before == 0 {
+    return 0
+  }
+  return float64(s.Before - s.After) / float64(s.Before)
+}
+
+func (s FilterStats) String() string {
+  reasons := []string{}
+  for reason := range s.Dropped {
+    reasons = append(reasons, reason)
+  }
+  sort.Strings(reasons)
+  parts := []string{}
+  for _,reason := range reasons {
+    parts = append(parts, reason + "=" + strconv.Itoa(s.Dropped[reason]))
+  }
+  return strconv.Itoa(s.Before - s.After) + "/" + strconv.Itoa(s.Before) +
+  " dropped (" + strings.Join(parts, " ") + ")"
+}
+
+// counts the nodes under n, n included
+func (n *Node) NodeCount() int {
+  count := 0
+  n.CallPreOrder(func(*Node) {
+    count++
+  })
+  return count
+}
+
+func isHidden(n *Node) bool {
+  if _,exists := n.Attrs["hidden"]; exists {
+    return true
+  }
+  if strings.EqualFold(n.Attrs["aria-hidden"], "true") {
+    return true
+  }
+  if strings.EqualFold(n.NodeName, "input") && strings.EqualFold(n.Attrs["type"], "hidden") {
+    return true
+  }
+  style := strings.ToLower(strings.Replace(n.Attrs["style"], " ", "", -1))
+  return strings.Contains(style, "display:none") ||
+  strings.Contains(style, "visibility:hidden")
+}
+
+// parses a pixel dimension such as "1" or "1px", returning -1 if it isn't one
+func pixelSize(value string) int {
+  value = strings.TrimSuffix(strings.TrimSpace(strings.ToLower(value)), "px")
+  size, err := strconv.Atoi(value)
+  if err != nil {
+    return -1
+  }
+  return size
+}
+
+func isTrackingPixel(n *Node) bool {
+  if !strings.EqualFold(n.NodeName, "img") {
+    return false
+  }
+  width, height := pixelSize(n.Attrs["width"]), pixelSize(n.Attrs["height"])
+  return width >= 0 && width <= 1 && height >= 0 && height <= 1
+}
+
+// the reason n should be dropped, or "" to keep it
+func (cfg *FilterConfig) dropReason(n *Node) string {
+  for _,tag := range cfg.DenyTags {
+    if strings.EqualFold(n.NodeName, tag) {
+      return DropTag
+    }
+  }
+  for _,rule := range cfg.DenyAttrs {
+    if rule.Tag != "" && !strings.EqualFold(n.NodeName, rule.Tag) { continue }
+    value, exists := n.Attrs[rule.Attr]
+    if !exists { continue }
+    if len(rule.Contains) == 0 {
+      return DropAttr
+    }
+    for _,needle := range rule.Contains {
+      if strings.Contains(strings.ToLower(value), strings.ToLower(needle)) {
+        return DropAttr
+      }
+    }
+  }
+  if len(cfg.DenyClasses) > 0 {
+    for _,class := range strings.Fields(n.Attrs["class"]) {
+      for _,denied := range cfg.DenyClasses {
+        if class == denied {
+          return DropClass
+        }
+      }
+    }
+  }
+  if cfg.PruneWhitespaceText && n.NodeName == "#text" &&
+  strings.TrimSpace(n.Text) == "" {
+    return DropWhitespace
+  }
+  if cfg.RemoveHidden && isHidden(n) {
+    return DropHidden
+  }
+  if cfg.RemoveTrackingPixels && isTrackingPixel(n) {
+    return DropPixel
+  }
+  return ""
+}
+
+// Filter strips noise from the page under n in place, according to cfg. it
+// is meant to run before NodeToWrapper; the root itself is never dropped
+func Filter(n *Node, cfg *FilterConfig) FilterStats {
+  stats := FilterStats{
+    Before: n.NodeCount(),
+    Dropped: map[string]int{},
+  }
+
+  Walk(n, Walker{
+    Pre: func(c *Cursor) WalkAction {
+      kept := []*Node{}
+      dropped := false
+      for _,child := range c.Node.Children {
+        if reason := cfg.dropReason(child); reason != "" {
+          stats.Dropped[reason] += child.NodeCount()
+          dropped = true
+        } else {
+          kept = append(kept, child)
+        }
+      }
+      if dropped {
+        c.Node.SetChildren(kept)
+      }
+      return Continue
+    },
+  })
+
+  // ancestors of anything dropped have stale metrics
+  n.InvalidateTree()
+  stats.After = n.NodeCount()
+  return stats
+}
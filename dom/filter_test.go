@@ -0,0 +1,111 @@
+package dom
+
+import (
+  "strings"
+  "testing"
+)
+
+func TestFilter(t *testing.T) {
+  tests := []struct {
+    name, src, want string
+    dropped map[string]int
+  }{
+    {"deny tags", `<div>a<script>x()</script><style>p{}</style><!-- c --><noscript>n</noscript></div>`,
+      `html(div("a"))`, map[string]int{DropTag: 7}},
+    {"ad iframe", `<div><iframe src="https://ad.doubleclick.net/x"></iframe><iframe src="/video"></iframe></div>`,
+      `html(div(iframe[src="/video"]))`, map[string]int{DropAttr: 1}},
+    {"ad id on any tag", `<div id="google_ads_1"><p>ad</p></div><p>x</p>`,
+      `html(p("x"))`, map[string]int{DropAttr: 3}},
+    {"whitespace", "<ul>\n  <li>a</li>\n  <li> </li>\n</ul>",
+      `html(ul(li("a") li))`, map[string]int{DropWhitespace: 4}},
+    {"hidden", `<div hidden>a</div><div aria-hidden="TRUE">b</div><input type=hidden name=t><p style="display: none">c</p><p>d</p>`,
+      `html(p("d"))`, map[string]int{DropHidden: 7}},
+    {"tracking pixel", `<img src=t.gif width=1 height="1px"><img src=a.png width=100 height=1><img src=b.png>`,
+      `html(img[height="1" src="a.png" width="100"] img[src="b.png"])`, map[string]int{DropPixel: 1}},
+    {"nothing to drop", `<p>a<b>b</b></p>`, `html(p("a" b("b")))`, map[string]int{}},
+  }
+  for _,test := range tests {
+    root := ParseHTMLString(test.src)
+    stats := Filter(root, DefaultFilterConfig())
+    if got := dumpHTML(root); got != test.want {
+      t.Errorf("%s: got %s, want %s", test.name, got, test.want)
+    }
+    if len(stats.Dropped) != len(test.dropped) {
+      t.Errorf("%s: dropped %v, want %v", test.name, stats.Dropped, test.dropped)
+    }
+    for reason,count := range test.dropped {
+      if stats.Dropped[reason] != count {
+        t.Errorf("%s: dropped %v, want %v", test.name, stats.Dropped, test.dropped)
+      }
+    }
+    if stats.Before - stats.After != stats.Dropped[DropTag] + stats.Dropped[DropAttr] +
+    stats.Dropped[DropWhitespace] + stats.Dropped[DropHidden] + stats.Dropped[DropPixel] {
+      t.Errorf("%s: %s doesn't add up", test.name, stats)
+    }
+  }
+}
+
+// trees built by hand or read from json may keep the names' case
+func TestFilterUppercaseNames(t *testing.T) {
+  root := &Node{ NodeName: "BODY", Children: []*Node{
+    &Node{ NodeName: "INPUT", Attrs: map[string]string{"type": "HIDDEN"} },
+    &Node{ NodeName: "Input", Attrs: map[string]string{"type": "text"} },
+    &Node{ NodeName: "IMG", Attrs: map[string]string{"width": "1", "height": "1"} },
+    &Node{ NodeName: "Img", Attrs: map[string]string{"width": "1", "height": "0px"} },
+    &Node{ NodeName: "IMG", Attrs: map[string]string{"width": "100", "height": "1"} },
+  } }
+  stats := Filter(root, DefaultFilterConfig())
+  names := []string{}
+  for _,c := range root.Children {
+    names = append(names, c.NodeName)
+  }
+  if got := strings.Join(names, " "); got != "Input IMG" {
+    t.Errorf("kept %s, want Input IMG", got)
+  }
+  if stats.Dropped[DropHidden] != 1 || stats.Dropped[DropPixel] != 2 {
+    t.Errorf("dropped %v, want 1 hidden and 2 pixel", stats.Dropped)
+  }
+}
+
+func TestFilterInvalidatesMetrics(t *testing.T) {
+  root := ParseHTMLString(`<div><p>a</p><script>x()</script></div>`)
+  if got := root.TreeWeight(); got != 6 {
+    t.Fatalf("weight %d, want 6", got)
+  }
+  Filter(root, DefaultFilterConfig())
+  if got := root.TreeWeight(); got != 4 {
+    t.Errorf("weight after filtering %d, want 4", got)
+  }
+}
+
+func TestReadFilterConfig(t *testing.T) {
+  cfg, err := ReadFilterConfig(strings.NewReader(`{
+    "denyTags": ["aside"],
+    "denyAttrs": [{"attr": "data-ad"}],
+    "denyClasses": ["promo", "cookie-banner"],
+    "removeHidden": false
+  }`))
+  if err != nil {
+    t.Fatal(err)
+  }
+  if !cfg.PruneWhitespaceText || !cfg.RemoveTrackingPixels {
+    t.Errorf("missing fields didn't keep their defaults: %+v", cfg)
+  }
+
+  root := ParseHTMLString(`<div><aside>a</aside><script>s</script><p data-ad>b</p>` +
+  `<p class="x promo">c</p><p class="promotion">d</p><p hidden>e</p></div>`)
+  stats := Filter(root, cfg)
+  want := `html(div(script("s") p[class="promotion"]("d") p[hidden=""]("e")))`
+  if got := dumpHTML(root); got != want {
+    t.Errorf("got %s, want %s", got, want)
+  }
+  if stats.Dropped[DropClass] != 2 || stats.Dropped[DropTag] != 2 || stats.Dropped[DropAttr] != 2 {
+    t.Errorf("dropped %v", stats.Dropped)
+  }
+
+  for _,bad := range []string{`{"denyTag": ["aside"]}`, `{"denyTags": "aside"}`, `[`} {
+    if _, err := ReadFilterConfig(strings.NewReader(bad)); err == nil {
+      t.Errorf("%s: no error", bad)
+    }
+  }
+}
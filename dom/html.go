@@ -0,0 +1,348 @@
+package dom
+
+import (
+  "html"
+  "io"
+  "io/ioutil"
+  "strings"
+)
+
+// elements that never have children or an end tag
+var voidElements = map[string]bool{
+  "area": true, "base": true, "br": true, "col": true, "embed": true,
+  "hr": true, "img": true, "input": true, "link": true, "meta": true,
+  "param": true, "source": true, "track": true, "wbr": true,
+}
+
+// elements whose content is text up to their end tag, and whether entities
+// in that text are decoded
+var rawTextElements = map[string]bool{
+  "script": false, "style": false, "textarea": true, "title": true,
+}
+
+// block elements that implicitly close an open <p>
+var closesParagraph = map[string]bool{
+  "address": true, "article": true, "aside": true, "blockquote": true,
+  "div": true, "dl": true, "fieldset": true, "footer": true, "form": true,
+  "h1": true, "h2": true, "h3": true, "h4": true, "h5": true, "h6": true,
+  "header": true, "hr": true, "main": true, "nav": true, "ol": true,
+  "p": true, "pre": true, "section": true, "table": true, "ul": true,
+}
+
+// for an opening tag, the open elements it implicitly closes
+var impliedEndTags = map[string][]string{
+  "li": {"li"},
+  "dt": {"dt", "dd"},
+  "dd": {"dt", "dd"},
+  "tr": {"tr", "td", "th"},
+  "td": {"td", "th"},
+  "th": {"td", "th"},
+  "option": {"option"},
+  "thead": {"thead", "tbody", "tr", "td", "th"},
+  "tbody": {"thead", "tbody", "tr", "td", "th"},
+}
+
+type htmlParser struct {
+  src string
+  pos int
+  stack []*Node
+}
+
+func (p *htmlParser) top() *Node {
+  return p.stack[len(p.stack)-1]
+}
+
+func (p *htmlParser) appendChild(n *Node) {
+  parent := p.top()
+  parent.Children = append(parent.Children, n)
+}
+
+func (p *htmlParser) appendText(text string) {
+  if text == "" {
+    return
+  }
+  // adjacent text (e.g. around a dropped tag) is merged like in the dom
+  parent := p.top()
+  if l := len(parent.Children); l > 0 && parent.Children[l-1].NodeName == "#text" {
+    parent.Children[l-1].Text += text
+    return
+  }
+  p.appendChild(&Node{
+    NodeName: "#text",
+    Text: text,
+    Sign: 1,
+  })
+}
+
+// pops open elements up to and including the innermost one named name, if
+// there is one. the root is never popped
+func (p *htmlParser) closeElement(name string) {
+  for i := len(p.stack)-1; i > 0; i-- {
+    if p.stack[i].NodeName == name {
+      p.stack = p.stack[:i]
+      return
+    }
+  }
+}
+
+func isNameChar(c byte) bool {
+  return c != ' ' && c != '\t' && c != '\n' && c != '\r' && c != '\f' &&
+  c != '/' && c != '>' && c != '=' && c != '<'
+}
+
+func (p *htmlParser) skipSpace() {
+  for p.pos < len(p.src) && strings.IndexByte(" \t\n\r\f", p.src[p.pos]) >= 0 {
+    p.pos++
+  }
+}
+
+// parses the attributes of a start tag, up to and including its '>'. returns
+// whether the tag was self-closing
+func (p *htmlParser) attributes() (map[string]string, bool) {
+  attrs := map[string]string{}
+  for {
+    p.skipSpace()
+    if p.pos >= len(p.src) {
+      return attrs, false
+    }
+    switch p.src[p.pos] {
+    case '>':
+      p.pos++
+      return attrs, false
+    case '/':
+      p.pos++
+      if p.pos < len(p.src) && p.src[p.pos] == '>' {
+        p.pos++
+        return attrs, true
+      }
+      continue
+    }
+
+    start := p.pos
+    for p.pos < len(p.src) && isNameChar(p.src[p.pos]) {
+      p.pos++
+    }
+    name := strings.ToLower(p.src[start:p.pos])
+    if name == "" {
+      // stray character, e.g. a lone '='
+      p.pos++
+      continue
+    }
+
+    value := ""
+    p.skipSpace()
+    if p.pos < len(p.src) && p.src[p.pos] == '=' {
+      p.pos++
+      p.skipSpace()
+      if p.pos < len(p.src) && (p.src[p.pos] == '"' || p.src[p.pos] == '\'') {
+        quote := p.src[p.pos]
+        end := strings.IndexByte(p.src[p.pos+1:], quote)
+        if end < 0 {
+          end = len(p.src) - p.pos - 1
+        }
+        value = p.src[p.pos+1:p.pos+1+end]
+        p.pos += end + 2
+        if p.pos > len(p.src) {
+          p.pos = len(p.src)
+        }
+      } else {
+        start := p.pos
+        for p.pos < len(p.src) && strings.IndexByte(" \t\n\r\f>", p.src[p.pos]) < 0 {
+          p.pos++
+        }
+        value = p.src[start:p.pos]
+      }
+    }
+    if _,exists := attrs[name]; !exists {
+      attrs[name] = html.UnescapeString(value)
+    }
+  }
+}
+
+// reports whether s starts with the end tag name, ascii case-insensitively.
+// name is lowercase, and must not be followed by more of a tag name
+func hasEndTagName(s, name string) bool {
+  if len(s) < len(name) {
+    return false
+  }
+  for i := 0; i < len(name); i++ {
+    c := s[i]
+    if c >= 'A' && c <= 'Z' {
+      c += 'a' - 'A'
+    }
+    if c != name[i] {
+      return false
+    }
+  }
+  return len(s) == len(name) || !isNameChar(s[len(name)])
+}
+
+// reads the content of a raw text element up to its end tag. the end tag is
+// matched on the original bytes, since lowercasing may change the length of
+// the text before it
+func (p *htmlParser) rawText(name string, decode bool) {
+  end := len(p.src)
+  for i := p.pos; ; i += 2 {
+    next := strings.Index(p.src[i:], "</")
+    if next < 0 {
+      break
+    }
+    i += next
+    if hasEndTagName(p.src[i+2:], name) {
+      end = i
+      break
+    }
+  }
+  text := p.src[p.pos:end]
+  if decode {
+    text = html.UnescapeString(text)
+  }
+  p.appendText(text)
+  p.pos = end
+}
+
+func (p *htmlParser) startTag(name string) {
+  attrs, selfClosing := p.attributes()
+
+  if closesParagraph[name] {
+    for i := len(p.stack)-1; i > 0; i-- {
+      if p.stack[i].NodeName == "p" {
+        p.stack = p.stack[:i]
+        break
+      }
+    }
+  }
+  // e.g. <tr> closes an open <td> and then the <tr> around it
+  if implied, exists := impliedEndTags[name]; exists {
+    for closed := true; closed && len(p.stack) > 1; {
+      closed = false
+      for _,other := range implied {
+        if p.top().NodeName == other {
+          p.stack = p.stack[:len(p.stack)-1]
+          closed = true
+          break
+        }
+      }
+    }
+  }
+
+  // the root element was synthesized up front
+  if name == "html" && len(p.stack) == 1 {
+    for k,v := range attrs {
+      p.stack[0].Attrs[k] = v
+    }
+    return
+  }
+
+  n := &Node{
+    NodeName: name,
+    TagName: name,
+    Attrs: attrs,
+    Sign: 1,
+  }
+  p.appendChild(n)
+  if selfClosing || voidElements[name] {
+    return
+  }
+  p.stack = append(p.stack, n)
+  if decode, isRaw := rawTextElements[name]; isRaw {
+    p.rawText(name, decode)
+  }
+}
+
+func (p *htmlParser) parse() *Node {
+  root := &Node{
+    NodeName: "html",
+    TagName: "html",
+    Attrs: map[string]string{},
+    Sign: 1,
+  }
+  p.stack = []*Node{root}
+
+  for p.pos < len(p.src) {
+    next := strings.IndexByte(p.src[p.pos:], '<')
+    if next < 0 {
+      p.appendText(html.UnescapeString(p.src[p.pos:]))
+      break
+    }
+    p.appendText(html.UnescapeString(p.src[p.pos:p.pos+next]))
+    p.pos += next
+
+    rest := p.src[p.pos:]
+    switch {
+    case strings.HasPrefix(rest, "<!--"):
+      end := strings.Index(rest[4:], "-->")
+      if end < 0 {
+        end = len(rest) - 4
+      }
+      p.appendChild(&Node{
+        NodeName: "#comment",
+        Text: rest[4:4+end],
+        Sign: 1,
+      })
+      p.pos += 4 + end + 3
+
+    case strings.HasPrefix(rest, "<!"), strings.HasPrefix(rest, "<?"):
+      // doctype, cdata and processing instructions are skipped
+      end := strings.IndexByte(rest, '>')
+      if end < 0 {
+        end = len(rest) - 1
+      }
+      p.pos += end + 1
+
+    case strings.HasPrefix(rest, "</"):
+      p.pos += 2
+      start := p.pos
+      for p.pos < len(p.src) && isNameChar(p.src[p.pos]) {
+        p.pos++
+      }
+      name := strings.ToLower(p.src[start:p.pos])
+      if end := strings.IndexByte(p.src[p.pos:], '>'); end >= 0 {
+        p.pos += end + 1
+      } else {
+        p.pos = len(p.src)
+      }
+      p.closeElement(name)
+
+    case len(rest) > 1 && (rest[1] >= 'a' && rest[1] <= 'z' || rest[1] >= 'A' && rest[1] <= 'Z'):
+      p.pos++
+      start := p.pos
+      for p.pos < len(p.src) && isNameChar(p.src[p.pos]) {
+        p.pos++
+      }
+      p.startTag(strings.ToLower(p.src[start:p.pos]))
+
+    default:
+      // a '<' that doesn't start a tag is text
+      p.appendText("<")
+      p.pos++
+    }
+  }
+
+  p.pos = len(p.src)
+  return root
+}
+
+/*
+ParseHTMLString parses an html document into a tree rooted at its html
+element, which is synthesized if the document lacks one. It is a forgiving
+parser in the spirit of (but much simpler than) the html5 algorithm: void
+elements, raw text elements and the common implied end tags are handled,
+stray end tags are ignored and unclosed elements end with their parent.
+
+Node names are lowercase, text becomes #text nodes and comments #comment
+nodes. The input must already be decoded to utf-8.
+*/
+func ParseHTMLString(src string) *Node {
+  p := &htmlParser{ src: src }
+  return p.parse()
+}
+
+// ParseHTML reads and parses an html document, see ParseHTMLString
+func ParseHTML(r io.Reader) (*Node, error) {
+  src, err := ioutil.ReadAll(r)
+  if err != nil {
+    return nil, err
+  }
+  return ParseHTMLString(string(src)), nil
+}
@@ -0,0 +1,127 @@
+package dom
+
+import (
+  "bytes"
+  "fmt"
+  "sort"
+  "strings"
+  "testing"
+)
+
+// dumps a parsed tree compactly: names with children in parentheses, text
+// and comments quoted, attributes in brackets
+func dumpHTML(n *Node) string {
+  buf := &bytes.Buffer{}
+  var dump func(n *Node)
+  dump = func(n *Node) {
+    switch n.NodeName {
+    case "#text":
+      fmt.Fprintf(buf, "%q", n.Text)
+      return
+    case "#comment":
+      fmt.Fprintf(buf, "<!%q>", n.Text)
+      return
+    }
+    buf.WriteString(n.NodeName)
+    if len(n.Attrs) > 0 {
+      keys := []string{}
+      for k := range n.Attrs {
+        keys = append(keys, k)
+      }
+      sort.Strings(keys)
+      pairs := []string{}
+      for _,k := range keys {
+        pairs = append(pairs, fmt.Sprintf("%s=%q", k, n.Attrs[k]))
+      }
+      buf.WriteString("[" + strings.Join(pairs, " ") + "]")
+    }
+    if len(n.Children) > 0 {
+      buf.WriteString("(")
+      for i,c := range n.Children {
+        if i > 0 {
+          buf.WriteString(" ")
+        }
+        dump(c)
+      }
+      buf.WriteString(")")
+    }
+  }
+  dump(n)
+  return buf.String()
+}
+
+func TestParseHTML(t *testing.T) {
+  tests := []struct {
+    name, src, want string
+  }{
+    // structure
+    {"synthesized root", `<p>hi</p>`, `html(p("hi"))`},
+    {"explicit root", `<!DOCTYPE html><HTML lang=en><Body><DIV>x</DIV></Body></HTML>`,
+      `html[lang="en"](body(div("x")))`},
+    {"comment", `<div><!-- note -->x</div>`, `html(div(<!" note "> "x"))`},
+    {"entities", `<p>a &amp; b &lt;c&gt;</p>`, `html(p("a & b <c>"))`},
+
+    // void elements
+    {"void", `<div><br><img src=a.png><p>x</p></div>`,
+      `html(div(br img[src="a.png"] p("x")))`},
+    {"void with end tag", `<div><br></br>x</div>`, `html(div(br "x"))`},
+    {"self closing", `<div><span/>x</div>`, `html(div(span "x"))`},
+
+    // implied end tags
+    {"li", `<ul><li>a<li>b</ul>`, `html(ul(li("a") li("b")))`},
+    {"dt dd", `<dl><dt>a<dd>b<dt>c</dl>`, `html(dl(dt("a") dd("b") dt("c")))`},
+    {"table", `<table><tr><td>a<td>b<tr><td>c</table>`,
+      `html(table(tr(td("a") td("b")) tr(td("c"))))`},
+    {"p closed by block", `<p>a<div>b</div>`, `html(p("a") div("b"))`},
+    {"p closed by p", `<p>a<p>b`, `html(p("a") p("b"))`},
+    {"option", `<select><option>a<option>b</select>`, `html(select(option("a") option("b")))`},
+
+    // raw text
+    {"script", `<script>if (a<b && c>d) {}</script><p>x</p>`,
+      `html(script("if (a<b && c>d) {}") p("x"))`},
+    {"script with tags", `<script>document.write("<div>")</script><p>x</p>`,
+      `html(script("document.write(\"<div>\")") p("x"))`},
+    {"script end tag case", `<SCRIPT>x</ScRiPt><p>y</p>`, `html(script("x") p("y"))`},
+    {"script end tag prefix", `<script>a</scripts>b</script><p>y</p>`,
+      `html(script("a</scripts>b") p("y"))`},
+    // lowercasing Ⱥ changes its byte length
+    {"script with length changing case", `<script>var s='ȺȺȺȺȺȺȺȺȺȺ'</script><div>hi</div><p>t</p>`,
+      `html(script("var s='ȺȺȺȺȺȺȺȺȺȺ'") div("hi") p("t"))`},
+    {"style", `<style>p > a { x: y }</style>`, `html(style("p > a { x: y }"))`},
+    {"title decoded", `<title>a &amp; <b></title>`, `html(title("a & <b>"))`},
+    {"script not decoded", `<script>a &amp; b</script>`, `html(script("a &amp; b"))`},
+    {"unclosed script", `<script>a<p>b`, `html(script("a<p>b"))`},
+
+    // attributes
+    {"attributes", `<a href="x?a=1&amp;b=2" class='c d' data-x=y disabled>l</a>`,
+      `html(a[class="c d" data-x="y" disabled="" href="x?a=1&b=2"]("l"))`},
+    {"duplicate attribute", `<a id=1 id=2>l</a>`, `html(a[id="1"]("l"))`},
+    {"uppercase attribute", `<a HREF=x>l</a>`, `html(a[href="x"]("l"))`},
+
+    // malformed markup
+    {"stray end tag", `<div>a</span>b</div>`, `html(div("ab"))`},
+    {"unclosed", `<div><span>a`, `html(div(span("a")))`},
+    {"misnested", `<b><i>a</b>c</i>`, `html(b(i("a")) "c")`},
+    {"lone lt", `<p>a < b</p>`, `html(p("a < b"))`},
+    {"lt before digit", `<p>1<2</p>`, `html(p("1<2"))`},
+    {"unterminated comment", `<p>a</p><!-- b`, `html(p("a") <!" b">)`},
+    {"unterminated tag", `<div class="a`, `html(div[class="a"])`},
+    {"unterminated end tag", `<div>a</div`, `html(div("a"))`},
+    {"stray equals", `<div = a=b>x</div>`, `html(div[a="b"]("x"))`},
+    {"empty", ``, `html`},
+  }
+  for _,test := range tests {
+    if got := dumpHTML(ParseHTMLString(test.src)); got != test.want {
+      t.Errorf("%s: %s\n got %s\nwant %s", test.name, test.src, got, test.want)
+    }
+  }
+}
+
+func TestParseHTMLManyScripts(t *testing.T) {
+  // every raw text element must only scan up to its own end tag
+  src := strings.Repeat(`<script>x</script><p>y</p>`, 2000)
+  root := ParseHTMLString(src)
+  if got := len(root.Children); got != 4000 {
+    t.Errorf("%d children, want 4000", got)
+  }
+}
@@ -0,0 +1,307 @@
+package dom
+
+import (
+  "bytes"
+  "fmt"
+  "strings"
+)
+
+/*
+The template notation is a compact, regular-expression-like text form of a
+whole wrapper, e.g.
+
+  body(div.header^{?} (li a)^{+} p^{3})
+
+An element is its node name followed by optional #id and .class selectors,
+an optional ^{sign} and an optional parenthesized list of children, which
+must directly follow the element with no whitespace in between. A
+parenthesized list standing on its own is a ##paren node, whose sign follows
+the closing paren. Signs of 1 are omitted. Text content is not part of the
+notation, since wrappers are text-agnostic.
+
+Names may start with '#' (#text, #comment); any other character of a name,
+id or class that is part of the syntax is escaped with a backslash.
+*/
+
+const notationSpecial = "#.^(){}\\ \t\r\n"
+
+func escapeNotation(str string, allowLeadingHash bool) string {
+  buf := &bytes.Buffer{}
+  leading := allowLeadingHash
+  for _,r := range str {
+    if leading && r == '#' {
+      buf.WriteRune(r)
+      continue
+    }
+    leading = false
+    if strings.ContainsRune(notationSpecial, r) {
+      buf.WriteByte('\\')
+    }
+    buf.WriteRune(r)
+  }
+  return buf.String()
+}
+
+func writeNotation(buf *bytes.Buffer, n *Node, indent string, depth int) {
+  writeSign := func() {
+    if n.Sign.Normalize() != 1 {
+      buf.WriteString(fmt.Sprintf("^{%s}", n.Sign))
+    }
+  }
+  writeChildren := func() {
+    buf.WriteString("(")
+    for i,c := range n.Children {
+      if indent != "" {
+        buf.WriteString("\n")
+        buf.WriteString(strings.Repeat(indent, depth+1))
+      } else if i > 0 {
+        buf.WriteString(" ")
+      }
+      writeNotation(buf, c, indent, depth+1)
+    }
+    if indent != "" && len(n.Children) > 0 {
+      buf.WriteString("\n")
+      buf.WriteString(strings.Repeat(indent, depth))
+    }
+    buf.WriteString(")")
+  }
+
+  if n.IsParen() {
+    writeChildren()
+    writeSign()
+    return
+  }
+
+  buf.WriteString(escapeNotation(n.NodeName, true))
+  if id,exists := n.Attrs["id"]; exists && id != "" {
+    buf.WriteString("#")
+    buf.WriteString(escapeNotation(id, false))
+  }
+  if classes,exists := n.Attrs["class"]; exists {
+    for _,class := range strings.Fields(classes) {
+      buf.WriteString(".")
+      buf.WriteString(escapeNotation(class, false))
+    }
+  }
+  writeSign()
+  if len(n.Children) > 0 {
+    writeChildren()
+  }
+}
+
+// FormatNotation writes the whole tree under n in the template notation, on
+// a single line
+func FormatNotation(n *Node) string {
+  buf := &bytes.Buffer{}
+  writeNotation(buf, n, "", 0)
+  return buf.String()
+}
+
+// FormatNotationIndent writes the template notation with every child on its
+// own line, indented by indent per level
+func FormatNotationIndent(n *Node, indent string) string {
+  buf := &bytes.Buffer{}
+  writeNotation(buf, n, indent, 0)
+  return buf.String()
+}
+
+type notationParser struct {
+  src []rune
+  pos int
+}
+
+func (p *notationParser) errorf(format string, args ...interface{}) error {
+  return fmt.Errorf("notation: %s at offset %d", fmt.Sprintf(format, args...), p.pos)
+}
+
+func (p *notationParser) eof() bool {
+  return p.pos >= len(p.src)
+}
+
+func (p *notationParser) peek() rune {
+  if p.eof() {
+    return 0
+  }
+  return p.src[p.pos]
+}
+
+func (p *notationParser) skipSpace() {
+  for !p.eof() && strings.ContainsRune(" \t\r\n", p.peek()) {
+    p.pos++
+  }
+}
+
+// reads a name, id or class up to the next unescaped special character
+func (p *notationParser) ident(allowLeadingHash bool) (string, error) {
+  buf := &bytes.Buffer{}
+  if allowLeadingHash {
+    for p.peek() == '#' {
+      buf.WriteRune('#')
+      p.pos++
+    }
+  }
+  for !p.eof() {
+    r := p.peek()
+    if r == '\\' {
+      p.pos++
+      if p.eof() {
+        return "", p.errorf("dangling escape")
+      }
+      buf.WriteRune(p.peek())
+      p.pos++
+      continue
+    }
+    if strings.ContainsRune(notationSpecial, r) {
+      break
+    }
+    buf.WriteRune(r)
+    p.pos++
+  }
+  if buf.Len() == 0 || strings.Trim(buf.String(), "#") == "" {
+    return "", p.errorf("expected a name")
+  }
+  return buf.String(), nil
+}
+
+// parses ^{sign} if one is next
+func (p *notationParser) sign() (Sign, bool, error) {
+  if p.peek() != '^' {
+    return NoSign, false, nil
+  }
+  p.pos++
+  if p.peek() != '{' {
+    return NoSign, false, p.errorf("expected '{' after '^'")
+  }
+  p.pos++
+  start := p.pos
+  for !p.eof() && p.peek() != '}' {
+    p.pos++
+  }
+  if p.eof() {
+    return NoSign, false, p.errorf("unterminated sign")
+  }
+  sign, err := ParseSign(strings.TrimSpace(string(p.src[start:p.pos])))
+  if err != nil {
+    return NoSign, false, p.errorf("%s", err)
+  }
+  p.pos++
+  return sign, true, nil
+}
+
+// parses "(item item ...)", with the opening paren next
+func (p *notationParser) list() ([]*Node, error) {
+  p.pos++
+  children := []*Node{}
+  for {
+    p.skipSpace()
+    if p.eof() {
+      return nil, p.errorf("unterminated '('")
+    }
+    if p.peek() == ')' {
+      p.pos++
+      return children, nil
+    }
+    child, err := p.item()
+    if err != nil {
+      return nil, err
+    }
+    children = append(children, child)
+  }
+}
+
+func (p *notationParser) item() (*Node, error) {
+  if p.peek() == '(' {
+    children, err := p.list()
+    if err != nil {
+      return nil, err
+    }
+    sign, _, err := p.sign()
+    if err != nil {
+      return nil, err
+    }
+    return NewParenNode(children, sign.Normalize()), nil
+  }
+
+  name, err := p.ident(true)
+  if err != nil {
+    return nil, err
+  }
+  n := DefaultNode()
+  n.NodeName = name
+  if !strings.HasPrefix(name, "#") {
+    n.TagName = name
+  }
+
+  classes := []string{}
+  for p.peek() == '#' || p.peek() == '.' {
+    selector := p.peek()
+    p.pos++
+    value, err := p.ident(false)
+    if err != nil {
+      return nil, err
+    }
+    if n.Attrs == nil {
+      n.Attrs = map[string]string{}
+    }
+    if selector == '#' {
+      n.Attrs["id"] = value
+    } else {
+      classes = append(classes, value)
+    }
+  }
+  if len(classes) > 0 {
+    n.Attrs["class"] = strings.Join(classes, " ")
+  }
+
+  // the sign may come before or after the children, but not both
+  sign, hasSign, err := p.sign()
+  if err != nil {
+    return nil, err
+  }
+  if p.peek() == '(' {
+    if n.Children, err = p.list(); err != nil {
+      return nil, err
+    }
+    if !hasSign {
+      if sign, _, err = p.sign(); err != nil {
+        return nil, err
+      }
+    }
+  }
+  n.Sign = sign.Normalize()
+
+  return n, nil
+}
+
+// ParseNotationForest parses a whitespace separated sequence of trees in the
+// template notation
+func ParseNotationForest(str string) ([]*Node, error) {
+  p := &notationParser{ src: []rune(str) }
+  nodes := []*Node{}
+  for {
+    p.skipSpace()
+    if p.eof() {
+      return nodes, nil
+    }
+    if p.peek() == ')' {
+      return nil, p.errorf("unexpected ')'")
+    }
+    n, err := p.item()
+    if err != nil {
+      return nil, err
+    }
+    nodes = append(nodes, n)
+  }
+}
+
+// ParseNotation parses a single tree in the template notation
+func ParseNotation(str string) (*Node, error) {
+  nodes, err := ParseNotationForest(str)
+  if err != nil {
+    return nil, err
+  }
+  if len(nodes) != 1 {
+    return nil, fmt.Errorf("notation: expected a single tree, found %d", len(nodes))
+  }
+  return nodes[0], nil
+}
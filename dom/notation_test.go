@@ -0,0 +1,107 @@
+package dom
+
+import (
+  "strings"
+  "testing"
+)
+
+func TestNotationRoundTrip(t *testing.T) {
+  tests := []string{
+    `div`,
+    `body(div.header^{?} (li a)^{+} p^{3})`,
+    `ul#menu.nav.top(li^{*}(a #text))`,
+    `div(#text #comment br)`,
+    `(dt dd)^{2}`,
+    `div((a b)^{*} (c)^{?})`,
+    `x\.y#a\#b.c\(d\)(e\ f)`,
+  }
+  for _,test := range tests {
+    n, err := ParseNotation(test)
+    if err != nil {
+      t.Errorf("ParseNotation(%q): %s", test, err)
+      continue
+    }
+    if got := FormatNotation(n); got != test {
+      t.Errorf("%q formatted back as %q", test, got)
+    }
+    indented, err := ParseNotation(FormatNotationIndent(n, "  "))
+    if err != nil {
+      t.Errorf("%q indented: %s", test, err)
+    } else if !Equal(indented, n, nil) {
+      t.Errorf("%q changed when indented: %s", test, FormatNotation(indented))
+    }
+  }
+}
+
+func TestParseNotation(t *testing.T) {
+  n, err := ParseNotation("body( div#main.a.b^{+}( p^{1} (x y)^{*} ) )")
+  if err != nil {
+    t.Fatal(err)
+  }
+  div := n.Children[0]
+  if div.NodeName != "div" || div.TagName != "div" || div.Attrs["id"] != "main" ||
+  div.Attrs["class"] != "a b" || div.Sign != OnePlus {
+    t.Errorf("div parsed as %+v", div)
+  }
+  if len(div.Children) != 2 || div.Children[0].Sign != 1 {
+    t.Fatalf("children of div: %s", FormatNotation(div))
+  }
+  paren := div.Children[1]
+  if !paren.IsParen() || paren.Sign != ZeroPlus || len(paren.Children) != 2 {
+    t.Errorf("paren parsed as %s", FormatNotation(paren))
+  }
+  // reserved signs count as 1
+  if fixed, err := ParseNotation("div^{_}"); err != nil || fixed.Sign != 1 {
+    t.Errorf("reserved sign: %v, %v", fixed, err)
+  }
+  // a sign may also follow the children
+  after, err := ParseNotation("ul(li)^{?}")
+  if err != nil || after.Sign != ZeroOne {
+    t.Errorf("sign after the children: %v, %v", after, err)
+  }
+
+  forest, err := ParseNotationForest(" a b(c)\n d ")
+  if err != nil || len(forest) != 3 {
+    t.Errorf("forest of %d trees, %v", len(forest), err)
+  }
+}
+
+func TestParseNotationErrors(t *testing.T) {
+  tests := []string{
+    "",
+    "a b",
+    "div(",
+    "div(p))",
+    ")",
+    "div^",
+    "div^{",
+    "div^{x}",
+    "div^{-1}",
+    "div#",
+    "#",
+    "a^{+}(b)^{*}",
+  }
+  for _,test := range tests {
+    if n, err := ParseNotation(test); err == nil {
+      t.Errorf("ParseNotation(%q) = %s, want an error", test, FormatNotation(n))
+    }
+  }
+}
+
+// wrappers of parsed pages survive the notation, except for their text and
+// attributes other than id and class
+func TestNotationOfPage(t *testing.T) {
+  page := ParseHTMLString(`<body><div id=top class="a b"><ul><li>x<li>y</ul></div>
+    <!-- c --><table><tr><td>1<td>2</table></body>`)
+  page.Children[0].Children[0].Sign = OnePlus
+  n, err := ParseNotation(FormatNotation(page))
+  if err != nil {
+    t.Fatal(err)
+  }
+  if !Equal(n, page, &CompareOptions{ IgnoreText: true }) {
+    t.Errorf("%s parsed back as %s", FormatNotation(page), FormatNotation(n))
+  }
+  if got := FormatNotation(n); !strings.Contains(got, "div#top.a.b") {
+    t.Errorf("id and classes lost: %s", got)
+  }
+}
@@ -0,0 +1,290 @@
+package dom
+
+import (
+  "bytes"
+  "errors"
+  "fmt"
+  "strconv"
+  "strings"
+)
+
+// Path addresses a node by the child index taken at each level, starting
+// from the root. in wrappers, paren nodes are steps like any other node
+type Path []int
+
+// String formats the path as "/0/3/1", or "/" for the root
+func (p Path) String() string {
+  if len(p) == 0 {
+    return "/"
+  }
+  buf := &bytes.Buffer{}
+  for _,i := range p {
+    buf.WriteString("/")
+    buf.WriteString(strconv.Itoa(i))
+  }
+  return buf.String()
+}
+
+// ParsePath parses the form written by Path.String
+func ParsePath(str string) (Path, error) {
+  p := Path{}
+  for _,part := range strings.Split(strings.Trim(str, "/"), "/") {
+    if part == "" { continue }
+    i, err := strconv.Atoi(part)
+    if err != nil || i < 0 {
+      return nil, fmt.Errorf("invalid path step %q", part)
+    }
+    p = append(p, i)
+  }
+  return p, nil
+}
+
+// At returns the node at path p under n, or nil if there is none
+func (n *Node) At(p Path) *Node {
+  cur := n
+  for _,i := range p {
+    if i < 0 || i >= len(cur.Children) {
+      return nil
+    }
+    cur = cur.Children[i]
+  }
+  return cur
+}
+
+// PathTo finds the path from n to target, which is compared by identity
+func (n *Node) PathTo(target *Node) (Path, bool) {
+  if n == target {
+    return Path{}, true
+  }
+  for i,c := range n.Children {
+    if p, found := c.PathTo(target); found {
+      return append(Path{i}, p...), true
+    }
+  }
+  return nil, false
+}
+
+// saturating multiplication of counts, where Unbounded stays unbounded
+func mulCount(a, b int) int {
+  if a == 0 || b == 0 {
+    return 0
+  }
+  if a == Unbounded || b == Unbounded {
+    return Unbounded
+  }
+  return a*b
+}
+
+// a child as it appears in the page, with paren nodes dissolved into their
+// parent and the range of times it occurs
+type flatChild struct {
+  node *Node
+  min, max int
+}
+
+func flattenChildren(n *Node, mulMin, mulMax int, out []flatChild) []flatChild {
+  for _,c := range n.Children {
+    cMin := mulCount(mulMin, c.Sign.MinCount())
+    cMax := mulCount(mulMax, c.Sign.MaxCount())
+    if c.IsParen() {
+      out = flattenChildren(c, cMin, cMax, out)
+    } else {
+      out = append(out, flatChild{ node: c, min: cMin, max: cMax })
+    }
+  }
+  return out
+}
+
+// one element step of a selector
+type selectorStep struct {
+  node *Node
+  // 1-based range of positions among same-named siblings. lo is 0 when the
+  // position is unknown, hi is Unbounded when the node repeats freely
+  lo, hi int
+  // whether other nodes of the same name share the parent
+  hasSameNamed bool
+}
+
+// resolves the element steps along p, skipping paren nodes
+func (n *Node) selectorSteps(p Path) ([]selectorStep, error) {
+  if n.IsParen() {
+    return nil, errors.New("path starts at a paren node")
+  }
+  steps := []selectorStep{{ node: n, lo: 1, hi: 1 }}
+
+  parent := n
+  cur := n
+  for depth,i := range p {
+    if i < 0 || i >= len(cur.Children) {
+      return nil, fmt.Errorf("path %s leaves the tree at step %d", p, depth)
+    }
+    cur = cur.Children[i]
+    if cur.IsParen() {
+      if depth == len(p)-1 {
+        return nil, fmt.Errorf("path %s ends at a paren node", p)
+      }
+      continue
+    }
+
+    step := selectorStep{ node: cur }
+    before := 0 // same-named nodes preceding cur
+    known := true // whether before is an exact count
+    found := false
+    for _,fc := range flattenChildren(parent, 1, 1, nil) {
+      if fc.node == cur {
+        found = true
+        if known {
+          step.lo = before + 1
+          step.hi = Unbounded
+          if fc.max != Unbounded {
+            step.hi = before + fc.max
+          }
+        }
+      } else if fc.node.NodeName == cur.NodeName {
+        step.hasSameNamed = true
+        if !found {
+          known = known && fc.min == fc.max
+          before += fc.min
+        }
+      }
+    }
+    steps = append(steps, step)
+    parent = cur
+  }
+
+  return steps, nil
+}
+
+func xpathLiteral(s string) string {
+  if !strings.Contains(s, "'") {
+    return "'" + s + "'"
+  }
+  if !strings.Contains(s, "\"") {
+    return "\"" + s + "\""
+  }
+  parts := strings.Split(s, "'")
+  return "concat('" + strings.Join(parts, "', \"'\", '") + "')"
+}
+
+func (s selectorStep) xpath() string {
+  buf := &bytes.Buffer{}
+  switch s.node.NodeName {
+  case "#text":
+    buf.WriteString("text()")
+  case "#comment":
+    buf.WriteString("comment()")
+  default:
+    if strings.HasPrefix(s.node.NodeName, "#") {
+      buf.WriteString("node()")
+    } else {
+      buf.WriteString(s.node.NodeName)
+    }
+  }
+
+  if !s.hasSameNamed {
+    return buf.String()
+  }
+  switch {
+  case s.lo > 0 && s.lo == s.hi:
+    buf.WriteString(fmt.Sprintf("[%d]", s.lo))
+    return buf.String()
+  case s.lo > 0 && s.hi != Unbounded:
+    buf.WriteString(fmt.Sprintf("[position() >= %d and position() <= %d]", s.lo, s.hi))
+    return buf.String()
+  case s.lo > 1:
+    buf.WriteString(fmt.Sprintf("[position() >= %d]", s.lo))
+  }
+
+  // without an exact position, narrow down by attributes
+  if id := s.node.Attrs["id"]; id != "" {
+    buf.WriteString(fmt.Sprintf("[@id=%s]", xpathLiteral(id)))
+  }
+  for _,class := range strings.Fields(s.node.Attrs["class"]) {
+    buf.WriteString(fmt.Sprintf(
+      "[contains(concat(' ', normalize-space(@class), ' '), %s)]",
+      xpathLiteral(" " + class + " ")))
+  }
+  return buf.String()
+}
+
+func cssEscape(s string) string {
+  buf := &bytes.Buffer{}
+  for i,r := range s {
+    isIdent := r == '-' || r == '_' || r >= 0x80 ||
+    (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') ||
+    (r >= '0' && r <= '9' && i > 0)
+    if !isIdent {
+      buf.WriteByte('\\')
+    }
+    buf.WriteRune(r)
+  }
+  return buf.String()
+}
+
+func (s selectorStep) css() string {
+  buf := bytes.NewBufferString(s.node.NodeName)
+  if !s.hasSameNamed {
+    return buf.String()
+  }
+  switch {
+  case s.lo > 0 && s.lo == s.hi:
+    buf.WriteString(fmt.Sprintf(":nth-of-type(%d)", s.lo))
+    return buf.String()
+  case s.lo > 0 && s.hi != Unbounded:
+    buf.WriteString(fmt.Sprintf(":nth-of-type(n+%d):nth-of-type(-n+%d)", s.lo, s.hi))
+    return buf.String()
+  case s.lo > 1:
+    buf.WriteString(fmt.Sprintf(":nth-of-type(n+%d)", s.lo))
+  }
+
+  if id := s.node.Attrs["id"]; id != "" {
+    buf.WriteString("#" + cssEscape(id))
+  }
+  for _,class := range strings.Fields(s.node.Attrs["class"]) {
+    buf.WriteString("." + cssEscape(class))
+  }
+  return buf.String()
+}
+
+/*
+XPath returns an absolute xpath selecting the node at p under n, where n is
+the document root. Positions are counted among same-named siblings, with
+paren nodes dissolved into their parent as they would be in a page.
+
+In wrappers, a node whose sign lets it occur several times selects every
+occurrence: a fixed count becomes a position range, and if the position is
+unknown because a preceding same-named sibling repeats freely, the step
+falls back to the node's id and classes.
+*/
+func (n *Node) XPath(p Path) (string, error) {
+  steps, err := n.selectorSteps(p)
+  if err != nil {
+    return "", err
+  }
+  buf := &bytes.Buffer{}
+  for _,step := range steps {
+    buf.WriteString("/")
+    buf.WriteString(step.xpath())
+  }
+  return buf.String(), nil
+}
+
+// CSSSelector is the css equivalent of XPath. css can't select text or
+// comment nodes, so a path ending at one selects its parent element instead
+func (n *Node) CSSSelector(p Path) (string, error) {
+  steps, err := n.selectorSteps(p)
+  if err != nil {
+    return "", err
+  }
+  parts := []string{}
+  for _,step := range steps {
+    if strings.HasPrefix(step.node.NodeName, "#") {
+      break
+    }
+    parts = append(parts, step.css())
+  }
+  if len(parts) == 0 {
+    return "", fmt.Errorf("path %s has no element to select", p)
+  }
+  return strings.Join(parts, " > "), nil
+}
@@ -0,0 +1,111 @@
+package dom
+
+import (
+  "testing"
+)
+
+func TestPathString(t *testing.T) {
+  tests := []struct {
+    path Path
+    str string
+  }{
+    {Path{}, "/"},
+    {Path{0}, "/0"},
+    {Path{0, 3, 12}, "/0/3/12"},
+  }
+  for _,test := range tests {
+    if got := test.path.String(); got != test.str {
+      t.Errorf("%v formatted as %q, want %q", []int(test.path), got, test.str)
+    }
+    parsed, err := ParsePath(test.str)
+    if err != nil || parsed.String() != test.str {
+      t.Errorf("ParsePath(%q) = %v, %v", test.str, parsed, err)
+    }
+  }
+  for _,bad := range []string{"/a", "/-1", "/1/x/2"} {
+    if _, err := ParsePath(bad); err == nil {
+      t.Errorf("ParsePath(%q): no error", bad)
+    }
+  }
+}
+
+func TestPathTo(t *testing.T) {
+  root, _ := ParseNotation("html(body(div(p a) (h2 p)^{+}))")
+  h2 := root.Children[0].Children[1].Children[0]
+  p, found := root.PathTo(h2)
+  if !found || p.String() != "/0/1/0" {
+    t.Errorf("path to h2 %s, found %t", p, found)
+  }
+  if root.At(p) != h2 || root.At(Path{}) != root {
+    t.Error("At doesn't follow PathTo")
+  }
+  if root.At(Path{0, 5}) != nil || root.At(Path{-1}) != nil {
+    t.Error("At outside the tree isn't nil")
+  }
+  if _, found := root.PathTo(&Node{}); found {
+    t.Error("found a node outside the tree")
+  }
+}
+
+func TestSelectors(t *testing.T) {
+  root, err := ParseNotation(`html(body(div#nav p^{2} (h2 p)^{+} p.foot #text ul(li li.last)))`)
+  if err != nil {
+    t.Fatal(err)
+  }
+  tests := []struct {
+    path, xpath, css string
+  }{
+    {"/", "/html", "html"},
+    {"/0", "/html/body", "html > body"},
+    {"/0/0", "/html/body/div", "html > body > div"},
+    // a fixed count selects a range of positions
+    {"/0/1", "/html/body/p[position() >= 1 and position() <= 2]",
+      "html > body > p:nth-of-type(n+1):nth-of-type(-n+2)"},
+    // paren nodes dissolve into their parent
+    {"/0/2/0", "/html/body/h2", "html > body > h2"},
+    {"/0/2/1", "/html/body/p[position() >= 3]", "html > body > p:nth-of-type(n+3)"},
+    // after a freely repeating p, the position is unknown
+    {"/0/3", "/html/body/p[contains(concat(' ', normalize-space(@class), ' '), ' foot ')]",
+      "html > body > p.foot"},
+    {"/0/4", "/html/body/text()", "html > body"},
+    {"/0/5/1", "/html/body/ul/li[2]", "html > body > ul > li:nth-of-type(2)"},
+  }
+  for _,test := range tests {
+    p, err := ParsePath(test.path)
+    if err != nil {
+      t.Fatal(err)
+    }
+    if xpath, err := root.XPath(p); err != nil || xpath != test.xpath {
+      t.Errorf("XPath(%s) = %q, %v, want %q", test.path, xpath, err, test.xpath)
+    }
+    if css, err := root.CSSSelector(p); err != nil || css != test.css {
+      t.Errorf("CSSSelector(%s) = %q, %v, want %q", test.path, css, err, test.css)
+    }
+  }
+
+  for _,bad := range []Path{{0, 2}, {0, 9}, {0, 0, 0}} {
+    if xpath, err := root.XPath(bad); err == nil {
+      t.Errorf("XPath(%s) = %q, want an error", bad, xpath)
+    }
+  }
+  if _, err := root.Children[0].Children[2].XPath(Path{}); err == nil {
+    t.Error("no error for a path from a paren node")
+  }
+}
+
+func TestSelectorEscaping(t *testing.T) {
+  root, _ := ParseNotation(`html(body(p^{*} p p))`)
+  p := root.Children[0].Children[1]
+  p.Attrs = map[string]string{ "id": `it's "x"`, "class": "1st a.b" }
+  xpath, _ := root.XPath(Path{0, 1})
+  want := `/html/body/p[@id=concat('it', "'", 's "x"')]` +
+  `[contains(concat(' ', normalize-space(@class), ' '), ' 1st ')]` +
+  `[contains(concat(' ', normalize-space(@class), ' '), ' a.b ')]`
+  if xpath != want {
+    t.Errorf("xpath %s, want %s", xpath, want)
+  }
+  css, _ := root.CSSSelector(Path{0, 1})
+  if want := `html > body > p#it\'s\ \"x\".\1st.a\.b`; css != want {
+    t.Errorf("css %s, want %s", css, want)
+  }
+}
@@ -0,0 +1,192 @@
+package dom
+
+import (
+  "encoding/json"
+  "fmt"
+  "strconv"
+)
+
+/*
+Sign is the cardinality of a wrapper node, i.e. how many consecutive times it
+occurs in the pages the wrapper describes. Positive values are exact counts;
+the negative constants are regex-like quantifiers.
+
+Every sign stands for an interval of counts (? is [0,1], + is [1,inf), ...)
+and signs form a lattice under Join, which maps the union of two intervals
+to the smallest sign covering it.
+*/
+type Sign int
+
+// non-numeric values for Node.Sign
+const (
+  NoSign Sign = 0 // equivalent to 1, since Sign defaults to 0 when unmarshaling
+  OnePlus Sign = -1
+  ZeroPlus Sign = -2
+  ZeroOne Sign = -3
+  Fixed Sign = -4 // reserved, counts as exactly one
+)
+
+// MaxCount of signs with no upper bound
+const Unbounded = int(^uint(0) >> 1)
+
+// Normalize maps NoSign and Fixed to 1, leaving every other sign as is
+func (s Sign) Normalize() Sign {
+  if s == NoSign || s == Fixed {
+    return 1
+  }
+  return s
+}
+
+// Valid reports whether s is a positive count or one of the sign constants
+func (s Sign) Valid() bool {
+  return s >= Fixed
+}
+
+// MinCount is the fewest times a node with this sign can occur
+func (s Sign) MinCount() int {
+  switch s.Normalize() {
+  case ZeroPlus, ZeroOne:
+    return 0
+  case OnePlus:
+    return 1
+  default:
+    return int(s.Normalize())
+  }
+}
+
+// MaxCount is the most times a node with this sign can occur, or Unbounded
+func (s Sign) MaxCount() int {
+  switch s.Normalize() {
+  case ZeroPlus, OnePlus:
+    return Unbounded
+  case ZeroOne:
+    return 1
+  default:
+    return int(s.Normalize())
+  }
+}
+
+// Contains reports whether a node with this sign may occur exactly n times
+func (s Sign) Contains(n int) bool {
+  return s.MinCount() <= n && n <= s.MaxCount()
+}
+
+// IsFixedCount reports whether the sign is an exact count
+func (s Sign) IsFixedCount() bool {
+  return s.MinCount() == s.MaxCount()
+}
+
+// the smallest sign whose interval covers [lo,hi]
+func signForRange(lo, hi int) Sign {
+  switch {
+  case lo == hi && lo >= 1:
+    return Sign(lo)
+  case lo == 0 && hi <= 1:
+    return ZeroOne
+  case lo >= 1:
+    return OnePlus
+  default:
+    return ZeroPlus
+  }
+}
+
+/*
+Join is the least upper bound of two signs: the most specific sign allowing
+every count either one allows.
+
+  1,1 -> 1    N,N -> N    N,M -> +    N,+ -> +
+  1,? -> ?    N,? -> *    +,? -> *    N,* -> *
+*/
+func (s Sign) Join(o Sign) Sign {
+  if s.Normalize() == o.Normalize() {
+    return s.Normalize()
+  }
+
+  lo := s.MinCount()
+  if o.MinCount() < lo {
+    lo = o.MinCount()
+  }
+  hi := s.MaxCount()
+  if o.MaxCount() > hi {
+    hi = o.MaxCount()
+  }
+  return signForRange(lo, hi)
+}
+
+// Optional joins the sign with the node being absent, as when it only occurs
+// in one of two merged trees. 1|? -> ?, N|+|* -> *
+func (s Sign) Optional() Sign {
+  return signForRange(0, s.MaxCount())
+}
+
+func (s Sign) String() string {
+  switch {
+  case s == NoSign:
+    return "1"
+  case s > 0:
+    return fmt.Sprintf("%d",int(s))
+  case s == OnePlus:
+    return "+"
+  case s == ZeroPlus:
+    return "*"
+  case s == ZeroOne:
+    return "?"
+  default:
+    return "_"
+  }
+}
+
+// ParseSign parses the textual form of a sign: a count ("1", "3") or one of
+// "?", "*", "+" and "_"
+func ParseSign(str string) (Sign, error) {
+  switch str {
+  case "+":
+    return OnePlus, nil
+  case "*":
+    return ZeroPlus, nil
+  case "?":
+    return ZeroOne, nil
+  case "_":
+    return Fixed, nil
+  }
+
+  count, err := strconv.Atoi(str)
+  if err != nil || count < 0 {
+    return NoSign, fmt.Errorf("invalid sign %q", str)
+  }
+  return Sign(count), nil
+}
+
+// signs are encoded as their string form, e.g. "3" or "*"
+func (s Sign) MarshalJSON() ([]byte, error) {
+  return json.Marshal(s.String())
+}
+
+// accepts the string form as well as plain numbers, where the legacy negative
+// values of the sign constants are still understood
+func (s *Sign) UnmarshalJSON(data []byte) error {
+  if string(data) == "null" {
+    *s = NoSign
+    return nil
+  }
+
+  var str string
+  if err := json.Unmarshal(data, &str); err == nil {
+    parsed, err := ParseSign(str)
+    if err != nil {
+      return err
+    }
+    *s = parsed
+    return nil
+  }
+
+  var num int
+  if err := json.Unmarshal(data, &num); err != nil {
+    return fmt.Errorf("invalid sign %s", data)
+  }
+  if !Sign(num).Valid() {
+    return fmt.Errorf("invalid sign %d", num)
+  }
+  *s = Sign(num)
+  return nil
+}
@@ -0,0 +1,265 @@
+package dom
+
+import (
+  "encoding/json"
+  "strconv"
+  "testing"
+)
+
+// every kind of sign: unset, one, fixed counts and the quantifiers
+var latticeSigns = []Sign{NoSign, 1, 2, 3, ZeroOne, ZeroPlus, OnePlus}
+
+// the sign switch NodeMergeRecurse used before Join
+func legacyJoin(aSign, bSign Sign) Sign {
+  if aSign == 0 { aSign = 1 }
+  if bSign == 0 { bSign = 1 }
+  if aSign < bSign {
+    aSign, bSign = bSign, aSign
+  }
+  switch {
+  case aSign == bSign:
+    return aSign
+  case aSign == 1 && bSign == ZeroOne:
+    return ZeroOne
+  case aSign > 1 && bSign == ZeroOne,
+    aSign == OnePlus && bSign == ZeroOne,
+    aSign >= 1 && bSign == ZeroPlus,
+    aSign == ZeroPlus && bSign == ZeroOne,
+    aSign == OnePlus && bSign == ZeroPlus:
+    return ZeroPlus
+  case aSign > 1 && bSign >= 1,
+    aSign >= 1 && bSign == OnePlus:
+    return OnePlus
+  }
+  panic("ordering wrong somewhere")
+}
+
+// the optional sign switch NodeMergeRecurse used before Optional
+func legacyOptional(sign Sign) Sign {
+  if sign == 0 { sign = 1 }
+  if sign == 1 || sign == ZeroOne {
+    return ZeroOne
+  }
+  return ZeroPlus
+}
+
+func TestJoinMatchesLegacy(t *testing.T) {
+  for _,a := range latticeSigns {
+    for _,b := range latticeSigns {
+      if got, want := a.Join(b), legacyJoin(a, b); got != want {
+        t.Errorf("%s.Join(%s) = %s, want %s", a, b, got, want)
+      }
+    }
+  }
+}
+
+func TestJoinTable(t *testing.T) {
+  tests := []struct {
+    a, b, want Sign
+  }{
+    {NoSign, NoSign, 1},
+    {NoSign, 1, 1},
+    {1, 1, 1},
+    {2, 2, 2},
+    {2, 3, OnePlus},
+    {1, 3, OnePlus},
+    {1, OnePlus, OnePlus},
+    {3, OnePlus, OnePlus},
+    {1, ZeroOne, ZeroOne},
+    {NoSign, ZeroOne, ZeroOne},
+    {2, ZeroOne, ZeroPlus},
+    {OnePlus, ZeroOne, ZeroPlus},
+    {1, ZeroPlus, ZeroPlus},
+    {ZeroPlus, ZeroOne, ZeroPlus},
+    {OnePlus, ZeroPlus, ZeroPlus},
+    {ZeroOne, ZeroOne, ZeroOne},
+    {ZeroPlus, ZeroPlus, ZeroPlus},
+    {OnePlus, OnePlus, OnePlus},
+  }
+  for _,test := range tests {
+    if got := test.a.Join(test.b); got != test.want {
+      t.Errorf("%s.Join(%s) = %s, want %s", test.a, test.b, got, test.want)
+    }
+  }
+}
+
+func TestJoinLaws(t *testing.T) {
+  for _,a := range latticeSigns {
+    if got := a.Join(a); got != a.Normalize() {
+      t.Errorf("%s.Join(%s) = %s, not idempotent", a, a, got)
+    }
+    for _,b := range latticeSigns {
+      if a.Join(b) != b.Join(a) {
+        t.Errorf("%s.Join(%s) = %s but %s.Join(%s) = %s", a, b, a.Join(b), b, a, b.Join(a))
+      }
+      // the join covers both operands
+      for n := 0; n <= 4; n++ {
+        if (a.Contains(n) || b.Contains(n)) && !a.Join(b).Contains(n) {
+          t.Errorf("%s.Join(%s) = %s doesn't contain %d", a, b, a.Join(b), n)
+        }
+      }
+      for _,c := range latticeSigns {
+        left, right := a.Join(b).Join(c), a.Join(b.Join(c))
+        if left != right {
+          t.Errorf("(%s join %s) join %s = %s but %s join (%s join %s) = %s", a, b, c, left, a, b, c, right)
+        }
+      }
+    }
+  }
+}
+
+func TestOptional(t *testing.T) {
+  for _,s := range latticeSigns {
+    if got, want := s.Optional(), legacyOptional(s); got != want {
+      t.Errorf("%s.Optional() = %s, want %s", s, got, want)
+    }
+    if got := s.Optional(); got != s.Join(ZeroOne) && s.MaxCount() <= 1 {
+      t.Errorf("%s.Optional() = %s, want %s", s, got, s.Join(ZeroOne))
+    }
+    if !s.Optional().Contains(0) {
+      t.Errorf("%s.Optional() = %s doesn't contain 0", s, s.Optional())
+    }
+  }
+}
+
+func TestCounts(t *testing.T) {
+  tests := []struct {
+    sign Sign
+    min, max int
+  }{
+    {NoSign, 1, 1},
+    {Fixed, 1, 1},
+    {1, 1, 1},
+    {3, 3, 3},
+    {ZeroOne, 0, 1},
+    {ZeroPlus, 0, Unbounded},
+    {OnePlus, 1, Unbounded},
+  }
+  for _,test := range tests {
+    if got := test.sign.MinCount(); got != test.min {
+      t.Errorf("%s.MinCount() = %d, want %d", test.sign, got, test.min)
+    }
+    if got := test.sign.MaxCount(); got != test.max {
+      t.Errorf("%s.MaxCount() = %d, want %d", test.sign, got, test.max)
+    }
+    for n := 0; n <= 5; n++ {
+      want := test.min <= n && n <= test.max
+      if got := test.sign.Contains(n); got != want {
+        t.Errorf("%s.Contains(%d) = %v, want %v", test.sign, n, got, want)
+      }
+    }
+    if got, want := test.sign.IsFixedCount(), test.min == test.max; got != want {
+      t.Errorf("%s.IsFixedCount() = %v, want %v", test.sign, got, want)
+    }
+  }
+}
+
+func TestSignStr(t *testing.T) {
+  tests := []struct {
+    sign Sign
+    want string
+  }{
+    {NoSign, "0"},
+    {1, "1"},
+    {4, "4"},
+    {OnePlus, "+"},
+    {ZeroPlus, "*"},
+    {ZeroOne, "?"},
+    {Fixed, "_"},
+  }
+  for _,test := range tests {
+    n := &Node{ NodeName: "div", Sign: test.sign }
+    if got := n.SignStr(); got != test.want {
+      t.Errorf("SignStr of %d = %q, want %q", int(test.sign), got, test.want)
+    }
+  }
+}
+
+func TestParseSign(t *testing.T) {
+  tests := []struct {
+    str string
+    want Sign
+    ok bool
+  }{
+    {"0", NoSign, true},
+    {"1", 1, true},
+    {"12", 12, true},
+    {"+", OnePlus, true},
+    {"*", ZeroPlus, true},
+    {"?", ZeroOne, true},
+    {"_", Fixed, true},
+    {"", NoSign, false},
+    {"-1", NoSign, false},
+    {"1.5", NoSign, false},
+    {"++", NoSign, false},
+    {" 2", NoSign, false},
+  }
+  for _,test := range tests {
+    got, err := ParseSign(test.str)
+    if (err == nil) != test.ok || got != test.want {
+      t.Errorf("ParseSign(%q) = %s, %v, want %s, ok %t", test.str, got, err, test.want, test.ok)
+    }
+  }
+
+  // String and ParseSign round trip every sign but NoSign, which prints as 1
+  for _,sign := range append(latticeSigns, Fixed) {
+    got, err := ParseSign(sign.String())
+    if err != nil || got != sign.Normalize() && got != sign {
+      t.Errorf("ParseSign(%q) = %s, %v", sign.String(), got, err)
+    }
+  }
+}
+
+func TestSignJSON(t *testing.T) {
+  for _,sign := range []Sign{1, 5, OnePlus, ZeroPlus, ZeroOne, Fixed} {
+    data, err := json.Marshal(sign)
+    if err != nil {
+      t.Fatal(err)
+    }
+    if want := strconv.Quote(sign.String()); string(data) != want {
+      t.Errorf("%s encoded as %s, want %s", sign, data, want)
+    }
+    var decoded Sign
+    if err := json.Unmarshal(data, &decoded); err != nil || decoded != sign {
+      t.Errorf("%s decoded as %s, %v", data, decoded, err)
+    }
+  }
+
+  tests := []struct {
+    json string
+    want Sign
+    ok bool
+  }{
+    // legacy numbers
+    {`0`, NoSign, true},
+    {`3`, 3, true},
+    {`-1`, OnePlus, true},
+    {`-2`, ZeroPlus, true},
+    {`-3`, ZeroOne, true},
+    {`-4`, Fixed, true},
+    {`-5`, NoSign, false},
+    {`1.5`, NoSign, false},
+    // strings
+    {`"*"`, ZeroPlus, true},
+    {`"2"`, 2, true},
+    {`"x"`, NoSign, false},
+    {`null`, NoSign, true},
+    {`true`, NoSign, false},
+  }
+  for _,test := range tests {
+    var got Sign
+    err := json.Unmarshal([]byte(test.json), &got)
+    if (err == nil) != test.ok || got != test.want {
+      t.Errorf("%s decoded as %s, %v, want %s, ok %t", test.json, got, err, test.want, test.ok)
+    }
+  }
+
+  // nodes missing a sign, from before signs were written out, count as 1
+  var n Node
+  if err := json.Unmarshal([]byte(`{"nodeName": "div", "children": [{"nodeName": "p", "sign": -1}]}`), &n); err != nil {
+    t.Fatal(err)
+  }
+  if n.Sign.Normalize() != 1 || n.Children[0].Sign != OnePlus {
+    t.Errorf("signs %s and %s, want 1 and +", n.Sign, n.Children[0].Sign)
+  }
+}
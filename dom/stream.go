@@ -0,0 +1,402 @@
+package dom
+
+import (
+  "bufio"
+  "bytes"
+  "compress/gzip"
+  "encoding/json"
+  "fmt"
+  "io"
+  "log"
+  "os"
+  "os/exec"
+  "path/filepath"
+  "sort"
+)
+
+// EntrySource yields entries one at a time. Next returns io.EOF once there
+// are no more entries
+type EntrySource interface {
+  Next() (*Entry, error)
+}
+
+/*
+EntryReader streams json entries, one per line, validating each one like
+ReadEntries does. Lines can be of any length; only the current line is held
+in memory.
+*/
+type EntryReader struct {
+  Name string // reported in diagnostics, usually the file name
+  Skipped []*Diagnostic // problems with entries skipped in lenient mode
+
+  br *bufio.Reader
+  opts *LoadOptions
+  lineNum int
+}
+
+func NewEntryReader(r io.Reader, opts *LoadOptions) *EntryReader {
+  if opts == nil {
+    opts = &LoadOptions{ Limits: DefaultLimits() }
+  }
+  return &EntryReader{
+    br: bufio.NewReaderSize(r,64*1024),
+    opts: opts,
+  }
+}
+
+// parses and validates one line, returning its diagnostics if invalid
+func (r *EntryReader) parseLine(line []byte) (*Entry, []*Diagnostic) {
+  var entry Entry
+  diags := []*Diagnostic{}
+  if jsonErr := json.Unmarshal(line, &entry); jsonErr != nil {
+    diags = append(diags, &Diagnostic{ Message: jsonErr.Error() })
+  } else {
+    diags = ValidateEntry(&entry, r.opts.Limits)
+  }
+  for _,diag := range diags {
+    diag.File = r.Name
+    diag.Line = r.lineNum
+  }
+  if len(diags) > 0 {
+    return nil, diags
+  }
+  return &entry, nil
+}
+
+// SkippedEntries returns the problems of the entries skipped so far
+func (r *EntryReader) SkippedEntries() []*Diagnostic {
+  return r.Skipped
+}
+
+func (r *EntryReader) Next() (*Entry, error) {
+  for {
+    line, err := r.br.ReadBytes('\n')
+    if len(line) == 0 && err != nil {
+      return nil, err
+    }
+    r.lineNum++
+
+    line = bytes.TrimSpace(line)
+    if len(line) > 0 {
+      entry, diags := r.parseLine(line)
+      if entry != nil {
+        return entry, nil
+      }
+      if !r.opts.Lenient {
+        return nil, diags[0]
+      }
+      for _,diag := range diags {
+        log.Printf("skipping entry: %s", diag.Error())
+      }
+      r.Skipped = append(r.Skipped, diags...)
+    }
+
+    if err != nil {
+      return nil, err
+    }
+  }
+}
+
+// magic numbers of the supported compression formats
+var (
+  gzipMagic = []byte{0x1f, 0x8b}
+  zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+)
+
+// wraps a decompressor so closing it closes the underlying file too
+type decompressedFile struct {
+  io.Reader
+  closers []func() error
+}
+
+func (d *decompressedFile) Close() error {
+  var firstErr error
+  for _,closer := range d.closers {
+    if err := closer(); err != nil && firstErr == nil {
+      firstErr = err
+    }
+  }
+  return firstErr
+}
+
+/*
+reads the output of a zstd process. once the output ends, the process is
+waited for, and if it failed (e.g. on a corrupt or truncated file) its error
+is returned instead of io.EOF, so that entries aren't silently dropped
+*/
+type zstdReader struct {
+  name string
+  cmd *exec.Cmd
+  out io.Reader
+  stderr bytes.Buffer
+  done bool
+  err error
+}
+
+func (z *zstdReader) wait() error {
+  if z.done {
+    return z.err
+  }
+  z.done = true
+  if err := z.cmd.Wait(); err != nil {
+    msg := bytes.TrimSpace(z.stderr.Bytes())
+    z.err = fmt.Errorf("%s: zstd: %s: %s", z.name, err, msg)
+  }
+  return z.err
+}
+
+func (z *zstdReader) Read(p []byte) (int, error) {
+  n, err := z.out.Read(p)
+  if err == io.EOF {
+    if waitErr := z.wait(); waitErr != nil {
+      return n, waitErr
+    }
+  }
+  return n, err
+}
+
+// the process is killed if it's closed before its output ended
+func (z *zstdReader) Close() error {
+  if z.done {
+    return z.err
+  }
+  z.cmd.Process.Kill()
+  z.done = true
+  z.cmd.Wait()
+  return nil
+}
+
+/*
+OpenInput opens a file, transparently decompressing it if it starts with a
+gzip or zstd header. There is no zstd decoder in the standard library, so
+zstd input is piped through the zstd command, which must be on the PATH.
+*/
+func OpenInput(filename string) (io.ReadCloser, error) {
+  f, err := os.Open(filename)
+  if err != nil {
+    return nil, err
+  }
+  br := bufio.NewReader(f)
+  magic, _ := br.Peek(4)
+
+  switch {
+  case bytes.HasPrefix(magic, gzipMagic):
+    gz, err := gzip.NewReader(br)
+    if err != nil {
+      f.Close()
+      return nil, fmt.Errorf("%s: %s", filename, err)
+    }
+    return &decompressedFile{
+      Reader: gz,
+      closers: []func() error{ gz.Close, f.Close },
+    }, nil
+
+  case bytes.HasPrefix(magic, zstdMagic):
+    z := &zstdReader{
+      name: filename,
+      cmd: exec.Command("zstd", "-dc"),
+    }
+    z.cmd.Stdin = br
+    z.cmd.Stderr = &z.stderr
+    out, err := z.cmd.StdoutPipe()
+    if err != nil {
+      f.Close()
+      return nil, err
+    }
+    z.out = out
+    if err := z.cmd.Start(); err != nil {
+      f.Close()
+      return nil, fmt.Errorf("%s: zstd input needs the zstd command: %s", filename, err)
+    }
+    return &decompressedFile{
+      Reader: z,
+      closers: []func() error{ z.Close, f.Close },
+    }, nil
+
+  default:
+    return &decompressedFile{
+      Reader: br,
+      closers: []func() error{ f.Close },
+    }, nil
+  }
+}
+
+/*
+ExpandInputs turns a list of file names, glob patterns and directories into
+the files they name. Directories are searched recursively, and their files
+as well as glob matches are sorted.
+*/
+func ExpandInputs(patterns []string) ([]string, error) {
+  files := []string{}
+  for _,pattern := range patterns {
+    matches, err := filepath.Glob(pattern)
+    if err != nil {
+      return nil, err
+    }
+    if len(matches) == 0 {
+      return nil, fmt.Errorf("%s: no such file", pattern)
+    }
+    sort.Strings(matches)
+
+    for _,match := range matches {
+      info, err := os.Stat(match)
+      if err != nil {
+        return nil, err
+      }
+      if !info.IsDir() {
+        files = append(files, match)
+        continue
+      }
+      dirFiles := []string{}
+      err = filepath.Walk(match, func(path string, info os.FileInfo, err error) error {
+        if err != nil {
+          return err
+        }
+        if info.Mode().IsRegular() {
+          dirFiles = append(dirFiles, path)
+        }
+        return nil
+      })
+      if err != nil {
+        return nil, err
+      }
+      sort.Strings(dirFiles)
+      files = append(files, dirFiles...)
+    }
+  }
+  return files, nil
+}
+
+// SourceFunc creates the entry source reading one (decompressed) input file
+type SourceFunc func(name string, r io.Reader, opts *LoadOptions) EntrySource
+
+// sources that skip invalid entries in lenient mode, like EntryReader, report
+// them through this
+type skipReporter interface {
+  SkippedEntries() []*Diagnostic
+}
+
+// JSONSource is the SourceFunc of json entries, one per line
+func JSONSource(name string, r io.Reader, opts *LoadOptions) EntrySource {
+  er := NewEntryReader(r, opts)
+  er.Name = name
+  return er
+}
+
+// MultiEntryReader streams the entries of several files in turn, opening
+// each one only once the previous one is exhausted
+type MultiEntryReader struct {
+  Files []string
+  Skipped []*Diagnostic
+
+  // reads each file, json entries (NewEntryReader) if nil. set it before
+  // the first call to Next
+  Source SourceFunc
+
+  opts *LoadOptions
+  cur EntrySource
+  curFile io.ReadCloser
+  next int
+}
+
+// OpenEntries expands the given files, globs and directories (see
+// ExpandInputs) into a single stream of entries
+func OpenEntries(patterns []string, opts *LoadOptions) (*MultiEntryReader, error) {
+  files, err := ExpandInputs(patterns)
+  if err != nil {
+    return nil, err
+  }
+  return &MultiEntryReader{
+    Files: files,
+    opts: opts,
+  }, nil
+}
+
+func (m *MultiEntryReader) Next() (*Entry, error) {
+  for {
+    if m.cur == nil {
+      if m.next >= len(m.Files) {
+        return nil, io.EOF
+      }
+      f, err := OpenInput(m.Files[m.next])
+      if err != nil {
+        return nil, err
+      }
+      source := m.Source
+      if source == nil {
+        source = JSONSource
+      }
+      m.curFile = f
+      m.cur = source(m.Files[m.next], f, m.opts)
+      m.next++
+    }
+
+    entry, err := m.cur.Next()
+    if err == nil {
+      return entry, nil
+    }
+    if reporter, ok := m.cur.(skipReporter); ok {
+      m.Skipped = append(m.Skipped, reporter.SkippedEntries()...)
+    }
+    closeErr := m.curFile.Close()
+    m.cur = nil
+    if err != io.EOF {
+      return nil, err
+    }
+    // e.g. a decompressor failing once its file was read to the end
+    if closeErr != nil {
+      return nil, closeErr
+    }
+  }
+}
+
+// Close releases the file currently being read, if any
+func (m *MultiEntryReader) Close() error {
+  if m.cur == nil {
+    return nil
+  }
+  m.cur = nil
+  return m.curFile.Close()
+}
+
+/*
+StreamEntries sends every entry of src on the returned channel, which is
+closed at the end. The error that ended the stream (nil at io.EOF) is then
+sent on the error channel. The consumer must drain the entry channel.
+*/
+func StreamEntries(src EntrySource, buffer int) (<-chan *Entry, <-chan error) {
+  entries := make(chan *Entry, buffer)
+  errc := make(chan error, 1)
+  go func() {
+    defer close(errc)
+    defer close(entries)
+    for {
+      entry, err := src.Next()
+      if err == io.EOF {
+        errc <- nil
+        return
+      }
+      if err != nil {
+        errc <- err
+        return
+      }
+      entries <- entry
+    }
+  }()
+  return entries, errc
+}
+
+// CollectEntries reads every entry of src into memory
+func CollectEntries(src EntrySource) ([]*Entry, error) {
+  entries := []*Entry{}
+  for {
+    entry, err := src.Next()
+    if err == io.EOF {
+      return entries, nil
+    }
+    if err != nil {
+      return entries, err
+    }
+    entries = append(entries, entry)
+  }
+}
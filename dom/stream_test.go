@@ -0,0 +1,200 @@
+package dom
+
+import (
+  "bytes"
+  "compress/gzip"
+  "fmt"
+  "io"
+  "os"
+  "os/exec"
+  "path/filepath"
+  "strings"
+  "testing"
+)
+
+// json lines of n small entries
+func testLines(n int) string {
+  buf := &bytes.Buffer{}
+  for i := 0; i < n; i++ {
+    fmt.Fprintf(buf, `{"url":"http://ex.com/%d","dom":{"nodeName":"html","children":[{"nodeName":"p","children":[]}]}}`+"\n", i)
+  }
+  return buf.String()
+}
+
+func writeFile(t *testing.T, path string, data []byte) string {
+  if err := os.WriteFile(path, data, 0644); err != nil {
+    t.Fatal(err)
+  }
+  return path
+}
+
+func gzipped(data string) []byte {
+  buf := &bytes.Buffer{}
+  gz := gzip.NewWriter(buf)
+  gz.Write([]byte(data))
+  gz.Close()
+  return buf.Bytes()
+}
+
+func zstdCompressed(t *testing.T, data string) []byte {
+  if _, err := exec.LookPath("zstd"); err != nil {
+    t.Skip("no zstd command")
+  }
+  cmd := exec.Command("zstd", "-c")
+  cmd.Stdin = strings.NewReader(data)
+  out, err := cmd.Output()
+  if err != nil {
+    t.Fatal(err)
+  }
+  return out
+}
+
+func readAll(t *testing.T, patterns ...string) ([]*Entry, error) {
+  src, err := OpenEntries(patterns, nil)
+  if err != nil {
+    t.Fatal(err)
+  }
+  defer src.Close()
+  return CollectEntries(src)
+}
+
+func TestEntryReader(t *testing.T) {
+  // a line far longer than bufio's default buffer
+  long := fmt.Sprintf(`{"url":"http://ex.com/long","dom":{"nodeName":"html","text":"%s","children":[]}}`, strings.Repeat("x", 200000))
+  src := "\n" + testLines(2) + "  \n" + long + "\n" + testLines(1)
+  entries, err := CollectEntries(NewEntryReader(strings.NewReader(src), nil))
+  if err != nil {
+    t.Fatal(err)
+  }
+  if len(entries) != 4 {
+    t.Fatalf("%d entries, want 4", len(entries))
+  }
+  if entries[2].Uri != "http://ex.com/long" || len(entries[2].Dom.Text) != 200000 {
+    t.Errorf("long line read as %s with %d bytes of text", entries[2].Uri, len(entries[2].Dom.Text))
+  }
+
+  // no trailing newline
+  entries, err = CollectEntries(NewEntryReader(strings.NewReader(strings.TrimSpace(testLines(2))), nil))
+  if err != nil || len(entries) != 2 {
+    t.Errorf("%d entries, %v without a trailing newline", len(entries), err)
+  }
+}
+
+func TestEntryReaderDiagnostics(t *testing.T) {
+  src := testLines(1) + "{not json\n" + `{"url":"x","dom":{"nodeName":"p","sign":"%","children":[]}}` + "\n" + testLines(1)
+
+  r := NewEntryReader(strings.NewReader(src), nil)
+  r.Name = "in.jsonl"
+  _, err := CollectEntries(r)
+  diag, ok := err.(*Diagnostic)
+  if !ok {
+    t.Fatalf("strict mode error %v, want a diagnostic", err)
+  }
+  if diag.File != "in.jsonl" || diag.Line != 2 {
+    t.Errorf("diagnostic at %s:%d, want in.jsonl:2", diag.File, diag.Line)
+  }
+
+  r = NewEntryReader(strings.NewReader(src), &LoadOptions{ Lenient: true })
+  entries, err := CollectEntries(r)
+  if err != nil {
+    t.Fatal(err)
+  }
+  if len(entries) != 2 {
+    t.Errorf("%d entries in lenient mode, want 2", len(entries))
+  }
+  if len(r.Skipped) != 2 || r.Skipped[0].Line != 2 || r.Skipped[1].Line != 3 {
+    t.Errorf("skipped %v, want lines 2 and 3", r.Skipped)
+  }
+}
+
+func TestOpenInputCompressed(t *testing.T) {
+  dir := t.TempDir()
+  lines := testLines(3)
+  files := []string{
+    writeFile(t, filepath.Join(dir, "plain.jsonl"), []byte(lines)),
+    writeFile(t, filepath.Join(dir, "gz.jsonl.gz"), gzipped(lines)),
+    // detected by content, not by name
+    writeFile(t, filepath.Join(dir, "misnamed.jsonl"), gzipped(lines)),
+  }
+  for _,file := range files {
+    entries, err := readAll(t, file)
+    if err != nil || len(entries) != 3 {
+      t.Errorf("%s: %d entries, %v", filepath.Base(file), len(entries), err)
+    }
+  }
+
+  zst := writeFile(t, filepath.Join(dir, "z.jsonl.zst"), zstdCompressed(t, lines))
+  entries, err := readAll(t, zst)
+  if err != nil || len(entries) != 3 {
+    t.Errorf("zstd: %d entries, %v", len(entries), err)
+  }
+}
+
+func TestOpenInputTruncated(t *testing.T) {
+  dir := t.TempDir()
+  lines := testLines(200)
+
+  gz := gzipped(lines)
+  truncated := writeFile(t, filepath.Join(dir, "t.jsonl.gz"), gz[:len(gz)/2])
+  if _, err := readAll(t, truncated); err == nil {
+    t.Error("truncated gzip read without an error")
+  }
+
+  zst := zstdCompressed(t, lines)
+  truncated = writeFile(t, filepath.Join(dir, "t.jsonl.zst"), zst[:len(zst)/2])
+  _, err := readAll(t, truncated)
+  if err == nil {
+    t.Fatal("truncated zstd read without an error")
+  }
+  if !strings.Contains(err.Error(), "zstd") {
+    t.Errorf("error %q doesn't mention zstd", err)
+  }
+}
+
+func TestOpenInputClosedEarly(t *testing.T) {
+  dir := t.TempDir()
+  zst := writeFile(t, filepath.Join(dir, "z.jsonl.zst"), zstdCompressed(t, testLines(5000)))
+  f, err := OpenInput(zst)
+  if err != nil {
+    t.Fatal(err)
+  }
+  buf := make([]byte, 100)
+  if _, err := io.ReadFull(f, buf); err != nil {
+    t.Fatal(err)
+  }
+  // the killed process isn't reported as a failure
+  if err := f.Close(); err != nil {
+    t.Errorf("closing early: %s", err)
+  }
+}
+
+func TestOpenEntriesExpand(t *testing.T) {
+  dir := t.TempDir()
+  os.MkdirAll(filepath.Join(dir, "b", "c"), 0755)
+  writeFile(t, filepath.Join(dir, "a.jsonl"), []byte(testLines(1)))
+  writeFile(t, filepath.Join(dir, "b", "c", "d.jsonl"), []byte(testLines(2)))
+  writeFile(t, filepath.Join(dir, "b", "e.jsonl.gz"), gzipped(testLines(3)))
+
+  files, err := ExpandInputs([]string{dir})
+  if err != nil {
+    t.Fatal(err)
+  }
+  want := []string{"a.jsonl", "b/c/d.jsonl", "b/e.jsonl.gz"}
+  if len(files) != len(want) {
+    t.Fatalf("expanded to %v, want %v", files, want)
+  }
+  for i := range want {
+    if rel, _ := filepath.Rel(dir, files[i]); rel != want[i] {
+      t.Errorf("file %d is %s, want %s", i, rel, want[i])
+    }
+  }
+
+  entries, err := readAll(t, filepath.Join(dir, "*.jsonl"), filepath.Join(dir, "b"))
+  if err != nil || len(entries) != 6 {
+    t.Errorf("%d entries, %v, want 6", len(entries), err)
+  }
+
+  if _, err := ExpandInputs([]string{filepath.Join(dir, "nope*")}); err == nil {
+    t.Error("expanded a pattern matching nothing")
+  }
+}
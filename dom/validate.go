@@ -0,0 +1,125 @@
+package dom
+
+import (
+  "fmt"
+  "io"
+)
+
+// Limits bound the size of ingested pages
+type Limits struct {
+  MaxDepth int // 0 for no limit
+  MaxNodes int // 0 for no limit
+}
+
+func DefaultLimits() *Limits {
+  return &Limits{
+    MaxDepth: 512,
+    MaxNodes: 250000,
+  }
+}
+
+// Diagnostic is a problem with one entry of an input
+type Diagnostic struct {
+  File string // empty if unknown
+  Line int // 1-based line of the entry, 0 if unknown
+  Path string // where in the entry, e.g. "dom/0/3", empty for the whole entry
+  Message string
+}
+
+func (d *Diagnostic) Error() string {
+  msg := d.Message
+  if d.Path != "" {
+    msg = d.Path + ": " + msg
+  }
+  if d.Line > 0 {
+    msg = fmt.Sprintf("line %d: %s", d.Line, msg)
+  }
+  if d.File != "" {
+    msg = d.File + ": " + msg
+  }
+  return msg
+}
+
+/*
+ValidateEntry checks an unmarshaled entry against the format: the url and
+dom must be set, every node needs a nodeName and a known sign, children may
+not be null, paren nodes must have children, and the page must stay within
+limits (nil for no limits). Every problem found is returned.
+*/
+func ValidateEntry(e *Entry, limits *Limits) []*Diagnostic {
+  diags := []*Diagnostic{}
+  report := func(path string, format string, args ...interface{}) {
+    diags = append(diags, &Diagnostic{
+      Path: path,
+      Message: fmt.Sprintf(format, args...),
+    })
+  }
+
+  if e.Uri == "" {
+    report("", "missing url")
+  }
+  if e.Dom == nil {
+    report("", "missing dom")
+    return diags
+  }
+  if limits == nil {
+    limits = &Limits{}
+  }
+
+  // walked by hand rather than with Walk, since null children have to be
+  // stepped over without skipping their siblings
+  nodes := 0
+  var validate func(n *Node, path string, depth int) bool
+  validate = func(n *Node, path string, depth int) bool {
+    nodes++
+    if limits.MaxNodes > 0 && nodes > limits.MaxNodes {
+      report("", "more than %d nodes", limits.MaxNodes)
+      return false
+    }
+    if limits.MaxDepth > 0 && depth >= limits.MaxDepth {
+      report(path, "deeper than %d levels", limits.MaxDepth)
+      return true
+    }
+
+    if n.NodeName == "" {
+      report(path, "missing nodeName")
+    }
+    if !n.Sign.Valid() {
+      report(path, "invalid sign %d", int(n.Sign))
+    }
+    if n.IsParen() && len(n.Children) == 0 {
+      report(path, "paren node without children")
+    }
+    for i,child := range n.Children {
+      childPath := fmt.Sprintf("%s/%d", path, i)
+      if child == nil {
+        report(childPath, "null child")
+      } else if !validate(child, childPath, depth+1) {
+        return false
+      }
+    }
+    return true
+  }
+  validate(e.Dom, "dom", 0)
+
+  return diags
+}
+
+// LoadOptions controls how ReadEntries treats invalid entries
+type LoadOptions struct {
+  // log and skip invalid entries instead of failing on the first one
+  Lenient bool
+  Limits *Limits
+}
+
+/*
+ReadEntries reads one json entry per line, validating each one. Blank lines
+are ignored. In strict mode the first invalid entry is returned as a
+*Diagnostic error; in lenient mode invalid entries are logged, skipped and
+their problems returned alongside the valid entries.
+*/
+func ReadEntries(r io.Reader, opts *LoadOptions) ([]*Entry, []*Diagnostic, error) {
+  er := NewEntryReader(r, opts)
+  entries, err := CollectEntries(er)
+  return entries, er.Skipped, err
+}
@@ -0,0 +1,91 @@
+package dom
+
+import (
+  "encoding/json"
+  "strings"
+  "testing"
+)
+
+func TestValidateEntry(t *testing.T) {
+  tests := []struct {
+    name, json string
+    limits *Limits
+    want []string // the diagnostics, as Error formats them
+  }{
+    {"valid", `{"url": "x", "dom": {"nodeName": "p", "children": [{"nodeName": "#text", "text": "hi"}]}}`,
+      nil, nil},
+    {"missing url and dom", `{}`, nil, []string{"missing url", "missing dom"}},
+    {"missing node name", `{"url": "x", "dom": {"nodeName": "div", "children": [{"nodeName": "p"}, {"text": "x"}]}}`,
+      nil, []string{"dom/1: missing nodeName"}},
+    {"null child", `{"url": "x", "dom": {"nodeName": "div", "children": [null, {"nodeName": ""}]}}`,
+      nil, []string{"dom/0: null child", "dom/1: missing nodeName"}},
+    {"empty paren", `{"url": "x", "dom": {"nodeName": "div", "children": [{"nodeName": "##paren", "sign": "+"}]}}`,
+      nil, []string{"dom/0: paren node without children"}},
+    {"too deep", `{"url": "x", "dom": {"nodeName": "a", "children": [{"nodeName": "b", "children": [{"nodeName": "c", "children": [{"nodeName": ""}]}]}]}}`,
+      &Limits{ MaxDepth: 2 }, []string{"dom/0/0: deeper than 2 levels"}},
+    {"too many nodes", `{"url": "x", "dom": {"nodeName": "a", "children": [{"nodeName": "b"}, {"nodeName": "c"}, {"nodeName": ""}]}}`,
+      &Limits{ MaxNodes: 2 }, []string{"more than 2 nodes"}},
+    {"within limits", `{"url": "x", "dom": {"nodeName": "a", "children": [{"nodeName": "b"}]}}`,
+      &Limits{ MaxNodes: 2, MaxDepth: 2 }, nil},
+  }
+  for _,test := range tests {
+    var entry Entry
+    if err := json.Unmarshal([]byte(test.json), &entry); err != nil {
+      t.Fatalf("%s: %s", test.name, err)
+    }
+    got := []string{}
+    for _,diag := range ValidateEntry(&entry, test.limits) {
+      got = append(got, diag.Error())
+    }
+    if strings.Join(got, "; ") != strings.Join(test.want, "; ") {
+      t.Errorf("%s: %q, want %q", test.name, got, test.want)
+    }
+  }
+}
+
+func TestValidateSign(t *testing.T) {
+  // signs set in code rather than decoded
+  entry := &Entry{ Uri: "x", Dom: &Node{ NodeName: "p", Sign: Sign(-7) } }
+  diags := ValidateEntry(entry, nil)
+  if len(diags) != 1 || diags[0].Error() != "dom: invalid sign -7" {
+    t.Errorf("diagnostics %v", diags)
+  }
+}
+
+func TestDiagnosticError(t *testing.T) {
+  tests := []struct {
+    diag Diagnostic
+    want string
+  }{
+    {Diagnostic{ Message: "bad" }, "bad"},
+    {Diagnostic{ Path: "dom/1", Message: "bad" }, "dom/1: bad"},
+    {Diagnostic{ File: "a.jsonl", Line: 3, Path: "dom", Message: "bad" }, "a.jsonl: line 3: dom: bad"},
+    {Diagnostic{ File: "a.jsonl", Message: "bad" }, "a.jsonl: bad"},
+  }
+  for _,test := range tests {
+    if got := test.diag.Error(); got != test.want {
+      t.Errorf("%q, want %q", got, test.want)
+    }
+  }
+}
+
+func TestReadEntries(t *testing.T) {
+  src := `{"url": "a", "dom": {"nodeName": "p"}}
+
+{"url": "b", "dom": {"nodeName": ""}}
+{"url": "c", "dom": {"nodeName": "p", "sign": -9}}
+{"url": "d", "dom": {"nodeName": "p"}}
+`
+  _, _, err := ReadEntries(strings.NewReader(src), nil)
+  if err == nil || err.Error() != "line 3: dom: missing nodeName" {
+    t.Errorf("strict mode error %v", err)
+  }
+
+  entries, skipped, err := ReadEntries(strings.NewReader(src), &LoadOptions{ Lenient: true })
+  if err != nil || len(entries) != 2 || entries[1].Uri != "d" {
+    t.Fatalf("%d entries, %v", len(entries), err)
+  }
+  if len(skipped) != 2 || skipped[0].Line != 3 || skipped[1].Line != 4 {
+    t.Errorf("skipped %v, want lines 3 and 4", skipped)
+  }
+}
@@ -0,0 +1,82 @@
+package dom
+
+// WalkAction tells Walk how to continue after a hook
+type WalkAction int
+
+const (
+  Continue WalkAction = iota
+  SkipChildren // from Pre, don't descend into the node. Post is still called
+  Stop // end the walk immediately
+)
+
+// Cursor describes the node being visited. it is reused for every node of a
+// walk, so hooks must copy anything they want to keep
+type Cursor struct {
+  Node *Node
+  Index int // index of Node among its parent's children, -1 for the root
+  Ancestors []*Node // from the root down to the parent
+  path Path
+}
+
+// Parent of the visited node, nil for the root
+func (c *Cursor) Parent() *Node {
+  if len(c.Ancestors) == 0 {
+    return nil
+  }
+  return c.Ancestors[len(c.Ancestors)-1]
+}
+
+// Depth of the visited node, 0 for the root
+func (c *Cursor) Depth() int {
+  return len(c.Ancestors)
+}
+
+// Path from the root to the visited node
+func (c *Cursor) Path() Path {
+  return append(Path{}, c.path...)
+}
+
+// Walker holds the hooks called around each node's children. either may be
+// nil; a SkipChildren returned from Post is treated as Continue
+type Walker struct {
+  Pre func(c *Cursor) WalkAction
+  Post func(c *Cursor) WalkAction
+}
+
+func (w *Walker) visit(c *Cursor, n *Node, index int) WalkAction {
+  c.Node = n
+  c.Index = index
+
+  action := Continue
+  if w.Pre != nil {
+    action = w.Pre(c)
+  }
+  if action == Stop {
+    return Stop
+  }
+
+  if action != SkipChildren && len(n.Children) > 0 {
+    c.Ancestors = append(c.Ancestors, n)
+    for i,child := range n.Children {
+      c.path = append(c.path, i)
+      if w.visit(c, child, i) == Stop {
+        return Stop
+      }
+      c.path = c.path[:len(c.path)-1]
+    }
+    c.Ancestors = c.Ancestors[:len(c.Ancestors)-1]
+    c.Node = n
+    c.Index = index
+  }
+
+  if w.Post != nil && w.Post(c) == Stop {
+    return Stop
+  }
+  return Continue
+}
+
+// Walk visits the tree under n depth-first, calling Pre before and Post after
+// each node's children. returns false if a hook stopped the walk
+func Walk(n *Node, w Walker) bool {
+  return w.visit(&Cursor{}, n, -1) != Stop
+}
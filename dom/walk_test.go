@@ -0,0 +1,81 @@
+package dom
+
+import (
+  "fmt"
+  "strings"
+  "testing"
+)
+
+// records the hooks called on the tree "a(b(c d) e)"
+func walkTrace(pre, post func(c *Cursor) WalkAction) (string, bool) {
+  root, _ := ParseNotation("a(b(c d) e)")
+  trace := []string{}
+  done := Walk(root, Walker{
+    Pre: func(c *Cursor) WalkAction {
+      trace = append(trace, "+" + c.Node.NodeName)
+      if pre == nil {
+        return Continue
+      }
+      return pre(c)
+    },
+    Post: func(c *Cursor) WalkAction {
+      trace = append(trace, "-" + c.Node.NodeName)
+      if post == nil {
+        return Continue
+      }
+      return post(c)
+    },
+  })
+  return strings.Join(trace, " "), done
+}
+
+func TestWalk(t *testing.T) {
+  onNode := func(name string, action WalkAction) func(c *Cursor) WalkAction {
+    return func(c *Cursor) WalkAction {
+      if c.Node.NodeName == name {
+        return action
+      }
+      return Continue
+    }
+  }
+  tests := []struct {
+    name string
+    pre, post func(c *Cursor) WalkAction
+    want string
+    done bool
+  }{
+    {"all", nil, nil, "+a +b +c -c +d -d -b +e -e -a", true},
+    {"skip", onNode("b", SkipChildren), nil, "+a +b -b +e -e -a", true},
+    {"stop in pre", onNode("d", Stop), nil, "+a +b +c -c +d", false},
+    {"stop in post", nil, onNode("b", Stop), "+a +b +c -c +d -d -b", false},
+    {"skip in post", nil, onNode("c", SkipChildren), "+a +b +c -c +d -d -b +e -e -a", true},
+  }
+  for _,test := range tests {
+    got, done := walkTrace(test.pre, test.post)
+    if got != test.want || done != test.done {
+      t.Errorf("%s: %s, done %t, want %s, done %t", test.name, got, done, test.want, test.done)
+    }
+  }
+}
+
+func TestWalkCursor(t *testing.T) {
+  root, _ := ParseNotation("a(b(c d) e)")
+  got := []string{}
+  check := func(c *Cursor) WalkAction {
+    parent := "-"
+    if c.Parent() != nil {
+      parent = c.Parent().NodeName
+    }
+    got = append(got, fmt.Sprintf("%s:%d:%d:%s:%s", c.Node.NodeName, c.Index, c.Depth(), parent, c.Path()))
+    if root.At(c.Path()) != c.Node {
+      t.Errorf("cursor path %s doesn't lead to %s", c.Path(), c.Node.NodeName)
+    }
+    return Continue
+  }
+  Walk(root, Walker{ Pre: check, Post: check })
+  want := "a:-1:0:-:/ b:0:1:a:/0 c:0:2:b:/0/0 c:0:2:b:/0/0 d:1:2:b:/0/1 d:1:2:b:/0/1 " +
+  "b:0:1:a:/0 e:1:1:a:/1 e:1:1:a:/1 a:-1:0:-:/"
+  if strings.Join(got, " ") != want {
+    t.Errorf("cursors %s, want %s", strings.Join(got, " "), want)
+  }
+}
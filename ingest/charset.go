@@ -0,0 +1,146 @@
+package ingest
+
+import (
+  "bytes"
+  "mime"
+  "regexp"
+  "strings"
+  "unicode/utf16"
+  "unicode/utf8"
+)
+
+// windows-1252 code points of bytes 0x80 to 0x9f, which latin1 leaves as
+// control characters. undefined bytes map to themselves
+var windows1252 = [32]rune{
+  0x20ac, 0x81, 0x201a, 0x0192, 0x201e, 0x2026, 0x2020, 0x2021,
+  0x02c6, 0x2030, 0x0160, 0x2039, 0x0152, 0x8d, 0x017d, 0x8f,
+  0x90, 0x2018, 0x2019, 0x201c, 0x201d, 0x2022, 0x2013, 0x2014,
+  0x02dc, 0x2122, 0x0161, 0x203a, 0x0153, 0x9d, 0x017e, 0x0178,
+}
+
+// charset labels mapped to the decoder handling them. as browsers do, ascii
+// and latin1 are read as windows-1252, a superset of both
+var charsetAliases = map[string]string{
+  "utf-8": "utf-8",
+  "utf8": "utf-8",
+  "unicode-1-1-utf-8": "utf-8",
+  "us-ascii": "windows-1252",
+  "ascii": "windows-1252",
+  "iso-8859-1": "windows-1252",
+  "iso8859-1": "windows-1252",
+  "latin1": "windows-1252",
+  "l1": "windows-1252",
+  "cp1252": "windows-1252",
+  "windows-1252": "windows-1252",
+  "x-cp1252": "windows-1252",
+  "utf-16": "utf-16le",
+  "utf-16le": "utf-16le",
+  "utf-16be": "utf-16be",
+}
+
+func decodeWindows1252(body []byte) string {
+  var b strings.Builder
+  b.Grow(len(body))
+  for _,c := range body {
+    switch {
+    case c < 0x80:
+      b.WriteByte(c)
+    case c < 0xa0:
+      b.WriteRune(windows1252[c-0x80])
+    default:
+      b.WriteRune(rune(c))
+    }
+  }
+  return b.String()
+}
+
+func decodeUTF16(body []byte, bigEndian bool) string {
+  units := make([]uint16, len(body)/2)
+  for i := range units {
+    if bigEndian {
+      units[i] = uint16(body[2*i])<<8 | uint16(body[2*i+1])
+    } else {
+      units[i] = uint16(body[2*i+1])<<8 | uint16(body[2*i])
+    }
+  }
+  return string(utf16.Decode(units))
+}
+
+var (
+  utf8BOM = []byte{0xef, 0xbb, 0xbf}
+  utf16LEBOM = []byte{0xff, 0xfe}
+  utf16BEBOM = []byte{0xfe, 0xff}
+)
+
+func hasBOM(body []byte) bool {
+  return bytes.HasPrefix(body, utf8BOM) || bytes.HasPrefix(body, utf16LEBOM) || bytes.HasPrefix(body, utf16BEBOM)
+}
+
+// SupportedCharset reports whether Decode knows a charset. the empty charset
+// is utf-8
+func SupportedCharset(charset string) bool {
+  charset = strings.TrimSpace(charset)
+  if charset == "" {
+    return true
+  }
+  _,exists := charsetAliases[strings.ToLower(charset)]
+  return exists
+}
+
+/*
+Decode converts a document body in the given charset to utf-8. A byte order
+mark overrides the charset, and unknown charsets (see SupportedCharset) are
+read as utf-8. Invalid
+sequences are replaced with U+FFFD rather than failing, since a page with a
+few broken characters is still worth clustering.
+*/
+func Decode(body []byte, charset string) string {
+  switch {
+  case bytes.HasPrefix(body, utf8BOM):
+    return strings.ToValidUTF8(string(body[len(utf8BOM):]), "�")
+  case bytes.HasPrefix(body, utf16LEBOM):
+    return decodeUTF16(body[len(utf16LEBOM):], false)
+  case bytes.HasPrefix(body, utf16BEBOM):
+    return decodeUTF16(body[len(utf16BEBOM):], true)
+  }
+
+  switch charsetAliases[strings.ToLower(strings.TrimSpace(charset))] {
+  case "windows-1252":
+    return decodeWindows1252(body)
+  case "utf-16le":
+    return decodeUTF16(body, false)
+  case "utf-16be":
+    return decodeUTF16(body, true)
+  }
+  if utf8.Valid(body) {
+    return string(body)
+  }
+  return strings.ToValidUTF8(string(body), "�")
+}
+
+// <meta charset="..."> and <meta http-equiv="content-type" content="...">
+var metaCharsetRe = regexp.MustCompile(`(?i)<meta[^>]+charset\s*=\s*["']?\s*([a-z0-9_:.-]+)`)
+
+// how far into a document a meta charset is looked for, as in browsers
+const sniffLength = 1024
+
+/*
+DetectCharset picks the charset of an html document: from the content type
+header if it names one, otherwise from a meta tag near the start of the
+body. Returns "" if neither does, in which case Decode assumes utf-8.
+*/
+func DetectCharset(contentType string, body []byte) string {
+  if _,params, err := mime.ParseMediaType(contentType); err == nil {
+    if charset := params["charset"]; charset != "" {
+      return charset
+    }
+  }
+  head := body
+  if len(head) > sniffLength {
+    head = head[:sniffLength]
+  }
+  if match := metaCharsetRe.FindSubmatch(head); match != nil {
+    return string(match[1])
+  }
+  return ""
+}
@@ -0,0 +1,153 @@
+package ingest
+
+import (
+  "encoding/base64"
+  "encoding/json"
+  "fmt"
+  "io"
+
+  "github.com/predictive-edge/dom-cluster/dom"
+)
+
+// the parts of a har 1.2 archive that are read, see
+// http://www.softwareishard.com/blog/har-12-spec/
+type harArchive struct {
+  Log struct {
+    Entries []harEntry `json:"entries"`
+  } `json:"log"`
+}
+
+type harEntry struct {
+  // chrome's devtools record what kind of resource each request was for;
+  // pages and iframes are both documents
+  ResourceType string `json:"_resourceType"`
+  Request struct {
+    Url string `json:"url"`
+  } `json:"request"`
+  Response struct {
+    Status int `json:"status"`
+    Content struct {
+      MimeType string `json:"mimeType"`
+      Text string `json:"text"`
+      Encoding string `json:"encoding"`
+    } `json:"content"`
+  } `json:"response"`
+}
+
+/*
+HARReader turns the html documents recorded in a har file, as exported from a
+browser's network panel, into entries. Every successful (2xx) response with
+an html content type becomes its own entry, so iframe documents come out
+separately from the page that embeds them. Responses recorded without their
+content and, when the browser recorded resource types, html fetched by
+scripts are skipped.
+
+A har file is a single json document, so it is read whole on the first call
+to Next.
+*/
+type HARReader struct {
+  Name string // reported in diagnostics, usually the file name
+  Skipped []*dom.Diagnostic // problems with pages skipped in lenient mode
+
+  r io.Reader
+  opts *dom.LoadOptions
+  entries []harEntry
+  next int
+}
+
+func NewHARReader(r io.Reader, opts *dom.LoadOptions) *HARReader {
+  if opts == nil {
+    opts = &dom.LoadOptions{ Limits: dom.DefaultLimits() }
+  }
+  return &HARReader{
+    r: r,
+    opts: opts,
+  }
+}
+
+func (h *HARReader) SkippedEntries() []*dom.Diagnostic {
+  return h.Skipped
+}
+
+func (h *HARReader) load() error {
+  var archive harArchive
+  if err := json.NewDecoder(h.r).Decode(&archive); err != nil {
+    return &dom.Diagnostic{
+      File: h.Name,
+      Message: err.Error(),
+    }
+  }
+  h.entries = archive.Log.Entries
+  h.r = nil
+  return nil
+}
+
+// the page of a har entry, nil if it isn't an html document
+func (h *HARReader) page(e *harEntry, where string) (*dom.Entry, *dom.Diagnostic) {
+  if e.ResourceType != "" && e.ResourceType != "document" {
+    return nil, nil
+  }
+  if e.Response.Status < 200 || e.Response.Status > 299 {
+    return nil, nil
+  }
+  content := &e.Response.Content
+  if !isHTML(content.MimeType) || content.Text == "" {
+    return nil, nil
+  }
+
+  if content.Encoding == "base64" {
+    body, err := base64.StdEncoding.DecodeString(content.Text)
+    if err != nil {
+      return nil, &dom.Diagnostic{
+        File: h.Name,
+        Message: where + ": " + err.Error(),
+      }
+    }
+    entry, err := htmlPage(e.Request.Url, content.MimeType, body)
+    if err != nil {
+      return nil, &dom.Diagnostic{
+        File: h.Name,
+        Message: where + ": " + err.Error(),
+      }
+    }
+    return entry, nil
+  }
+  // otherwise the text was already decoded when the har was written
+  return &dom.Entry{
+    Uri: e.Request.Url,
+    Dom: dom.ParseHTMLString(content.Text),
+  }, nil
+}
+
+// Next returns the entry of the next html document in the file
+func (h *HARReader) Next() (*dom.Entry, error) {
+  if h.r != nil {
+    if err := h.load(); err != nil {
+      return nil, err
+    }
+  }
+
+  for h.next < len(h.entries) {
+    where := fmt.Sprintf("entry %d", h.next)
+    entry, diag := h.page(&h.entries[h.next], where)
+    h.entries[h.next] = harEntry{} // release the content
+    h.next++
+
+    var diags []*dom.Diagnostic
+    if diag != nil {
+      diags = []*dom.Diagnostic{ diag }
+    } else if entry != nil {
+      diags = validatePage(entry, h.opts.Limits, h.Name, where)
+    }
+    if len(diags) == 0 {
+      if entry != nil {
+        return entry, nil
+      }
+      continue
+    }
+    if err := skipPage(diags, h.opts, &h.Skipped); err != nil {
+      return nil, err
+    }
+  }
+  return nil, io.EOF
+}
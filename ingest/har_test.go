@@ -0,0 +1,168 @@
+package ingest
+
+import (
+  "encoding/base64"
+  "io"
+  "strings"
+  "testing"
+
+  "github.com/predictive-edge/dom-cluster/dom"
+)
+
+func TestHARReader(t *testing.T) {
+  latin1 := base64.StdEncoding.EncodeToString([]byte("<p>caf\xe9</p>"))
+  src := `{"log": {"entries": [
+    {"_resourceType": "document", "request": {"url": "http://ex.com/"},
+      "response": {"status": 200, "content": {"mimeType": "text/html; charset=utf-8", "text": "<p>page</p>"}}},
+    {"_resourceType": "script", "request": {"url": "http://ex.com/app.js"},
+      "response": {"status": 200, "content": {"mimeType": "text/html", "text": "<p>fetched</p>"}}},
+    {"request": {"url": "http://ex.com/frame"},
+      "response": {"status": 200, "content": {"mimeType": "text/html; charset=iso-8859-1", "text": "` + latin1 + `", "encoding": "base64"}}},
+    {"request": {"url": "http://ex.com/redirect"},
+      "response": {"status": 301, "content": {"mimeType": "text/html", "text": "<p>moved</p>"}}},
+    {"request": {"url": "http://ex.com/empty"},
+      "response": {"status": 200, "content": {"mimeType": "text/html"}}},
+    {"request": {"url": "http://ex.com/style.css"},
+      "response": {"status": 200, "content": {"mimeType": "text/css", "text": "p {}"}}}
+  ]}}`
+
+  got, err := readPages(NewHARReader(strings.NewReader(src), nil))
+  if err != nil {
+    t.Fatal(err)
+  }
+  if want := "http://ex.com/ page\nhttp://ex.com/frame café"; strings.Join(got, "\n") != want {
+    t.Errorf("pages\n%s\nwant\n%s", strings.Join(got, "\n"), want)
+  }
+}
+
+func TestHARReaderErrors(t *testing.T) {
+  r := NewHARReader(strings.NewReader(`{"log": {"entries": [`), nil)
+  r.Name = "session.har"
+  if _, err := r.Next(); err == nil || !strings.HasPrefix(err.Error(), "session.har: ") {
+    t.Errorf("truncated har: %v", err)
+  }
+
+  src := `{"log": {"entries": [
+    {"request": {"url": "http://ex.com/a"},
+      "response": {"status": 200, "content": {"mimeType": "text/html", "text": "!!", "encoding": "base64"}}},
+    {"request": {"url": ""},
+      "response": {"status": 200, "content": {"mimeType": "text/html", "text": "<p>b</p>"}}},
+    {"request": {"url": "http://ex.com/c"},
+      "response": {"status": 200, "content": {"mimeType": "text/html", "text": "<p>c</p>"}}},
+    {"request": {"url": "http://ex.com/d"},
+      "response": {"status": 200, "content": {"mimeType": "text/html; charset=euc-kr", "text": "PHA+ZDwvcD4=", "encoding": "base64"}}}
+  ]}}`
+  if _, err := readPages(NewHARReader(strings.NewReader(src), nil)); err == nil || !strings.Contains(err.Error(), "entry 0: ") {
+    t.Errorf("bad base64: %v", err)
+  }
+  r = NewHARReader(strings.NewReader(src), &dom.LoadOptions{ Lenient: true })
+  pages, err := readPages(r)
+  if err != nil || len(pages) != 1 || len(r.Skipped) != 3 {
+    t.Errorf("lenient: pages %v, %d skipped, %v", pages, len(r.Skipped), err)
+  }
+  if len(r.Skipped) == 3 && !strings.Contains(r.Skipped[1].Error(), "entry 1: missing url") {
+    t.Errorf("skipped %s", r.Skipped[1])
+  }
+  if len(r.Skipped) == 3 && !strings.Contains(r.Skipped[2].Error(), `entry 3: unsupported charset "euc-kr"`) {
+    t.Errorf("skipped %s", r.Skipped[2])
+  }
+}
+
+func TestMHTMLReader(t *testing.T) {
+  src := "From: <Saved by Blink>\r\n" +
+  "Snapshot-Content-Location: http://ex.com/page\r\n" +
+  "MIME-Version: 1.0\r\n" +
+  "Content-Type: multipart/related; type=\"text/html\"; boundary=\"----b\"\r\n" +
+  "\r\n" +
+  "------b\r\n" +
+  "Content-Type: text/html\r\n" +
+  "Content-Transfer-Encoding: quoted-printable\r\n" +
+  "\r\n" +
+  "<p class=3D\"x\">main=\r\n page</p>\r\n" +
+  "------b\r\n" +
+  "Content-Type: text/css\r\n" +
+  "Content-Location: http://ex.com/style.css\r\n" +
+  "\r\n" +
+  "p {}\r\n" +
+  "------b\r\n" +
+  "Content-Type: text/html; charset=iso-8859-1\r\n" +
+  "Content-Transfer-Encoding: base64\r\n" +
+  "Content-Location: http://ads.com/frame\r\n" +
+  "\r\n" +
+  base64.StdEncoding.EncodeToString([]byte("<p>caf\xe9</p>")) + "\r\n" +
+  "------b--\r\n"
+
+  got, err := readPages(NewMHTMLReader(strings.NewReader(src), nil))
+  if err != nil {
+    t.Fatal(err)
+  }
+  if want := "http://ex.com/page main page\nhttp://ads.com/frame café"; strings.Join(got, "\n") != want {
+    t.Errorf("pages\n%s\nwant\n%s", strings.Join(got, "\n"), want)
+  }
+}
+
+func TestMHTMLReaderSingleDocument(t *testing.T) {
+  src := "Snapshot-Content-Location: http://ex.com/one\r\nContent-Type: text/html\r\n\r\n<p>one</p>"
+  r := NewMHTMLReader(strings.NewReader(src), nil)
+  got, err := readPages(r)
+  if err != nil || strings.Join(got, "\n") != "http://ex.com/one one" {
+    t.Errorf("pages %v, %v", got, err)
+  }
+  if _, err := r.Next(); err != io.EOF {
+    t.Errorf("after the end: %v", err)
+  }
+}
+
+func TestMHTMLReaderErrors(t *testing.T) {
+  tests := []struct {
+    name, src, err string
+  }{
+    {"no header", "", "EOF"},
+    {"no boundary", "Content-Type: multipart/related\r\n\r\n", "multipart message without a boundary"},
+    {"bad content type", "Content-Type: ;;\r\n\r\n", "mime"},
+    {"truncated", "Content-Type: multipart/related; boundary=b\r\n\r\n--b\r\nContent-Type: text/html\r\n\r\n<p>x",
+      "part 1: "},
+    {"unsupported charset", "Content-Type: multipart/related; boundary=b\r\n\r\n--b\r\nContent-Type: text/html; charset=koi8-r\r\n\r\n<p>x</p>\r\n--b--\r\n",
+      `part 1: unsupported charset "koi8-r"`},
+  }
+  for _,test := range tests {
+    r := NewMHTMLReader(strings.NewReader(test.src), nil)
+    r.Name = "page.mhtml"
+    _, err := readPages(r)
+    if err == nil || !strings.Contains(err.Error(), test.err) || !strings.HasPrefix(err.Error(), "page.mhtml: ") {
+      t.Errorf("%s: error %v, want %q", test.name, err, test.err)
+    }
+  }
+}
+
+func TestSourceFor(t *testing.T) {
+  tests := []struct {
+    name, format string
+  }{
+    {"crawl.warc", FormatWARC},
+    {"CRAWL.WARC.GZ", FormatWARC},
+    {"session.har.zst", FormatHAR},
+    {"page.mht", FormatMHTML},
+    {"page.mhtml.gz", FormatMHTML},
+    {"entries.jsonl", FormatJSON},
+    {"entries.txt", FormatJSON},
+    {"warc.gz.jsonl", FormatJSON},
+  }
+  for _,test := range tests {
+    if got := formatOf(test.name); got != test.format {
+      t.Errorf("format of %s = %s, want %s", test.name, got, test.format)
+    }
+  }
+
+  auto, err := SourceFor(FormatAuto)
+  if err != nil {
+    t.Fatal(err)
+  }
+  har := `{"log": {"entries": [{"request": {"url": "http://ex.com/"}, "response": {"status": 200, "content": {"mimeType": "text/html", "text": "<p>x</p>"}}}]}}`
+  if _,ok := auto("a.har", strings.NewReader(har), nil).(*HARReader); !ok {
+    t.Error("auto didn't pick the har reader for a.har")
+  }
+  if _, err := SourceFor("csv"); err == nil {
+    t.Error("no error for an unknown format")
+  }
+}
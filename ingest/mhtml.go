@@ -0,0 +1,181 @@
+package ingest
+
+import (
+  "bufio"
+  "encoding/base64"
+  "fmt"
+  "io"
+  "io/ioutil"
+  "mime"
+  "mime/multipart"
+  "mime/quotedprintable"
+  "net/mail"
+  "net/textproto"
+  "strings"
+
+  "github.com/predictive-edge/dom-cluster/dom"
+)
+
+/*
+MHTMLReader turns the html parts of an mhtml snapshot (a multipart/related
+mime message, as saved by browsers) into entries. The main document and every
+iframe document are saved as parts of their own, so each becomes a separate
+entry, with the part's Content-Location as its Uri. The main document falls
+back to the snapshot's location if its part has none.
+
+Parts are decoded from base64 or quoted-printable and then from their
+charset. A snapshot that is a single html document rather than a multipart
+message yields that one page.
+*/
+type MHTMLReader struct {
+  Name string // reported in diagnostics, usually the file name
+  Skipped []*dom.Diagnostic // problems with pages skipped in lenient mode
+
+  r io.Reader
+  opts *dom.LoadOptions
+  msg *mail.Message
+  mr *multipart.Reader
+  parts int
+  done bool
+}
+
+func NewMHTMLReader(r io.Reader, opts *dom.LoadOptions) *MHTMLReader {
+  if opts == nil {
+    opts = &dom.LoadOptions{ Limits: dom.DefaultLimits() }
+  }
+  return &MHTMLReader{
+    r: r,
+    opts: opts,
+  }
+}
+
+func (m *MHTMLReader) SkippedEntries() []*dom.Diagnostic {
+  return m.Skipped
+}
+
+func (m *MHTMLReader) diagnostic(format string, args ...interface{}) *dom.Diagnostic {
+  return &dom.Diagnostic{
+    File: m.Name,
+    Message: fmt.Sprintf(format, args...),
+  }
+}
+
+// reads a part's body, undoing its transfer encoding
+func partBody(header textproto.MIMEHeader, body io.Reader) ([]byte, error) {
+  switch strings.ToLower(strings.TrimSpace(header.Get("Content-Transfer-Encoding"))) {
+  case "base64":
+    body = base64.NewDecoder(base64.StdEncoding, body)
+  case "quoted-printable":
+    body = quotedprintable.NewReader(body)
+  }
+  return ioutil.ReadAll(body)
+}
+
+// the page of one part (or of the whole message if it isn't multipart), nil
+// if it isn't html
+func (m *MHTMLReader) page(header textproto.MIMEHeader, body io.Reader, where string) (*dom.Entry, *dom.Diagnostic) {
+  contentType := header.Get("Content-Type")
+  if !isHTML(contentType) {
+    return nil, nil
+  }
+  data, err := partBody(header, body)
+  if err != nil {
+    return nil, m.diagnostic("%s: %s", where, err)
+  }
+
+  uri := header.Get("Content-Location")
+  if uri == "" && m.parts <= 1 {
+    uri = m.msg.Header.Get("Snapshot-Content-Location")
+  }
+  entry, err := htmlPage(uri, contentType, data)
+  if err != nil {
+    return nil, m.diagnostic("%s: %s", where, err)
+  }
+  return entry, nil
+}
+
+// reads the message header, setting up the part reader if it is multipart
+func (m *MHTMLReader) open() *dom.Diagnostic {
+  msg, err := mail.ReadMessage(bufio.NewReader(m.r))
+  m.r = nil
+  if err != nil {
+    return m.diagnostic("%s", err)
+  }
+  m.msg = msg
+
+  mediaType, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+  if err != nil {
+    return m.diagnostic("%s", err)
+  }
+  if strings.HasPrefix(mediaType, "multipart/") {
+    if params["boundary"] == "" {
+      return m.diagnostic("multipart message without a boundary")
+    }
+    m.mr = multipart.NewReader(msg.Body, params["boundary"])
+  }
+  return nil
+}
+
+// the next html page of the snapshot, nil at the end
+func (m *MHTMLReader) nextPage() (*dom.Entry, string, *dom.Diagnostic) {
+  for {
+    if m.msg == nil {
+      // the header couldn't be read
+      return nil, "", nil
+    }
+    if m.mr == nil {
+      // a single document, or nothing left of one
+      if m.done {
+        return nil, "", nil
+      }
+      m.done = true
+      m.parts++
+      entry, diag := m.page(textproto.MIMEHeader(m.msg.Header), m.msg.Body, "message")
+      return entry, "message", diag
+    }
+
+    part, err := m.mr.NextRawPart()
+    if err == io.EOF {
+      return nil, "", nil
+    }
+    m.parts++
+    where := fmt.Sprintf("part %d", m.parts)
+    if err != nil {
+      // the rest of the message can't be found without its boundaries
+      m.mr = nil
+      m.done = true
+      return nil, where, m.diagnostic("%s: %s", where, err)
+    }
+    entry, diag := m.page(part.Header, part, where)
+    if entry != nil || diag != nil {
+      return entry, where, diag
+    }
+  }
+}
+
+// Next returns the entry of the next html document in the snapshot
+func (m *MHTMLReader) Next() (*dom.Entry, error) {
+  if m.r != nil {
+    if diag := m.open(); diag != nil {
+      return nil, diag
+    }
+  }
+
+  for {
+    entry, where, diag := m.nextPage()
+    var diags []*dom.Diagnostic
+    if diag != nil {
+      diags = []*dom.Diagnostic{ diag }
+    } else if entry != nil {
+      diags = validatePage(entry, m.opts.Limits, m.Name, where)
+    } else {
+      return nil, io.EOF
+    }
+    if len(diags) == 0 {
+      return entry, nil
+    }
+    if err := skipPage(diags, m.opts, &m.Skipped); err != nil {
+      return nil, err
+    }
+  }
+}
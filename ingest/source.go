@@ -0,0 +1,113 @@
+package ingest
+
+import (
+  "fmt"
+  "io"
+  "log"
+  "strings"
+
+  "github.com/predictive-edge/dom-cluster/dom"
+)
+
+// input formats accepted by SourceFor
+const (
+  FormatAuto = "auto"
+  FormatJSON = "jsonl"
+  FormatWARC = "warc"
+  FormatHAR = "har"
+  FormatMHTML = "mhtml"
+)
+
+// htmlPage decodes an html document body from its charset and parses it. a
+// charset Decode doesn't support is an error, rather than a page of mojibake
+func htmlPage(uri string, contentType string, body []byte) (*dom.Entry, error) {
+  charset := DetectCharset(contentType, body)
+  if !hasBOM(body) && !SupportedCharset(charset) {
+    return nil, fmt.Errorf("unsupported charset %q", charset)
+  }
+  return &dom.Entry{
+    Uri: uri,
+    Dom: dom.ParseHTMLString(Decode(body, charset)),
+  }, nil
+}
+
+// validates a page read from a capture file, labeling its diagnostics with
+// the file and where in it the page came from, e.g. "record 3"
+func validatePage(entry *dom.Entry, limits *dom.Limits, file string, where string) []*dom.Diagnostic {
+  diags := dom.ValidateEntry(entry, limits)
+  for _,diag := range diags {
+    diag.File = file
+    diag.Message = where + ": " + diag.Message
+  }
+  return diags
+}
+
+// fails with the first problem of an invalid page, or in lenient mode logs
+// them all and adds them to skipped
+func skipPage(diags []*dom.Diagnostic, opts *dom.LoadOptions, skipped *[]*dom.Diagnostic) error {
+  if !opts.Lenient {
+    return diags[0]
+  }
+  for _,diag := range diags {
+    log.Printf("skipping page: %s", diag.Error())
+  }
+  *skipped = append(*skipped, diags...)
+  return nil
+}
+
+func warcSource(name string, r io.Reader, opts *dom.LoadOptions) dom.EntrySource {
+  wr := NewWARCReader(r, opts)
+  wr.Name = name
+  return wr
+}
+
+func harSource(name string, r io.Reader, opts *dom.LoadOptions) dom.EntrySource {
+  hr := NewHARReader(r, opts)
+  hr.Name = name
+  return hr
+}
+
+func mhtmlSource(name string, r io.Reader, opts *dom.LoadOptions) dom.EntrySource {
+  mr := NewMHTMLReader(r, opts)
+  mr.Name = name
+  return mr
+}
+
+// picks the format of a file from its name, e.g. crawl.warc.gz. a trailing
+// compression extension is ignored
+func formatOf(name string) string {
+  lower := strings.ToLower(name)
+  for _,ext := range []string{".gz", ".zst"} {
+    lower = strings.TrimSuffix(lower, ext)
+  }
+  switch {
+  case strings.HasSuffix(lower, ".warc"):
+    return FormatWARC
+  case strings.HasSuffix(lower, ".har"):
+    return FormatHAR
+  case strings.HasSuffix(lower, ".mht"), strings.HasSuffix(lower, ".mhtml"):
+    return FormatMHTML
+  }
+  return FormatJSON
+}
+
+// SourceFor returns the dom.SourceFunc reading files of the given format,
+// for use as MultiEntryReader.Source. auto picks by file name
+func SourceFor(format string) (dom.SourceFunc, error) {
+  switch format {
+  case FormatJSON:
+    return dom.JSONSource, nil
+  case FormatWARC:
+    return warcSource, nil
+  case FormatHAR:
+    return harSource, nil
+  case FormatMHTML:
+    return mhtmlSource, nil
+  case FormatAuto:
+    return func(name string, r io.Reader, opts *dom.LoadOptions) dom.EntrySource {
+      source, _ := SourceFor(formatOf(name))
+      return source(name, r, opts)
+    }, nil
+  }
+  return nil, fmt.Errorf("unknown input format %q", format)
+}
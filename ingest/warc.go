@@ -0,0 +1,206 @@
+package ingest
+
+import (
+  "bufio"
+  "bytes"
+  "compress/gzip"
+  "fmt"
+  "io"
+  "io/ioutil"
+  "mime"
+  "net/http"
+  "net/textproto"
+  "strconv"
+  "strings"
+
+  "github.com/predictive-edge/dom-cluster/dom"
+)
+
+// WARCRecord is one record of a warc file. Block is only valid until the next
+// record is read
+type WARCRecord struct {
+  Version string // e.g. WARC/1.0
+  Header textproto.MIMEHeader
+  Block io.Reader
+}
+
+func (r *WARCRecord) Type() string {
+  return r.Header.Get("WARC-Type")
+}
+
+func (r *WARCRecord) TargetURI() string {
+  // some writers wrap the uri in angle brackets, as warc 1.1 once specified
+  return strings.Trim(r.Header.Get("WARC-Target-URI"), "<>")
+}
+
+/*
+WARCReader turns the response records of a warc file into entries. Only
+successful (2xx) responses with an html or xhtml content type are kept; the
+http payload is dechunked, gunzipped if needed, decoded from its charset and
+parsed with dom.ParseHTMLString. Each entry's Uri is the record's target uri.
+
+A .warc.gz file is a series of gzip members, which OpenInput already reads as
+one stream.
+*/
+type WARCReader struct {
+  Name string // reported in diagnostics, usually the file name
+  Skipped []*dom.Diagnostic // problems with pages skipped in lenient mode
+
+  br *bufio.Reader
+  tp *textproto.Reader
+  opts *dom.LoadOptions
+  cur *WARCRecord
+  records int
+}
+
+func NewWARCReader(r io.Reader, opts *dom.LoadOptions) *WARCReader {
+  if opts == nil {
+    opts = &dom.LoadOptions{ Limits: dom.DefaultLimits() }
+  }
+  br := bufio.NewReaderSize(r,64*1024)
+  return &WARCReader{
+    br: br,
+    tp: textproto.NewReader(br),
+    opts: opts,
+  }
+}
+
+func (w *WARCReader) SkippedEntries() []*dom.Diagnostic {
+  return w.Skipped
+}
+
+func (w *WARCReader) diagnostic(format string, args ...interface{}) *dom.Diagnostic {
+  return &dom.Diagnostic{
+    File: w.Name,
+    Message: fmt.Sprintf("record %d: ", w.records) + fmt.Sprintf(format, args...),
+  }
+}
+
+// NextRecord reads the header of the next record, skipping whatever is left
+// of the previous one. returns io.EOF at the end of the file
+func (w *WARCReader) NextRecord() (*WARCRecord, error) {
+  if w.cur != nil {
+    if _,err := io.Copy(ioutil.Discard, w.cur.Block); err != nil {
+      return nil, err
+    }
+    w.cur = nil
+  }
+
+  // records are separated by two blank lines
+  var version string
+  for version == "" {
+    line, err := w.tp.ReadLine()
+    if err != nil {
+      if err == io.EOF && line == "" {
+        return nil, io.EOF
+      }
+      return nil, err
+    }
+    version = strings.TrimSpace(line)
+  }
+  w.records++
+  if !strings.HasPrefix(version, "WARC/") {
+    return nil, w.diagnostic("expected a warc version line, found %q", version)
+  }
+
+  header, err := w.tp.ReadMIMEHeader()
+  if err != nil {
+    return nil, w.diagnostic("%s", err)
+  }
+  length, err := strconv.ParseInt(header.Get("Content-Length"), 10, 64)
+  if err != nil || length < 0 {
+    return nil, w.diagnostic("bad Content-Length %q", header.Get("Content-Length"))
+  }
+
+  w.cur = &WARCRecord{
+    Version: version,
+    Header: header,
+    Block: io.LimitReader(w.br, length),
+  }
+  return w.cur, nil
+}
+
+func isHTML(contentType string) bool {
+  mediaType, _, err := mime.ParseMediaType(contentType)
+  if err != nil {
+    return false
+  }
+  return mediaType == "text/html" || mediaType == "application/xhtml+xml"
+}
+
+// reports whether a record's block is an http message rather than, e.g., the
+// answer to a dns: lookup
+func isHTTPRecord(rec *WARCRecord) bool {
+  mediaType, _, err := mime.ParseMediaType(rec.Header.Get("Content-Type"))
+  return err == nil && mediaType == "application/http"
+}
+
+// reads the http response in a response record's block. returns a nil entry
+// for responses that aren't html pages, over http or not
+func (w *WARCReader) responseEntry(rec *WARCRecord) (*dom.Entry, *dom.Diagnostic) {
+  if !isHTTPRecord(rec) {
+    return nil, nil
+  }
+  resp, err := http.ReadResponse(bufio.NewReader(rec.Block), nil)
+  if err != nil {
+    return nil, w.diagnostic("%s", err)
+  }
+  defer resp.Body.Close()
+  if resp.StatusCode < 200 || resp.StatusCode > 299 {
+    return nil, nil
+  }
+  contentType := resp.Header.Get("Content-Type")
+  if !isHTML(contentType) {
+    return nil, nil
+  }
+
+  body, err := ioutil.ReadAll(resp.Body)
+  if err != nil {
+    return nil, w.diagnostic("%s", err)
+  }
+  if strings.EqualFold(resp.Header.Get("Content-Encoding"), "gzip") {
+    gz, err := gzip.NewReader(bytes.NewReader(body))
+    if err != nil {
+      return nil, w.diagnostic("%s", err)
+    }
+    if body, err = ioutil.ReadAll(gz); err != nil {
+      return nil, w.diagnostic("%s", err)
+    }
+  }
+
+  entry, err := htmlPage(rec.TargetURI(), contentType, body)
+  if err != nil {
+    return nil, w.diagnostic("%s", err)
+  }
+  return entry, nil
+}
+
+// Next returns the entry of the next html response in the file
+func (w *WARCReader) Next() (*dom.Entry, error) {
+  for {
+    rec, err := w.NextRecord()
+    if err != nil {
+      return nil, err
+    }
+    if rec.Type() != "response" {
+      continue
+    }
+
+    entry, diag := w.responseEntry(rec)
+    var diags []*dom.Diagnostic
+    if diag != nil {
+      diags = []*dom.Diagnostic{ diag }
+    } else if entry != nil {
+      diags = validatePage(entry, w.opts.Limits, w.Name, fmt.Sprintf("record %d", w.records))
+    }
+    if len(diags) == 0 {
+      if entry != nil {
+        return entry, nil
+      }
+      continue
+    }
+    if err := skipPage(diags, w.opts, &w.Skipped); err != nil {
+      return nil, err
+    }
+  }
+}
@@ -0,0 +1,200 @@
+package ingest
+
+import (
+  "bytes"
+  "compress/gzip"
+  "fmt"
+  "io"
+  "strings"
+  "testing"
+
+  "github.com/predictive-edge/dom-cluster/dom"
+)
+
+// a warc record with the given block, an http message for requests and
+// responses
+func warcRecord(typ, uri, block string) string {
+  contentType := "application/warc-fields"
+  if typ == "request" || typ == "response" {
+    contentType = "application/http; msgtype=" + typ
+  }
+  return typedWARCRecord(typ, contentType, uri, block)
+}
+
+func typedWARCRecord(typ, contentType, uri, block string) string {
+  return fmt.Sprintf("WARC/1.0\r\nWARC-Type: %s\r\nContent-Type: %s\r\nWARC-Target-URI: %s\r\nContent-Length: %d\r\n\r\n%s\r\n\r\n",
+  typ, contentType, uri, len(block), block)
+}
+
+// an http response with the given headers, one per line
+func httpResponse(status string, headers string, body string) string {
+  return "HTTP/1.1 " + status + "\r\n" + strings.Replace(headers, "\n", "\r\n", -1) +
+  fmt.Sprintf("\r\nContent-Length: %d\r\n\r\n", len(body)) + body
+}
+
+func gzipString(s string) string {
+  buf := &bytes.Buffer{}
+  gz := gzip.NewWriter(buf)
+  gz.Write([]byte(s))
+  gz.Close()
+  return buf.String()
+}
+
+// the uris and parsed text of every entry of src, and the error ending it
+func readPages(src dom.EntrySource) ([]string, error) {
+  pages := []string{}
+  for {
+    entry, err := src.Next()
+    if err == io.EOF {
+      return pages, nil
+    }
+    if err != nil {
+      return pages, err
+    }
+    text := ""
+    entry.Dom.CallPreOrder(func(n *dom.Node) {
+      if n.NodeName == "#text" {
+        text += n.Text
+      }
+    })
+    pages = append(pages, entry.Uri + " " + text)
+  }
+}
+
+func TestWARCReader(t *testing.T) {
+  chunked := "HTTP/1.1 200 OK\r\nContent-Type: text/html\r\nTransfer-Encoding: chunked\r\n\r\n" +
+  "6\r\n<p>chu\r\n7\r\nnked</p\r\n1\r\n>\r\n0\r\n\r\n"
+  src := warcRecord("warcinfo", "", "software: test\r\n") +
+  warcRecord("request", "http://ex.com/a", "GET /a HTTP/1.1\r\nHost: ex.com\r\n\r\n") +
+  warcRecord("response", "http://ex.com/a", httpResponse("200 OK", "Content-Type: text/html; charset=utf-8", "<p>plain</p>")) +
+  warcRecord("response", "<http://ex.com/b>", chunked) +
+  warcRecord("response", "http://ex.com/c", httpResponse("200 OK", "Content-Type: text/html\nContent-Encoding: gzip", gzipString("<p>zipped</p>"))) +
+  warcRecord("response", "http://ex.com/d", httpResponse("200 OK", "Content-Type: text/html; charset=iso-8859-1", "<p>caf\xe9</p>")) +
+  warcRecord("response", "http://ex.com/e", httpResponse("200 OK", "Content-Type: text/html", `<meta charset="windows-1252"><p>`+"\x93q\x94</p>")) +
+  warcRecord("response", "http://ex.com/missing", httpResponse("404 Not Found", "Content-Type: text/html", "<p>gone</p>")) +
+  warcRecord("response", "http://ex.com/img.png", httpResponse("200 OK", "Content-Type: image/png", "\x89PNG")) +
+  warcRecord("response", "http://ex.com/x", httpResponse("200 OK", "Content-Type: application/xhtml+xml", "<p>x</p>")) +
+  // responses that aren't http messages
+  typedWARCRecord("response", "text/dns", "dns:ex.com", "20240101000000\nex.com. 300 IN A 192.0.2.1\n") +
+  typedWARCRecord("response", "application/octet-stream", "http://ex.com/raw", "<p>raw</p>") +
+  typedWARCRecord("response", "APPLICATION/HTTP;msgtype=response", "http://ex.com/y",
+    httpResponse("200 OK", "Content-Type: text/html", "<p>y</p>"))
+
+  got, err := readPages(NewWARCReader(strings.NewReader(src), nil))
+  if err != nil {
+    t.Fatal(err)
+  }
+  want := []string{
+    "http://ex.com/a plain",
+    "http://ex.com/b chunked",
+    "http://ex.com/c zipped",
+    "http://ex.com/d café",
+    "http://ex.com/e “q”",
+    "http://ex.com/x x",
+    "http://ex.com/y y",
+  }
+  if strings.Join(got, "\n") != strings.Join(want, "\n") {
+    t.Errorf("pages\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+  }
+}
+
+func TestWARCReaderErrors(t *testing.T) {
+  good := warcRecord("response", "http://ex.com/a", httpResponse("200 OK", "Content-Type: text/html", "<p>a</p>"))
+  tests := []struct {
+    name, src, err string
+  }{
+    {"not warc", "HTTP/1.1 200 OK\r\n\r\n", "record 1: expected a warc version line"},
+    {"bad length", "WARC/1.0\r\nWARC-Type: response\r\nContent-Length: x\r\n\r\n", `record 1: bad Content-Length "x"`},
+    {"bad response", good + warcRecord("response", "http://ex.com/b", "garbage"), "record 2: malformed HTTP"},
+    {"no url", warcRecord("response", "", httpResponse("200 OK", "Content-Type: text/html", "<p>b</p>")), "record 1: missing url"},
+    {"unsupported charset", warcRecord("response", "http://ex.com/b", httpResponse("200 OK", "Content-Type: text/html; charset=shift_jis", "<p>\x82\xa0</p>")),
+      `record 1: unsupported charset "shift_jis"`},
+    {"unsupported meta charset", warcRecord("response", "http://ex.com/b", httpResponse("200 OK", "Content-Type: text/html", `<meta charset="gbk"><p>x</p>`)),
+      `record 1: unsupported charset "gbk"`},
+  }
+  for _,test := range tests {
+    r := NewWARCReader(strings.NewReader(test.src), nil)
+    r.Name = "crawl.warc"
+    _, err := readPages(r)
+    if err == nil || !strings.Contains(err.Error(), test.err) || !strings.HasPrefix(err.Error(), "crawl.warc: ") {
+      t.Errorf("%s: error %v, want %q", test.name, err, test.err)
+    }
+  }
+
+  // lenient mode skips the broken record and carries on
+  src := warcRecord("response", "http://ex.com/b", "garbage") + good
+  r := NewWARCReader(strings.NewReader(src), &dom.LoadOptions{ Lenient: true })
+  pages, err := readPages(r)
+  if err != nil || len(pages) != 1 || len(r.Skipped) != 1 {
+    t.Errorf("lenient: pages %v, %d skipped, %v", pages, len(r.Skipped), err)
+  }
+}
+
+func TestDetectCharset(t *testing.T) {
+  tests := []struct {
+    contentType, body, want string
+  }{
+    {"text/html; charset=ISO-8859-1", `<meta charset="utf-8">`, "ISO-8859-1"},
+    {"text/html", `<meta charset="utf-8">`, "utf-8"},
+    {"text/html", `<meta http-equiv="Content-Type" content="text/html; charset=windows-1252">`, "windows-1252"},
+    {"", `<META CHARSET=Shift_JIS>`, "Shift_JIS"},
+    {"text/html", strings.Repeat(" ", 1100) + `<meta charset="utf-8">`, ""},
+    {"text/html", `<p>none</p>`, ""},
+  }
+  for _,test := range tests {
+    if got := DetectCharset(test.contentType, []byte(test.body)); got != test.want {
+      t.Errorf("DetectCharset(%q, %.30q) = %q, want %q", test.contentType, test.body, got, test.want)
+    }
+  }
+}
+
+func TestSupportedCharset(t *testing.T) {
+  tests := []struct {
+    charset string
+    want bool
+  }{
+    {"", true},
+    {"UTF-8", true},
+    {" latin1 ", true},
+    {"utf-16be", true},
+    {"iso-8859-2", false},
+    {"shift_jis", false},
+    {"gbk", false},
+  }
+  for _,test := range tests {
+    if got := SupportedCharset(test.charset); got != test.want {
+      t.Errorf("SupportedCharset(%q) = %t, want %t", test.charset, got, test.want)
+    }
+  }
+}
+
+func TestHTMLPageCharset(t *testing.T) {
+  if _,err := htmlPage("http://ex.com/", "text/html; charset=iso-8859-5", []byte("<p>\xbf</p>")); err == nil {
+    t.Error("no error for an unsupported charset")
+  }
+  // a byte order mark overrides the charset
+  entry, err := htmlPage("http://ex.com/", "text/html; charset=iso-8859-5", []byte("\xef\xbb\xbf<p>x</p>"))
+  if err != nil || entry.Uri != "http://ex.com/" {
+    t.Errorf("bom: %v", err)
+  }
+}
+
+func TestDecode(t *testing.T) {
+  tests := []struct {
+    body, charset, want string
+  }{
+    {"caf\xc3\xa9", "", "café"},
+    {"caf\xe9", "latin1", "café"},
+    {"\x80 \x9f", "us-ascii", "€ Ÿ"},
+    {"\xef\xbb\xbfcaf\xc3\xa9", "latin1", "café"}, // the bom wins
+    {"\xff\xfeh\x00i\x00", "", "hi"},
+    {"\xfe\xff\x00h\x00i", "utf-8", "hi"},
+    {"h\x00i\x00", "utf-16le", "hi"},
+    {"caf\xe9", "no-such-charset", "caf�"},
+  }
+  for _,test := range tests {
+    if got := Decode([]byte(test.body), test.charset); got != test.want {
+      t.Errorf("Decode(%q, %q) = %q, want %q", test.body, test.charset, got, test.want)
+    }
+  }
+}
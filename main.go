@@ -1,6 +1,10 @@
 package main
 
 import (
+  "context"
+  "encoding/json"
+  "flag"
+  "io/ioutil"
   "math/rand"
   "time"
   "fmt"
@@ -8,79 +12,554 @@ import (
   "io"
   "github.com/predictive-edge/dom-cluster/cluster"
   "github.com/predictive-edge/dom-cluster/dom"
+  "github.com/predictive-edge/dom-cluster/ingest"
+  "github.com/predictive-edge/dom-cluster/report"
+  "github.com/predictive-edge/dom-cluster/server"
   "os"
-  "bufio"
-  "encoding/json"
+  "strconv"
+  "strings"
 )
 
-func getEntries(filename string) []*dom.Entry {
-  f, err := os.Open(filename)
+func getEntries(pattern string, format string, lenient bool) []*dom.Entry {
+  src, err := dom.OpenEntries(strings.Split(pattern, ","), &dom.LoadOptions{
+    Lenient: lenient,
+    Limits: dom.DefaultLimits(),
+  })
   if err != nil {
     log.Fatal(err)
   }
-  defer f.Close()
+  defer src.Close()
+  if src.Source, err = ingest.SourceFor(format); err != nil {
+    log.Fatal(err)
+  }
 
-  r := bufio.NewReaderSize(f,512*1024)
-  //r.ReadLine() // extra read since first one is template
-  line, isPrefix, err := r.ReadLine()
-  entries := []*dom.Entry{}
-  for err == nil && !isPrefix {
-    var entry dom.Entry
-    json.Unmarshal(line, &entry)
+  entries, err := dom.CollectEntries(src)
+  if err != nil {
+    log.Fatal(err)
+  }
+  if len(src.Skipped) > 0 {
+    log.Printf("%d problems in skipped entries", len(src.Skipped))
+  }
 
-    if entry.Dom != nil {
-      entries = append(entries, &entry)
+  return entries
+}
+
+// flags of the noise filter, which is off unless asked for since it changes
+// the wrappers and so the templates, distances and scores of a run
+type filterFlags struct {
+  enabled *bool
+  configFile *string
+}
+
+func addFilterFlags(flags *flag.FlagSet) *filterFlags {
+  return &filterFlags{
+    enabled: flags.Bool("filter", false, "strip scripts, styles, comments, hidden elements, tracking pixels and ads before wrapping"),
+    configFile: flags.String("filterconfig", "", "json file of the filter's tag, attribute and class deny lists etc.; implies -filter"),
+  }
+}
+
+// the filter config asked for, nil for no filtering
+func (f *filterFlags) config() *dom.FilterConfig {
+  if *f.configFile == "" {
+    if *f.enabled {
+      return dom.DefaultFilterConfig()
     }
-    line, isPrefix, err = r.ReadLine()
+    return nil
   }
-  if err != io.EOF && err != nil {
-      log.Fatal(err)
+  file, err := os.Open(*f.configFile)
+  if err != nil {
+    log.Fatal(err)
   }
-  if isPrefix {
-    log.Fatal("buffer size too small")
+  defer file.Close()
+  cfg, err := dom.ReadFilterConfig(file)
+  if err != nil {
+    log.Fatalf("%s: %s", *f.configFile, err)
   }
+  return cfg
+}
+
+// input flags shared by every command that reads and wraps pages
+type inputFlags struct {
+  file *string
+  format *string
+  lenient *bool
+  filter *filterFlags
+  filterLog *bool
+}
 
+func addInputFlags(flags *flag.FlagSet) *inputFlags {
+  return &inputFlags{
+    file: flags.String("in", "./transformedrealdata.txt", "comma separated files, globs or directories of entries, one json entry per line, optionally gzip/zstd compressed"),
+    format: flags.String("informat", "auto", "input format: jsonl, warc, har, mhtml, or auto to pick by file name (*.warc, *.har, *.mht, *.mhtml, optionally .gz/.zst)"),
+    lenient: flags.Bool("lenient", false, "log and skip invalid entries instead of failing"),
+    filter: addFilterFlags(flags),
+    filterLog: flags.Bool("filterlog", false, "log how much of each page the noise filter dropped"),
+  }
+}
+
+// loads entries, strips noise from each page if asked to and converts it
+// into its wrapper
+func getWrappedEntries(in *inputFlags) []*dom.Entry {
+  entries := getEntries(*in.file, *in.format, *in.lenient)
+  filterCfg := in.filter.config()
+  before, after := 0, 0
+  for _,entry := range entries {
+    if filterCfg != nil {
+      stats := dom.Filter(entry.Dom, filterCfg)
+      before += stats.Before
+      after += stats.After
+      if *in.filterLog {
+        log.Printf("filter: %s %s", entry.Uri, stats)
+      }
+    }
+    entry.Dom = cluster.NodeToWrapper(entry.Dom,10)
+  }
+  if filterCfg != nil && *in.filterLog {
+    log.Printf("filter: %d/%d nodes dropped over %d pages", before-after, before, len(entries))
+  }
   return entries
 }
 
-func main() {
-  //defer profile.Start(profile.CPUProfile).Stop()
-  rand.Seed(time.Now().UTC().UnixNano())
+func createOutput(filename string) io.WriteCloser {
+  if filename == "-" {
+    return os.Stdout
+  }
+  f, err := os.Create(filename)
+  if err != nil {
+    log.Fatal(err)
+  }
+  return f
+}
 
-  entries := getEntries("./transformedrealdata.txt")
+// a context done after d, or only once canceled if d is 0
+func timeoutContext(d time.Duration) (context.Context, context.CancelFunc) {
+  if d > 0 {
+    return context.WithTimeout(context.Background(), d)
+  }
+  return context.WithCancel(context.Background())
+}
+
+func runCluster(args []string) {
+  flags := flag.NewFlagSet("cluster", flag.ExitOnError)
+  in := addInputFlags(flags)
+  uriWeight := flags.Float64("uriweight", 0, "weight of url pattern distance vs dom merge score, from 0 to 1; greedy mode only")
+  mode := flags.String("mode", "greedy", "clustering mode: greedy, kmedoids or dbscan")
+  k := flags.Int("k", 0, "number of clusters for kmedoids, 0 to pick by silhouette")
+  kMax := flags.Int("kmax", 20, "largest k tried when picking k automatically")
+  eps := flags.Float64("eps", cluster.MergeScoreCutoff, "dbscan neighborhood radius in merge score distance")
+  minPts := flags.Int("minpts", 3, "dbscan minimum neighborhood size of a core page, itself included")
+  reportFile := flags.String("report", "", "if set, also write an html report of the templates here")
+  saveFile := flags.String("save", "", "if set, store the templates here for the monitor command")
+  progress := flags.Bool("progress", false, "log the progress of greedy clustering")
+  statsFile := flags.String("stats", "", "if set, write a json summary of the greedy clustering run here")
+  timeout := flags.Duration("timeout", 0, "stop clustering after this long; greedy mode keeps the templates built so far, the others give up. 0 for no limit")
+  flags.Parse(args)
+  // the other modes compare pages through a distance matrix of dom merge
+  // scores alone
+  if *uriWeight != 0 && *mode != "greedy" {
+    log.Fatalf("-uriweight only applies to greedy clustering, not %s", *mode)
+  }
+
+  entries := getWrappedEntries(in)
   //entry := entries[0]
   //templatizedNode := TemplatizeNode(&entry.Dom,10)
   //fmt.Println(templatizedNode)
 
-  //wrappers := []*dom.Node{}
-  for _,entry := range entries {
-    //fmt.Println(entry.Uri)
-    entry.Dom = cluster.NodeToWrapper(entry.Dom,10)
-    //wrappers = append(wrappers, cluster.NodeToWrapper(&entry.Dom,10))
+  ctx, cancel := timeoutContext(*timeout)
+  defer cancel()
+
+  var templates []*cluster.Template
+  var noise []*dom.Entry
+  var clusterErr error
+  switch *mode {
+  case "greedy":
+    opts := cluster.DefaultOptions()
+    opts.UriWeight = *uriWeight
+    stats := cluster.NewRunStats(entries)
+    opts.Progress = func(p *cluster.Progress) {
+      stats.Observe(p)
+      if *progress && p.Event != cluster.ProgressMerge {
+        log.Print(p)
+      }
+    }
+    templates, clusterErr = cluster.DoClusterContext(ctx, entries, opts)
+    if clusterErr != nil {
+      assigned := 0
+      for _,t := range templates {
+        assigned += t.NumPages
+      }
+      log.Printf("clustering stopped: %s; %d templates of %d/%d pages built so far", clusterErr, len(templates), assigned, len(entries))
+    }
+    if *statsFile != "" {
+      out := createOutput(*statsFile)
+      if err := stats.WriteJSON(out); err != nil {
+        log.Fatal(err)
+      }
+      out.Close()
+    }
+  case "kmedoids":
+    m, err := cluster.ComputeDistanceMatrixContext(ctx, entries, 0, cluster.DenseMatrix)
+    if err != nil {
+      log.Fatalf("computing the distance matrix: %s", err)
+    }
+    var result *cluster.MedoidClustering
+    if *k > 0 {
+      result = cluster.KMedoids(m, *k)
+    } else {
+      result = cluster.KMedoidsAuto(m, 2, *kMax)
+    }
+    fmt.Printf("k=%d silhouette=%f\n", result.NumClusters, result.Silhouette)
+    // templates are seeded from their medoid, so BaseUri is the medoid uri
+    templates = cluster.TemplatesFromClustering(entries, &result.Clustering, result.Medoids)
+  case "dbscan":
+    m, err := cluster.ComputeDistanceMatrixContext(ctx, entries, 0, *eps)
+    if err != nil {
+      log.Fatalf("computing the distance matrix: %s", err)
+    }
+    result := cluster.DBSCAN(m, *eps, *minPts)
+    templates = cluster.TemplatesFromClustering(entries, result, nil)
+    noise = cluster.NoiseEntries(entries, result)
+  default:
+    log.Fatalf("unknown clustering mode %q", *mode)
+  }
+
+  for _,t := range templates {
+    fmt.Println(t.BaseUri)
+    fmt.Println(t.UriPattern)
+    fmt.Println(t.Uris)
+  }
+  if len(noise) > 0 {
+    fmt.Println("noise")
+    for _,entry := range noise {
+      fmt.Println(entry.Uri)
+    }
+  }
+
+  if *reportFile != "" {
+    out := createOutput(*reportFile)
+    if err := report.Write(out, templates, noise, report.DefaultOptions()); err != nil {
+      log.Fatal(err)
+    }
+    out.Close()
+  }
+  if *saveFile != "" {
+    out := createOutput(*saveFile)
+    if err := cluster.SaveTemplates(out, templates); err != nil {
+      log.Fatal(err)
+    }
+    out.Close()
+  }
+  // partial results are still written, but batch jobs should see they are
+  if clusterErr != nil {
+    os.Exit(1)
+  }
+}
+
+func loadTemplates(filename string) []*cluster.Template {
+  f, err := os.Open(filename)
+  if err != nil {
+    log.Fatal(err)
+  }
+  defer f.Close()
+  templates, err := cluster.LoadTemplates(f)
+  if err != nil {
+    log.Fatalf("%s: %s", filename, err)
+  }
+  return templates
+}
+
+// classifies a day's pages against stored templates, records the day in the
+// history file and alerts on templates that degraded: events are printed as
+// json lines and the exit status is 2
+func runMonitor(args []string) {
+  flags := flag.NewFlagSet("monitor", flag.ExitOnError)
+  in := addInputFlags(flags)
+  templatesFile := flags.String("templates", "templates.json", "templates stored with cluster -save")
+  historyFile := flags.String("history", "history.jsonl", "file of past days' stats, appended to")
+  date := flags.String("date", time.Now().UTC().Format("2006-01-02"), "label of this run in the history")
+  uriWeight := flags.Float64("uriweight", 0, "weight of url pattern distance vs dom merge score, from 0 to 1")
+  th := cluster.DefaultDriftThresholds()
+  flags.Float64Var(&th.MinMatchRate, "minmatch", th.MinMatchRate, "alert below this match rate, 0 to disable")
+  flags.Float64Var(&th.MaxMeanScore, "maxscore", th.MaxMeanScore, "alert above this mean merge score, 0 to disable")
+  flags.Float64Var(&th.MaxMatchRateDrop, "maxdrop", th.MaxMatchRateDrop, "alert when the match rate falls this far below the baseline, 0 to disable")
+  flags.Float64Var(&th.MaxMeanScoreRise, "maxrise", th.MaxMeanScoreRise, "alert when the mean score rises this far above the baseline, 0 to disable")
+  flags.IntVar(&th.BaselineDays, "baseline", th.BaselineDays, "number of previous days averaged for the baseline")
+  flags.IntVar(&th.MinPages, "minpages", th.MinPages, "don't judge templates with fewer pages than this")
+  flags.Parse(args)
+
+  templates := loadTemplates(*templatesFile)
+  entries := getWrappedEntries(in)
+  opts := cluster.DefaultOptions()
+  opts.UriWeight = *uriWeight
+  day := cluster.ClassifyDay(*date, templates, entries, opts)
+
+  history := []*cluster.DayStats{}
+  if f, err := os.Open(*historyFile); err == nil {
+    history, err = cluster.ReadHistory(f)
+    f.Close()
+    if err != nil {
+      log.Fatalf("%s: %s", *historyFile, err)
+    }
+  } else if !os.IsNotExist(err) {
+    log.Fatal(err)
+  }
+  events := cluster.CheckDrift(day, history, th)
+
+  f, err := os.OpenFile(*historyFile, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+  if err != nil {
+    log.Fatal(err)
+  }
+  if err := cluster.AppendHistory(f, day); err != nil {
+    log.Fatal(err)
+  }
+  f.Close()
+
+  enc := json.NewEncoder(os.Stdout)
+  for _,event := range events {
+    log.Print(event)
+    enc.Encode(event)
+  }
+  if len(events) > 0 {
+    os.Exit(2)
+  }
+}
+
+// computes the pairwise distance matrix of all wrappers and exports it
+func runMatrix(args []string) {
+  flags := flag.NewFlagSet("matrix", flag.ExitOnError)
+  in := addInputFlags(flags)
+  outFile := flags.String("out", "-", "output file, - for stdout")
+  format := flags.String("format", "csv", "output format: csv or bin")
+  threshold := flags.Float64("threshold", cluster.DenseMatrix, "if 0 or more, only keep pairs scoring at or below this (sparse output); negative for a dense matrix")
+  workers := flags.Int("workers", 0, "number of parallel workers, 0 for one per cpu")
+  timeout := flags.Duration("timeout", 0, "give up computing the matrix after this long; 0 for no limit")
+  flags.Parse(args)
+
+  entries := getWrappedEntries(in)
+  ctx, cancel := timeoutContext(*timeout)
+  defer cancel()
+  m, err := cluster.ComputeDistanceMatrixContext(ctx, entries, *workers, *threshold)
+  if err != nil {
+    log.Fatalf("computing the distance matrix: %s", err)
+  }
+
+  out := createOutput(*outFile)
+  defer out.Close()
+
+  switch *format {
+  case "csv":
+    err = m.WriteCSV(out)
+  case "bin":
+    err = m.WriteBinary(out)
+  default:
+    log.Fatalf("unknown matrix format %q", *format)
+  }
+  if err != nil {
+    log.Fatal(err)
   }
-  //fmt.Println("====")
+}
 
-  templates := cluster.DoCluster(entries)
+// reruns greedy clustering with different seeds and reports the consensus
+// clustering along with the pages whose assignment is unreliable
+func runStability(args []string) {
+  flags := flag.NewFlagSet("stability", flag.ExitOnError)
+  in := addInputFlags(flags)
+  uriWeight := flags.Float64("uriweight", 0, "weight of url pattern distance vs dom merge score, from 0 to 1")
+  runs := flags.Int("runs", 10, "number of clustering runs")
+  seed := flags.Int64("seed", 1, "seed of the first run, incremented for each following run")
+  minScore := flags.Float64("minscore", 0.8, "pages with a lower stability score are reported as unstable")
+  matrixFile := flags.String("matrix", "", "if set, write the co-association distance matrix here as csv")
+  timeout := flags.Duration("timeout", 0, "give up on the runs after this long; 0 for no limit")
+  flags.Parse(args)
+  if *runs < 1 {
+    log.Fatal("stability needs -runs of at least 1")
+  }
 
+  entries := getWrappedEntries(in)
+  opts := cluster.DefaultOptions()
+  opts.UriWeight = *uriWeight
+  ctx, cancel := timeoutContext(*timeout)
+  defer cancel()
+  stability, err := cluster.ClusterStabilityContext(ctx, entries, *runs, *seed, opts)
+  if err != nil {
+    log.Fatal(err)
+  }
+
+  if *matrixFile != "" {
+    out := createOutput(*matrixFile)
+    if err := stability.CoAssociation.WriteCSV(out); err != nil {
+      log.Fatal(err)
+    }
+    out.Close()
+  }
+
+  templates := cluster.TemplatesFromClustering(entries, stability.Consensus, nil)
   for _,t := range templates {
     fmt.Println(t.BaseUri)
+    fmt.Println(t.UriPattern)
     fmt.Println(t.Uris)
   }
-  //for _,t1 := range wrappers {
-  //  for _,t2 := range wrappers {
-  //    _, score := cluster.NodeMerge(t1,t2)
-  //    if score > 0.4 {
-  //      fmt.Printf("0 ")
-  //    } else {
-  //      fmt.Printf("1 ")
-  //    }
-  //    //fmt.Printf("%f ",score)
-  //  }
-  //  fmt.Printf("\n")
-  //}
-  //a := wrappers[0]
-  //b := wrappers[1]
-  //merged, score := cluster.NodeMerge(a,b)
-  //fmt.Println(merged.Children)
-  //fmt.Println(score)
+  fmt.Println("unstable")
+  for _,i := range stability.Unstable(*minScore) {
+    fmt.Printf("%s %f\n", entries[i].Uri, stability.Scores[i])
+  }
+}
+
+// finds a page by its url or its index in the input
+func findEntry(entries []*dom.Entry, ref string) *dom.Entry {
+  for _,entry := range entries {
+    if entry.Uri == ref {
+      return entry
+    }
+  }
+  i, err := strconv.Atoi(ref)
+  if err != nil || i < 0 || i >= len(entries) {
+    log.Fatalf("no page %q", ref)
+  }
+  return entries[i]
+}
+
+// draws the wrapper of a page, or the alignment of two pages' wrappers, as a
+// graphviz graph
+func runDot(args []string) {
+  flags := flag.NewFlagSet("dot", flag.ExitOnError)
+  in := addInputFlags(flags)
+  page := flags.String("page", "0", "url or index of the page to draw")
+  with := flags.String("with", "", "if set, url or index of a second page; draws how the two wrappers align when merged")
+  outFile := flags.String("out", "-", "output file, - for stdout")
+  flags.Parse(args)
+
+  entries := getWrappedEntries(in)
+  out := createOutput(*outFile)
+  defer out.Close()
+
+  var err error
+  if *with == "" {
+    err = dom.WriteDOT(out, findEntry(entries, *page).Dom)
+  } else {
+    err = cluster.WriteAlignmentDOT(out, findEntry(entries, *page).Dom,
+      findEntry(entries, *with).Dom)
+  }
+  if err != nil {
+    log.Fatal(err)
+  }
+}
+
+// breaks down the merge score of two pages' wrappers
+func runExplain(args []string) {
+  flags := flag.NewFlagSet("explain", flag.ExitOnError)
+  in := addInputFlags(flags)
+  page := flags.String("page", "0", "url or index of the first page")
+  with := flags.String("with", "1", "url or index of the second page")
+  top := flags.Int("top", 20, "number of largest costs listed, 0 for all")
+  flags.Parse(args)
+
+  entries := getWrappedEntries(in)
+  a, b := findEntry(entries, *page), findEntry(entries, *with)
+  _, explanation := cluster.ExplainMerge(a.Dom, b.Dom)
+  fmt.Printf("a: %s\nb: %s\n", a.Uri, b.Uri)
+  fmt.Print(explanation.String(*top))
+}
+
+// reads a wrapper saved as a json node, or else written in the template
+// notation
+func readWrapper(filename string) *dom.Node {
+  data, err := ioutil.ReadFile(filename)
+  if err != nil {
+    log.Fatal(err)
+  }
+  src := strings.TrimSpace(string(data))
+  if strings.HasPrefix(src, "{") {
+    var node dom.Node
+    if err := json.Unmarshal(data, &node); err != nil {
+      log.Fatalf("%s: %s", filename, err)
+    }
+    return &node
+  }
+  node, err := dom.ParseNotation(src)
+  if err != nil {
+    log.Fatalf("%s: %s", filename, err)
+  }
+  return node
+}
+
+// lists the changes between two wrappers, exiting with status 1 if there
+// are any
+func runDiff(args []string) {
+  flags := flag.NewFlagSet("diff", flag.ExitOnError)
+  oldFile := flags.String("old", "", "file of the old wrapper, as json or in the template notation")
+  newFile := flags.String("new", "", "file of the new wrapper, as json or in the template notation")
+  asJSON := flags.Bool("json", false, "write the changes as json")
+  flags.Parse(args)
+  if *oldFile == "" || *newFile == "" {
+    log.Fatal("diff needs both -old and -new")
+  }
+
+  diff := cluster.DiffWrappers(readWrapper(*oldFile), readWrapper(*newFile))
+  if *asJSON {
+    if err := diff.WriteJSON(os.Stdout); err != nil {
+      log.Fatal(err)
+    }
+  } else {
+    fmt.Print(diff)
+  }
+  if !diff.Empty() {
+    os.Exit(1)
+  }
+}
+
+// serves classification and extraction against stored templates over http
+func runServe(args []string) {
+  flags := flag.NewFlagSet("serve", flag.ExitOnError)
+  cfg := server.DefaultConfig()
+  addr := flags.String("addr", ":8080", "address to listen on")
+  flags.StringVar(&cfg.TemplatesFile, "templates", cfg.TemplatesFile, "templates stored with cluster -save")
+  flags.Int64Var(&cfg.MaxBodyBytes, "maxbytes", cfg.MaxBodyBytes, "largest accepted request body")
+  flags.DurationVar(&cfg.Timeout, "timeout", cfg.Timeout, "time limit of each request")
+  flags.Float64Var(&cfg.Options.UriWeight, "uriweight", 0, "weight of url pattern distance vs dom merge score, from 0 to 1")
+  filter := addFilterFlags(flags)
+  flags.Parse(args)
+  // pages must be filtered like the ones the templates were built from
+  cfg.Filter = filter.config()
+
+  s, err := server.New(cfg)
+  if err != nil {
+    log.Fatal(err)
+  }
+  log.Printf("serving %s on %s", cfg.TemplatesFile, *addr)
+  log.Fatal(s.ListenAndServe(*addr))
+}
+
+func main() {
+  //defer profile.Start(profile.CPUProfile).Stop()
+  rand.Seed(time.Now().UTC().UnixNano())
+
+  // the first argument picks the command, defaulting to cluster
+  cmd := "cluster"
+  args := os.Args[1:]
+  if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+    cmd = args[0]
+    args = args[1:]
+  }
+
+  switch cmd {
+  case "cluster":
+    runCluster(args)
+  case "matrix":
+    runMatrix(args)
+  case "stability":
+    runStability(args)
+  case "dot":
+    runDot(args)
+  case "explain":
+    runExplain(args)
+  case "diff":
+    runDiff(args)
+  case "monitor":
+    runMonitor(args)
+  case "serve":
+    runServe(args)
+  default:
+    log.Fatalf("unknown command %q", cmd)
+  }
 }
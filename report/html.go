@@ -0,0 +1,92 @@
+package report
+
+// the page layout. everything, styles included, is inline so that the file
+// can be mailed or attached to a ticket on its own
+const reportHTML = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Title}}</title>
+<style>
+body { font-family: sans-serif; margin: 2em auto; max-width: 70em; color: #222; }
+h2 { border-bottom: 1px solid #ccc; padding-bottom: 0.2em; margin-top: 2em; }
+table { border-collapse: collapse; margin: 0.5em 0; }
+td, th { text-align: left; padding: 0.15em 0.6em; vertical-align: top; }
+th { background: #f0f0f0; }
+tr:nth-child(even) td { background: #fafafa; }
+pre { background: #f6f6f6; padding: 0.8em; overflow-x: auto; font-size: 85%; }
+code, .num { font-family: monospace; }
+.num { text-align: right; }
+.bar { background: #6a9fd8; height: 0.9em; }
+.barcell { width: 20em; }
+.muted { color: #888; }
+summary { cursor: pointer; }
+</style>
+</head>
+<body>
+<h1>{{.Title}}</h1>
+<p class="muted">Generated {{.Generated}}.
+{{len .Templates}} templates over {{.NumPages}} pages.</p>
+
+<table>
+<tr><th>Template</th><th>Pages</th><th>Url pattern</th><th>Mean score</th><th>Max score</th><th>Slots</th></tr>
+{{range .Templates}}<tr>
+<td><a href="#{{.Anchor}}">#{{.Number}}</a></td>
+<td class="num">{{.Size}}</td>
+<td><code>{{.UriPattern}}</code></td>
+{{if .Scored}}<td class="num">{{score .MeanScore}}</td>
+<td class="num">{{score .MaxScore}}</td>{{else}}<td class="muted">unknown</td><td class="muted">unknown</td>{{end}}
+<td class="num">{{len .Slots}}</td>
+</tr>{{end}}
+</table>
+
+{{range .Templates}}
+<h2 id="{{.Anchor}}">Template #{{.Number}}</h2>
+<p>{{.Size}} pages{{if .UriPattern}}, urls like <code>{{.UriPattern}}</code>{{end}}.</p>
+
+<h3>Representative pages</h3>
+<table>
+<tr><th>Url</th><th>Merge score</th></tr>
+{{range .Representatives}}<tr><td><a href="{{.Uri}}">{{.Uri}}</a></td>{{if .Scored}}<td class="num">{{score .Score}}</td>{{else}}<td class="muted">unknown</td>{{end}}</tr>
+{{end}}</table>
+
+<h3>Member merge scores</h3>
+{{if lt .Scored .Size}}<p class="muted">Scores of {{.Scored}} of the {{.Size}} pages, the others' doms weren't kept.</p>{{end}}
+<table>
+{{range .Histogram}}<tr>
+<td class="num">{{score .Lo}} &ndash; {{score .Hi}}</td>
+<td class="num">{{.Count}}</td>
+<td class="barcell"><div class="bar" style="{{width .Percent}}"></div></td>
+</tr>{{end}}
+</table>
+
+<h3>Variable slots</h3>
+{{if .Slots}}<table>
+<tr><th>Kind</th><th>Node</th><th>Sign</th><th>XPath</th><th>CSS</th></tr>
+{{range .Slots}}<tr>
+<td>{{.Kind}}</td>
+<td><code>{{.Node.Label}}</code></td>
+<td><code>{{.Node.Sign}}</code></td>
+<td><code>{{.XPath}}</code></td>
+<td><code>{{.CSS}}</code></td>
+</tr>{{end}}
+</table>{{else}}<p class="muted">Every page has the same structure.</p>{{end}}
+
+<details>
+<summary>Wrapper</summary>
+<pre>{{.Notation}}</pre>
+</details>
+{{end}}
+
+<h2 id="pages">Pages</h2>
+<table>
+<tr><th>Url</th><th>Template</th><th>Merge score</th></tr>
+{{range .Pages}}<tr>
+<td><a href="{{.Uri}}">{{.Uri}}</a></td>
+{{if .Template}}<td><a href="#{{.Template.Anchor}}">#{{.Template.Number}}</a></td>
+{{if .Scored}}<td class="num">{{score .Score}}</td>{{else}}<td class="muted">unknown</td>{{end}}{{else}}<td class="muted">none</td><td></td>{{end}}
+</tr>{{end}}
+</table>
+</body>
+</html>
+`
@@ -0,0 +1,207 @@
+/*
+Package report renders clustering results as a single self-contained html
+file, with no external stylesheets or scripts, that can be handed to people
+who don't run the clustering themselves.
+*/
+package report
+
+import (
+  "fmt"
+  "html/template"
+  "io"
+  "math"
+  "sort"
+  "time"
+
+  "github.com/predictive-edge/dom-cluster/cluster"
+  "github.com/predictive-edge/dom-cluster/dom"
+)
+
+type Options struct {
+  Title string
+  Representatives int // uris shown per template, the closest to its wrapper
+  HistogramBin float64 // width of the merge score histogram's bins
+}
+
+func DefaultOptions() *Options {
+  return &Options{
+    Title: "dom-cluster report",
+    Representatives: 5,
+    HistogramBin: 0.05,
+  }
+}
+
+type histogramBin struct {
+  Lo, Hi float64
+  Count int
+  Percent float64 // of the largest bin, for the bar width
+}
+
+type memberScore struct {
+  Uri string
+  Score float64
+  Scored bool // false if the member's dom isn't known, e.g. for loaded templates
+}
+
+type templateSection struct {
+  Number int
+  Anchor string
+  Size int
+  UriPattern string
+  Representatives []memberScore
+  Notation string
+  Scored int // members with a merge score
+  MeanScore, MaxScore float64 // of the scored members
+  Histogram []histogramBin
+  Slots []cluster.Slot
+
+  members []memberScore // every member, in the template's order
+}
+
+type pageRow struct {
+  Uri string
+  Template *templateSection // nil for noise
+  Score float64
+  Scored bool
+}
+
+type reportData struct {
+  Title string
+  Generated string
+  NumPages int
+  Templates []*templateSection
+  Pages []pageRow
+}
+
+func histogram(scores []float64, binWidth float64) []histogramBin {
+  max := cluster.MergeScoreCutoff
+  for _,score := range scores {
+    max = math.Max(max, score)
+  }
+  numBins := int(math.Ceil(max / binWidth))
+  if numBins < 1 {
+    numBins = 1
+  }
+  bins := make([]histogramBin, numBins)
+  for i := range bins {
+    bins[i].Lo = float64(i) * binWidth
+    bins[i].Hi = float64(i+1) * binWidth
+  }
+  largest := 0
+  for _,score := range scores {
+    i := int(score / binWidth)
+    if i >= numBins {
+      i = numBins - 1
+    }
+    bins[i].Count++
+    if bins[i].Count > largest {
+      largest = bins[i].Count
+    }
+  }
+  for i := range bins {
+    if largest > 0 {
+      bins[i].Percent = 100 * float64(bins[i].Count) / float64(largest)
+    }
+  }
+  return bins
+}
+
+func newTemplateSection(number int, t *cluster.Template, opts *Options) *templateSection {
+  section := &templateSection{
+    Number: number,
+    Anchor: fmt.Sprintf("template-%d", number),
+    Size: t.NumPages,
+    Notation: dom.FormatNotationIndent(t.Wrapper, "  "),
+    Slots: cluster.VariableSlots(t.Wrapper),
+  }
+  if t.UriPattern != nil {
+    section.UriPattern = t.UriPattern.String()
+  }
+
+  // how far each member is from the template it ended up in. templates
+  // loaded with LoadTemplates don't have their members' doms, only their uris
+  members := []memberScore{}
+  scores := []float64{}
+  for i,uri := range t.MemberUris() {
+    if i >= len(t.Included) || t.Included[i] == nil {
+      members = append(members, memberScore{ Uri: uri })
+      continue
+    }
+    _, score := cluster.NodeMerge(t.Wrapper, t.Included[i])
+    members = append(members, memberScore{ Uri: uri, Score: score, Scored: true })
+    scores = append(scores, score)
+    section.MeanScore += score
+    section.MaxScore = math.Max(section.MaxScore, score)
+  }
+  section.Scored = len(scores)
+  if section.Scored > 0 {
+    section.MeanScore /= float64(section.Scored)
+  }
+  section.Histogram = histogram(scores, opts.HistogramBin)
+
+  section.members = members
+
+  // unscored members come last
+  closest := append([]memberScore{}, members...)
+  sort.SliceStable(closest, func(i, j int) bool {
+    if closest[i].Scored != closest[j].Scored {
+      return closest[i].Scored
+    }
+    return closest[i].Score < closest[j].Score
+  })
+  if len(closest) > opts.Representatives {
+    closest = closest[:opts.Representatives]
+  }
+  section.Representatives = closest
+  return section
+}
+
+/*
+Write renders a report of templates, largest first, followed by an index of
+every page linking it to its template. Pages left out of every template,
+such as dbscan noise, can be passed as noise and are listed without one.
+*/
+func Write(w io.Writer, templates []*cluster.Template, noise []*dom.Entry, opts *Options) error {
+  if opts == nil {
+    opts = DefaultOptions()
+  }
+  sorted := append([]*cluster.Template{}, templates...)
+  sort.SliceStable(sorted, func(i, j int) bool {
+    return sorted[i].NumPages > sorted[j].NumPages
+  })
+
+  data := &reportData{
+    Title: opts.Title,
+    Generated: time.Now().UTC().Format(time.RFC1123),
+  }
+  for i,t := range sorted {
+    section := newTemplateSection(i+1, t, opts)
+    data.Templates = append(data.Templates, section)
+    for _,member := range section.members {
+      data.Pages = append(data.Pages, pageRow{
+        Uri: member.Uri,
+        Template: section,
+        Score: member.Score,
+        Scored: member.Scored,
+      })
+    }
+  }
+  for _,entry := range noise {
+    data.Pages = append(data.Pages, pageRow{ Uri: entry.Uri })
+  }
+  sort.SliceStable(data.Pages, func(i, j int) bool {
+    return data.Pages[i].Uri < data.Pages[j].Uri
+  })
+  data.NumPages = len(data.Pages)
+
+  return reportTemplate.Execute(w, data)
+}
+
+var reportTemplate = template.Must(template.New("report").Funcs(template.FuncMap{
+  "score": func(score float64) string {
+    return fmt.Sprintf("%.3f", score)
+  },
+  "width": func(percent float64) template.CSS {
+    return template.CSS(fmt.Sprintf("width: %.1f%%", percent))
+  },
+}).Parse(reportHTML))
@@ -0,0 +1,105 @@
+package report
+
+import (
+  "bytes"
+  "fmt"
+  "strings"
+  "testing"
+
+  "github.com/predictive-edge/dom-cluster/cluster"
+  "github.com/predictive-edge/dom-cluster/dom"
+)
+
+// two templates of three pages each, products and listings
+func testTemplates() ([]*cluster.Template, []*dom.Entry) {
+  entries := []*dom.Entry{}
+  labels := []int{}
+  for i := 0; i < 3; i++ {
+    html := fmt.Sprintf(`<html><body><h1>product %d</h1><ul>%s</ul></body></html>`,
+    i, strings.Repeat("<li><a>rel</a></li>", 2 + i))
+    entries = append(entries, &dom.Entry{
+      Uri: fmt.Sprintf("http://ex.com/product/%d", i),
+      Dom: cluster.NodeToWrapper(dom.ParseHTMLString(html), 10),
+    })
+    labels = append(labels, 0)
+  }
+  for i := 0; i < 3; i++ {
+    html := fmt.Sprintf(`<html><body><nav>x</nav><section>%s</section></body></html>`,
+    strings.Repeat("<div><img><span>item</span></div>", 3 + i))
+    entries = append(entries, &dom.Entry{
+      Uri: fmt.Sprintf("http://ex.com/category/%d", i),
+      Dom: cluster.NodeToWrapper(dom.ParseHTMLString(html), 10),
+    })
+    labels = append(labels, 1)
+  }
+  c := &cluster.Clustering{ Labels: labels, NumClusters: 2 }
+  return cluster.TemplatesFromClustering(entries, c, nil), entries
+}
+
+func TestWrite(t *testing.T) {
+  templates, _ := testTemplates()
+  noise := []*dom.Entry{{ Uri: "http://ex.com/about" }}
+  buf := &bytes.Buffer{}
+  if err := Write(buf, templates, noise, nil); err != nil {
+    t.Fatal(err)
+  }
+  out := buf.String()
+  for _,want := range []string{"2 templates over 7 pages", "http://ex.com/product/2",
+  "http://ex.com/category/0", "http://ex.com/about", `href="#template-2"`} {
+    if !strings.Contains(out, want) {
+      t.Errorf("report lacks %q", want)
+    }
+  }
+  for _,bad := range []string{"unknown", "NaN"} {
+    if strings.Contains(out, bad) {
+      t.Errorf("report has %q", bad)
+    }
+  }
+}
+
+func TestTemplateSectionScores(t *testing.T) {
+  templates, _ := testTemplates()
+  section := newTemplateSection(1, templates[0], DefaultOptions())
+  if section.Scored != 3 || len(section.members) != 3 {
+    t.Fatalf("%d of %d members scored, want 3 of 3", section.Scored, len(section.members))
+  }
+  sum := 0.0
+  for _,member := range section.members {
+    sum += member.Score
+  }
+  if mean := sum / 3; section.MeanScore < mean - 1e-9 || section.MeanScore > mean + 1e-9 {
+    t.Errorf("mean score %f, want %f", section.MeanScore, mean)
+  }
+}
+
+// templates loaded from a file only have their members' uris
+func TestWriteLoadedTemplates(t *testing.T) {
+  templates, _ := testTemplates()
+  buf := &bytes.Buffer{}
+  if err := cluster.SaveTemplates(buf, templates); err != nil {
+    t.Fatal(err)
+  }
+  loaded, err := cluster.LoadTemplates(buf)
+  if err != nil {
+    t.Fatal(err)
+  }
+
+  section := newTemplateSection(1, loaded[0], DefaultOptions())
+  if section.Scored != 0 || len(section.members) != 3 {
+    t.Errorf("%d of %d members scored, want 0 of 3", section.Scored, len(section.members))
+  }
+  if section.MeanScore != 0 || section.MaxScore != 0 {
+    t.Errorf("mean %f, max %f of no scores", section.MeanScore, section.MaxScore)
+  }
+
+  out := &bytes.Buffer{}
+  if err := Write(out, loaded, nil, nil); err != nil {
+    t.Fatal(err)
+  }
+  if strings.Contains(out.String(), "NaN") {
+    t.Error("report has NaN scores")
+  }
+  if !strings.Contains(out.String(), "Scores of 0 of the 3 pages") {
+    t.Error("report doesn't say the scores are missing")
+  }
+}
@@ -0,0 +1,347 @@
+/*
+Package server exposes a stored template set over http, so that scrapers
+written in other languages can classify pages and extract their data.
+
+  GET  /templates  lists the loaded templates
+  POST /reload     reloads the templates from disk
+  POST /classify   finds the template of a page
+  POST /extract    classifies a page and extracts its data
+
+Pages are posted either as a json entry ({"url": ..., "dom": ...}, content
+type application/json) or as raw html (any other content type), with the url
+in the url query parameter. /extract takes an optional template parameter to
+skip classification.
+*/
+package server
+
+import (
+  "context"
+  "encoding/json"
+  "errors"
+  "fmt"
+  "io/ioutil"
+  "log"
+  "mime"
+  "net/http"
+  "os"
+  "sync"
+  "time"
+
+  "github.com/predictive-edge/dom-cluster/cluster"
+  "github.com/predictive-edge/dom-cluster/dom"
+)
+
+type Config struct {
+  TemplatesFile string
+  MaxBodyBytes int64 // largest accepted request body
+  Timeout time.Duration // per request
+  Limits *dom.Limits // on the size of posted pages
+  Filter *dom.FilterConfig // applied to pages before wrapping, nil for none
+  Options *cluster.Options
+}
+
+func DefaultConfig() *Config {
+  return &Config{
+    TemplatesFile: "templates.json",
+    MaxBodyBytes: 10 << 20,
+    Timeout: 10 * time.Second,
+    Limits: dom.DefaultLimits(),
+    Options: cluster.DefaultOptions(),
+  }
+}
+
+type Server struct {
+  cfg *Config
+
+  mu sync.RWMutex // guards templates and loaded
+  templates []*cluster.Template
+  loaded time.Time
+}
+
+// New creates a server, loading its templates
+func New(cfg *Config) (*Server, error) {
+  s := &Server{ cfg: cfg }
+  if err := s.Reload(); err != nil {
+    return nil, err
+  }
+  return s, nil
+}
+
+// Reload replaces the templates with those currently on disk. requests in
+// flight finish with the old ones
+func (s *Server) Reload() error {
+  f, err := os.Open(s.cfg.TemplatesFile)
+  if err != nil {
+    return err
+  }
+  defer f.Close()
+  templates, err := cluster.LoadTemplates(f)
+  if err != nil {
+    return fmt.Errorf("%s: %s", s.cfg.TemplatesFile, err)
+  }
+  // the wrappers are shared by concurrent requests, so their lazily
+  // memoized metrics must be computed up front
+  for _,t := range templates {
+    t.Wrapper.TreeWeight()
+  }
+
+  s.mu.Lock()
+  s.templates = templates
+  s.loaded = time.Now()
+  s.mu.Unlock()
+  return nil
+}
+
+func (s *Server) currentTemplates() []*cluster.Template {
+  s.mu.RLock()
+  defer s.mu.RUnlock()
+  return s.templates
+}
+
+// Handler serves the endpoints, each request bounded by the configured
+// body size and timeout. a timed out request is answered with 503 and the
+// merges it was running are canceled
+func (s *Server) Handler() http.Handler {
+  mux := http.NewServeMux()
+  mux.HandleFunc("/templates", s.handleTemplates)
+  mux.HandleFunc("/reload", s.handleReload)
+  mux.HandleFunc("/classify", s.handleClassify)
+  mux.HandleFunc("/extract", s.handleExtract)
+
+  limited := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+    r.Body = http.MaxBytesReader(w, r.Body, s.cfg.MaxBodyBytes)
+    mux.ServeHTTP(w, r)
+  })
+  return http.TimeoutHandler(limited, s.cfg.Timeout, "request timed out\n")
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+  w.Header().Set("Content-Type", "application/json")
+  w.WriteHeader(status)
+  if err := json.NewEncoder(w).Encode(v); err != nil {
+    log.Printf("writing response: %s", err)
+  }
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+  writeJSON(w, status, map[string]string{ "error": err.Error() })
+}
+
+func requireMethod(w http.ResponseWriter, r *http.Request, method string) bool {
+  if r.Method != method {
+    w.Header().Set("Allow", method)
+    writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("use %s", method))
+    return false
+  }
+  return true
+}
+
+type templateInfo struct {
+  Id string `json:"id"`
+  BaseUri string `json:"baseUri"`
+  NumPages int `json:"numPages"`
+  UriPattern string `json:"uriPattern,omitempty"`
+  Wrapper string `json:"wrapper"` // in the template notation
+}
+
+func (s *Server) handleTemplates(w http.ResponseWriter, r *http.Request) {
+  if !requireMethod(w, r, http.MethodGet) {
+    return
+  }
+  s.mu.RLock()
+  templates, loaded := s.templates, s.loaded
+  s.mu.RUnlock()
+
+  infos := []templateInfo{}
+  for _,t := range templates {
+    info := templateInfo{
+      Id: t.Id,
+      BaseUri: t.BaseUri,
+      NumPages: t.NumPages,
+      Wrapper: dom.FormatNotation(t.Wrapper),
+    }
+    if t.UriPattern != nil {
+      info.UriPattern = t.UriPattern.String()
+    }
+    infos = append(infos, info)
+  }
+  writeJSON(w, http.StatusOK, map[string]interface{}{
+    "loaded": loaded.UTC().Format(time.RFC3339),
+    "templates": infos,
+  })
+}
+
+func (s *Server) handleReload(w http.ResponseWriter, r *http.Request) {
+  if !requireMethod(w, r, http.MethodPost) {
+    return
+  }
+  if err := s.Reload(); err != nil {
+    writeError(w, http.StatusInternalServerError, err)
+    return
+  }
+  writeJSON(w, http.StatusOK, map[string]int{
+    "templates": len(s.currentTemplates()),
+  })
+}
+
+// a posted page, filtered, along with its wrapper
+type postedPage struct {
+  uri string
+  page *dom.Node
+  wrapper *dom.Node
+}
+
+// reads the page of a request as a json entry or raw html, returning the
+// status to fail with if it can't be
+func (s *Server) readPage(r *http.Request) (*postedPage, int, error) {
+  body, err := ioutil.ReadAll(r.Body)
+  if err != nil {
+    var tooLarge *http.MaxBytesError
+    if errors.As(err, &tooLarge) {
+      return nil, http.StatusRequestEntityTooLarge, err
+    }
+    return nil, http.StatusBadRequest, err
+  }
+
+  var entry dom.Entry
+  mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+  if mediaType == "application/json" {
+    if err := json.Unmarshal(body, &entry); err != nil {
+      return nil, http.StatusBadRequest, err
+    }
+  } else {
+    entry.Uri = r.URL.Query().Get("url")
+    entry.Dom = dom.ParseHTMLString(string(body))
+  }
+  if entry.Uri == "" {
+    entry.Uri = r.URL.Query().Get("url")
+  }
+  // the url is optional here, it only matters with a url weight
+  if entry.Uri == "" {
+    entry.Uri = "about:blank"
+  }
+  if diags := dom.ValidateEntry(&entry, s.cfg.Limits); len(diags) > 0 {
+    return nil, http.StatusUnprocessableEntity, diags[0]
+  }
+
+  if s.cfg.Filter != nil {
+    dom.Filter(entry.Dom, s.cfg.Filter)
+  }
+  wrapper, err := cluster.NodeToWrapperContext(r.Context(), entry.Dom.Clone(), 10)
+  if err != nil {
+    return nil, http.StatusServiceUnavailable, err
+  }
+  return &postedPage{
+    uri: entry.Uri,
+    page: entry.Dom,
+    wrapper: wrapper,
+  }, http.StatusOK, nil
+}
+
+type classifyResponse struct {
+  Template *string `json:"template"` // null if no template is close enough
+  Nearest string `json:"nearest,omitempty"`
+  Score float64 `json:"score"` // distance to the nearest template
+}
+
+// classifies a page against templates, returning its template if it has one
+func (s *Server) classify(ctx context.Context, templates []*cluster.Template, p *postedPage) (*classifyResponse, *cluster.Template, error) {
+  nearest, score, err := cluster.NearestContext(ctx, templates, &dom.Entry{
+    Uri: p.uri,
+    Dom: p.wrapper,
+  }, s.cfg.Options)
+  if err != nil {
+    return nil, nil, err
+  }
+  resp := &classifyResponse{}
+  if nearest == nil {
+    return resp, nil, nil
+  }
+  resp.Nearest = nearest.Id
+  resp.Score = score
+  if score >= cluster.MergeScoreCutoff {
+    return resp, nil, nil
+  }
+  resp.Template = &nearest.Id
+  return resp, nearest, nil
+}
+
+func (s *Server) handleClassify(w http.ResponseWriter, r *http.Request) {
+  if !requireMethod(w, r, http.MethodPost) {
+    return
+  }
+  p, status, err := s.readPage(r)
+  if err != nil {
+    writeError(w, status, err)
+    return
+  }
+  resp, _, err := s.classify(r.Context(), s.currentTemplates(), p)
+  if err != nil {
+    writeError(w, http.StatusServiceUnavailable, err)
+    return
+  }
+  writeJSON(w, http.StatusOK, resp)
+}
+
+type extractResponse struct {
+  *classifyResponse
+  *cluster.Extraction
+}
+
+func (s *Server) handleExtract(w http.ResponseWriter, r *http.Request) {
+  if !requireMethod(w, r, http.MethodPost) {
+    return
+  }
+  p, status, err := s.readPage(r)
+  if err != nil {
+    writeError(w, status, err)
+    return
+  }
+
+  // the same templates throughout, even if they are reloaded meanwhile
+  templates := s.currentTemplates()
+  var template *cluster.Template
+  resp := &extractResponse{}
+  if id := r.URL.Query().Get("template"); id != "" {
+    for _,t := range templates {
+      if t.Id == id {
+        template = t
+      }
+    }
+    if template == nil {
+      writeError(w, http.StatusNotFound, fmt.Errorf("no template %q", id))
+      return
+    }
+    _, score, err := template.DistanceContext(r.Context(), &dom.Entry{ Uri: p.uri, Dom: p.wrapper }, s.cfg.Options)
+    if err != nil {
+      writeError(w, http.StatusServiceUnavailable, err)
+      return
+    }
+    resp.classifyResponse = &classifyResponse{
+      Template: &template.Id,
+      Nearest: template.Id,
+      Score: score,
+    }
+  } else {
+    resp.classifyResponse, template, err = s.classify(r.Context(), templates, p)
+    if err != nil {
+      writeError(w, http.StatusServiceUnavailable, err)
+      return
+    }
+  }
+
+  if template != nil {
+    resp.Extraction = cluster.Extract(template.Wrapper, p.page)
+  }
+  writeJSON(w, http.StatusOK, resp)
+}
+
+// ListenAndServe serves the endpoints on addr
+func (s *Server) ListenAndServe(addr string) error {
+  srv := &http.Server{
+    Addr: addr,
+    Handler: s.Handler(),
+    ReadHeaderTimeout: s.cfg.Timeout,
+  }
+  return srv.ListenAndServe()
+}
@@ -0,0 +1,282 @@
+package server
+
+import (
+  "encoding/json"
+  "fmt"
+  "io/ioutil"
+  "net/http"
+  "net/http/httptest"
+  "os"
+  "path/filepath"
+  "strings"
+  "testing"
+
+  "github.com/predictive-edge/dom-cluster/cluster"
+  "github.com/predictive-edge/dom-cluster/dom"
+)
+
+func productHTML(i int) string {
+  return fmt.Sprintf(`<html><body><div><a href="/">home</a></div>
+    <div><h1>product %d</h1><p>$%d</p><ul><li>a</li><li>b</li></ul></div></body></html>`, i, i)
+}
+
+func listingHTML(i int) string {
+  return fmt.Sprintf(`<html><body><nav><a>x</a></nav><section>%s</section><footer>f</footer></body></html>`,
+  strings.Repeat("<div><img><span>item</span></div>", 3 + i))
+}
+
+// builds a template from the merged wrappers of some pages
+func testTemplate(id string, pages ...string) *cluster.Template {
+  var t *cluster.Template
+  for _,page := range pages {
+    wrapper := cluster.NodeToWrapper(dom.ParseHTMLString(page), 10)
+    if t == nil {
+      t = cluster.NewTemplate(wrapper)
+      t.Id = id
+    } else {
+      t.Wrapper, _ = cluster.NodeMerge(t.Wrapper, wrapper)
+      t.NumPages++
+    }
+  }
+  return t
+}
+
+func writeTemplates(t *testing.T, path string, templates ...*cluster.Template) {
+  f, err := os.Create(path)
+  if err != nil {
+    t.Fatal(err)
+  }
+  defer f.Close()
+  if err := cluster.SaveTemplates(f, templates); err != nil {
+    t.Fatal(err)
+  }
+}
+
+// a server of a product and a listing template
+func testServer(t *testing.T, cfg *Config) (*Server, *httptest.Server) {
+  if cfg == nil {
+    cfg = DefaultConfig()
+  }
+  cfg.TemplatesFile = filepath.Join(t.TempDir(), "templates.json")
+  writeTemplates(t, cfg.TemplatesFile,
+    testTemplate("products", productHTML(1), productHTML(2)),
+    testTemplate("listings", listingHTML(0), listingHTML(1)))
+  s, err := New(cfg)
+  if err != nil {
+    t.Fatal(err)
+  }
+  ts := httptest.NewServer(s.Handler())
+  t.Cleanup(ts.Close)
+  return s, ts
+}
+
+// posts a body and decodes the json response into v, returning the status
+func post(t *testing.T, url, contentType, body string, v interface{}) int {
+  resp, err := http.Post(url, contentType, strings.NewReader(body))
+  if err != nil {
+    t.Fatal(err)
+  }
+  defer resp.Body.Close()
+  data, err := ioutil.ReadAll(resp.Body)
+  if err != nil {
+    t.Fatal(err)
+  }
+  if v != nil {
+    if err := json.Unmarshal(data, v); err != nil {
+      t.Fatalf("%s: %s: %s", url, err, data)
+    }
+  }
+  return resp.StatusCode
+}
+
+func jsonEntry(t *testing.T, uri, page string) string {
+  data, err := json.Marshal(&dom.Entry{
+    Uri: uri,
+    Dom: dom.ParseHTMLString(page),
+  })
+  if err != nil {
+    t.Fatal(err)
+  }
+  return string(data)
+}
+
+func TestNew(t *testing.T) {
+  cfg := DefaultConfig()
+  cfg.TemplatesFile = filepath.Join(t.TempDir(), "missing.json")
+  if _,err := New(cfg); err == nil {
+    t.Error("no error for a missing templates file")
+  }
+
+  cfg.TemplatesFile = filepath.Join(t.TempDir(), "bad.json")
+  ioutil.WriteFile(cfg.TemplatesFile, []byte(`{"version": 99}`), 0644)
+  if _,err := New(cfg); err == nil || !strings.Contains(err.Error(), "bad.json") {
+    t.Errorf("bad templates file: %v", err)
+  }
+}
+
+func TestTemplates(t *testing.T) {
+  _, ts := testServer(t, nil)
+
+  resp, err := http.Get(ts.URL + "/templates")
+  if err != nil {
+    t.Fatal(err)
+  }
+  defer resp.Body.Close()
+  var list struct {
+    Loaded string `json:"loaded"`
+    Templates []templateInfo `json:"templates"`
+  }
+  if err := json.NewDecoder(resp.Body).Decode(&list); err != nil {
+    t.Fatal(err)
+  }
+  if len(list.Templates) != 2 || list.Templates[0].Id != "products" || list.Templates[1].Id != "listings" {
+    t.Fatalf("templates %+v", list.Templates)
+  }
+  if list.Templates[0].NumPages != 2 || list.Templates[0].Wrapper == "" || list.Loaded == "" {
+    t.Errorf("template %+v, loaded %q", list.Templates[0], list.Loaded)
+  }
+
+  if status := post(t, ts.URL + "/templates", "text/html", "", nil); status != http.StatusMethodNotAllowed {
+    t.Errorf("POST /templates: status %d", status)
+  }
+}
+
+func TestClassify(t *testing.T) {
+  _, ts := testServer(t, nil)
+
+  tests := []struct {
+    name, query, contentType, body string
+    status int
+    template, nearest string // template empty if none
+  }{
+    {"html product", "", "text/html", productHTML(7), http.StatusOK, "products", "products"},
+    {"html listing", "?url=http://ex.com/c", "text/html; charset=utf-8", listingHTML(2), http.StatusOK, "listings", "listings"},
+    {"json listing", "", "application/json", jsonEntry(t, "http://ex.com/c", listingHTML(3)), http.StatusOK, "listings", "listings"},
+    {"unknown page", "", "text/html", `<html><body><table><tr><td>1</td></tr></table><form><input></form></body></html>`,
+      http.StatusOK, "", "products"},
+    {"bad json", "", "application/json", `{"url":`, http.StatusBadRequest, "", ""},
+    {"json without dom", "", "application/json", `{"url":"http://ex.com/"}`, http.StatusUnprocessableEntity, "", ""},
+  }
+  for _,test := range tests {
+    var resp struct {
+      classifyResponse
+      Error string `json:"error"`
+    }
+    status := post(t, ts.URL + "/classify" + test.query, test.contentType, test.body, &resp)
+    if status != test.status {
+      t.Errorf("%s: status %d, want %d (%s)", test.name, status, test.status, resp.Error)
+      continue
+    }
+    if status != http.StatusOK {
+      if resp.Error == "" {
+        t.Errorf("%s: no error message", test.name)
+      }
+      continue
+    }
+    template := ""
+    if resp.Template != nil {
+      template = *resp.Template
+    }
+    if template != test.template || (test.nearest != "" && resp.Nearest == "") {
+      t.Errorf("%s: template %q nearest %q score %f, want %q", test.name, template, resp.Nearest, resp.Score, test.template)
+    }
+  }
+
+  resp, err := http.Get(ts.URL + "/classify")
+  if err != nil {
+    t.Fatal(err)
+  }
+  resp.Body.Close()
+  if resp.StatusCode != http.StatusMethodNotAllowed || resp.Header.Get("Allow") != "POST" {
+    t.Errorf("GET /classify: status %d, Allow %q", resp.StatusCode, resp.Header.Get("Allow"))
+  }
+}
+
+func TestExtract(t *testing.T) {
+  _, ts := testServer(t, nil)
+
+  var resp struct {
+    Template *string `json:"template"`
+    Fields []*cluster.Field `json:"fields"`
+    Matched int `json:"matched"`
+  }
+  if status := post(t, ts.URL + "/extract", "text/html", productHTML(7), &resp); status != http.StatusOK {
+    t.Fatalf("status %d", status)
+  }
+  if resp.Template == nil || *resp.Template != "products" || resp.Matched == 0 {
+    t.Fatalf("template %v, %d matched", resp.Template, resp.Matched)
+  }
+  values := []string{}
+  for _,field := range resp.Fields {
+    values = append(values, field.Values...)
+  }
+  if all := strings.Join(values, "|"); !strings.Contains(all, "product 7") || !strings.Contains(all, "$7") {
+    t.Errorf("extracted %q", all)
+  }
+
+  // a named template is used even if another one is nearer
+  resp.Template, resp.Fields = nil, nil
+  if status := post(t, ts.URL + "/extract?template=listings", "text/html", productHTML(7), &resp); status != http.StatusOK {
+    t.Fatalf("status %d", status)
+  }
+  if resp.Template == nil || *resp.Template != "listings" {
+    t.Errorf("template %v, want listings", resp.Template)
+  }
+
+  if status := post(t, ts.URL + "/extract?template=none", "text/html", productHTML(7), nil); status != http.StatusNotFound {
+    t.Errorf("unknown template: status %d", status)
+  }
+
+  // no extraction without a template
+  var unknown map[string]interface{}
+  post(t, ts.URL + "/extract", "text/html", `<html><body><table><tr><td>1</td></tr></table></body></html>`, &unknown)
+  if _,exists := unknown["fields"]; exists || unknown["template"] != nil {
+    t.Errorf("unknown page: %v", unknown)
+  }
+}
+
+func TestMaxBodyBytes(t *testing.T) {
+  cfg := DefaultConfig()
+  cfg.MaxBodyBytes = 100
+  _, ts := testServer(t, cfg)
+
+  if status := post(t, ts.URL + "/classify", "text/html", productHTML(1), nil); status != http.StatusRequestEntityTooLarge {
+    t.Errorf("large body: status %d", status)
+  }
+  if status := post(t, ts.URL + "/classify", "text/html", "<p>x</p>", nil); status != http.StatusOK {
+    t.Errorf("small body: status %d", status)
+  }
+}
+
+func TestReload(t *testing.T) {
+  s, ts := testServer(t, nil)
+
+  writeTemplates(t, s.cfg.TemplatesFile, testTemplate("only", listingHTML(0)))
+  var reloaded map[string]int
+  if status := post(t, ts.URL + "/reload", "", "", &reloaded); status != http.StatusOK || reloaded["templates"] != 1 {
+    t.Errorf("reload: status %d, %v", status, reloaded)
+  }
+  var resp classifyResponse
+  post(t, ts.URL + "/classify", "text/html", productHTML(1), &resp)
+  if resp.Nearest != "only" {
+    t.Errorf("nearest %q after reload, want only", resp.Nearest)
+  }
+
+  // a failed reload keeps the templates
+  ioutil.WriteFile(s.cfg.TemplatesFile, []byte("not json"), 0644)
+  if status := post(t, ts.URL + "/reload", "", "", nil); status != http.StatusInternalServerError {
+    t.Errorf("bad reload: status %d", status)
+  }
+  if templates := s.currentTemplates(); len(templates) != 1 || templates[0].Id != "only" {
+    t.Errorf("%d templates after a failed reload", len(templates))
+  }
+
+  get, err := http.Get(ts.URL + "/reload")
+  if err != nil {
+    t.Fatal(err)
+  }
+  get.Body.Close()
+  if get.StatusCode != http.StatusMethodNotAllowed {
+    t.Errorf("GET /reload: status %d", get.StatusCode)
+  }
+}
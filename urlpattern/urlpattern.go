@@ -0,0 +1,280 @@
+package urlpattern
+
+import (
+  "bytes"
+  "fmt"
+  "net/url"
+  "sort"
+  "strings"
+  "unicode"
+)
+
+// generalized token classes. a literal segment is replaced by one of these
+// when it looks like an identifier rather than part of the site's structure
+const (
+  IntToken = "<int>"
+  HexToken = "<hex>"
+  SlugToken = "<slug>"
+  AnyToken = "<*>" // any single segment or value
+  OptToken = "<?>" // query value that may be missing entirely
+)
+
+// Tokens is a url split into the parts that are compared by patterns
+type Tokens struct {
+  Host string
+  Path []string
+  Query map[string]string
+}
+
+// Tokenize splits a url into host, non-empty path segments and query
+// key/value pairs. only the first value of a repeated query key is kept
+func Tokenize(rawurl string) (*Tokens, error) {
+  u, err := url.Parse(rawurl)
+  if err != nil {
+    return nil, err
+  }
+
+  tokens := &Tokens{
+    Host: strings.ToLower(u.Host),
+    Query: map[string]string{},
+  }
+  for _,seg := range strings.Split(u.EscapedPath(), "/") {
+    if seg == "" { continue }
+    if unescaped, err := url.PathUnescape(seg); err == nil {
+      seg = unescaped
+    }
+    tokens.Path = append(tokens.Path, seg)
+  }
+  for key,vals := range u.Query() {
+    if len(vals) > 0 {
+      tokens.Query[key] = vals[0]
+    } else {
+      tokens.Query[key] = ""
+    }
+  }
+  return tokens, nil
+}
+
+func isHexString(s string) bool {
+  hasDigit := false
+  for _,r := range s {
+    switch {
+    case r >= '0' && r <= '9':
+      hasDigit = true
+    case r >= 'a' && r <= 'f', r >= 'A' && r <= 'F', r == '-':
+    default:
+      return false
+    }
+  }
+  return hasDigit
+}
+
+// Generalize maps a single path segment or query value to its token class,
+// or returns it unchanged if it looks like a structural literal.
+//  "1234" -> <int>
+//  "9f86d081884c7d65", uuids -> <hex>
+//  "red-running-shoes", "item_42" -> <slug>
+func Generalize(seg string) string {
+  if seg == "" {
+    return seg
+  }
+
+  allDigits := true
+  for _,r := range seg {
+    if !unicode.IsDigit(r) {
+      allDigits = false
+      break
+    }
+  }
+  if allDigits {
+    return IntToken
+  }
+
+  if len(seg) >= 8 && isHexString(seg) {
+    return HexToken
+  }
+
+  // slugs are runs of words joined by dashes/underscores, possibly with an
+  // extension or trailing numeric id
+  if strings.ContainsAny(seg, "-_") {
+    words := strings.FieldsFunc(seg, func(r rune) bool {
+      return r == '-' || r == '_'
+    })
+    if len(words) >= 2 {
+      return SlugToken
+    }
+  }
+
+  return seg
+}
+
+func isToken(seg string) bool {
+  return len(seg) > 2 && seg[0] == '<' && seg[len(seg)-1] == '>'
+}
+
+// Pattern is a generalized url. Path segments and query values are either
+// literals or token classes; Tail means any number of further path segments
+// may follow
+type Pattern struct {
+  Host string
+  Path []string
+  Tail bool
+  Query map[string]string
+}
+
+// FromTokens generalizes every path segment and query value
+func FromTokens(tokens *Tokens) *Pattern {
+  p := &Pattern{
+    Host: tokens.Host,
+    Query: map[string]string{},
+  }
+  for _,seg := range tokens.Path {
+    p.Path = append(p.Path, Generalize(seg))
+  }
+  for key,val := range tokens.Query {
+    p.Query[key] = Generalize(val)
+  }
+  return p
+}
+
+// FromURL builds the pattern of a single url. unparseable urls produce an
+// empty pattern, which is at maximal distance from everything but itself
+func FromURL(rawurl string) *Pattern {
+  tokens, err := Tokenize(rawurl)
+  if err != nil {
+    return &Pattern{ Query: map[string]string{} }
+  }
+  return FromTokens(tokens)
+}
+
+// merges two segments into the most specific segment matching both
+func mergeSeg(a,b string) string {
+  switch {
+  case a == b:
+    return a
+  case a == AnyToken || b == AnyToken:
+    return AnyToken
+  // a literal which generalizes to the other side's class joins that class
+  case !isToken(a) && Generalize(a) == b:
+    return b
+  case !isToken(b) && Generalize(b) == a:
+    return a
+  default:
+    return AnyToken
+  }
+}
+
+// Merge returns the most specific pattern that matches everything either p
+// or o matches
+func (p *Pattern) Merge(o *Pattern) *Pattern {
+  merged := &Pattern{
+    Host: p.Host,
+    Tail: p.Tail || o.Tail || len(p.Path) != len(o.Path),
+    Query: map[string]string{},
+  }
+  if p.Host != o.Host {
+    merged.Host = AnyToken
+  }
+
+  common := len(p.Path)
+  if len(o.Path) < common {
+    common = len(o.Path)
+  }
+  for i := 0; i < common; i++ {
+    merged.Path = append(merged.Path, mergeSeg(p.Path[i], o.Path[i]))
+  }
+
+  for key,pVal := range p.Query {
+    if oVal, exists := o.Query[key]; exists {
+      merged.Query[key] = mergeSeg(pVal, oVal)
+    } else {
+      merged.Query[key] = OptToken
+    }
+  }
+  for key := range o.Query {
+    if _,exists := p.Query[key]; !exists {
+      merged.Query[key] = OptToken
+    }
+  }
+
+  return merged
+}
+
+// cost of aligning two segments: 0 if one matches the other, 1 otherwise
+func segCost(a,b string) float64 {
+  if mergeSeg(a,b) == AnyToken && a != AnyToken && b != AnyToken {
+    return 1
+  }
+  return 0
+}
+
+// Distance is the fraction of mismatched parts between two patterns, in
+// [0,1]. query parameters count half as much as path segments, since they
+// vary more freely within a page type
+func (p *Pattern) Distance(o *Pattern) float64 {
+  cost := 0.0
+  total := 1.0 // the host
+
+  if p.Host != o.Host && p.Host != AnyToken && o.Host != AnyToken {
+    cost += 1
+  }
+
+  long, short := p, o
+  if len(short.Path) > len(long.Path) {
+    long, short = short, long
+  }
+  for i,seg := range long.Path {
+    total += 1
+    if i < len(short.Path) {
+      cost += segCost(seg, short.Path[i])
+    } else if !short.Tail {
+      cost += 1
+    }
+  }
+
+  keys := map[string]struct{}{}
+  for key := range p.Query { keys[key] = struct{}{} }
+  for key := range o.Query { keys[key] = struct{}{} }
+  for key := range keys {
+    total += 0.5
+    pVal, pExists := p.Query[key]
+    oVal, oExists := o.Query[key]
+    switch {
+    case pExists && oExists:
+      if pVal != OptToken && oVal != OptToken {
+        cost += 0.5*segCost(pVal, oVal)
+      }
+    case pVal == OptToken || oVal == OptToken:
+    default:
+      cost += 0.5
+    }
+  }
+
+  return cost / total
+}
+
+func (p *Pattern) String() string {
+  buf := bytes.NewBufferString(p.Host)
+  for _,seg := range p.Path {
+    buf.WriteString("/")
+    buf.WriteString(seg)
+  }
+  if p.Tail {
+    buf.WriteString("/<**>")
+  } else if len(p.Path) == 0 {
+    buf.WriteString("/")
+  }
+
+  keys := make([]string, 0, len(p.Query))
+  for key := range p.Query {
+    keys = append(keys, key)
+  }
+  sort.Strings(keys)
+  for i,key := range keys {
+    sep := "&"
+    if i == 0 { sep = "?" }
+    buf.WriteString(fmt.Sprintf("%s%s=%s", sep, key, p.Query[key]))
+  }
+
+  return buf.String()
+}
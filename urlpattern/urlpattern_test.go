@@ -0,0 +1,111 @@
+package urlpattern
+
+import (
+  "math"
+  "testing"
+)
+
+func TestGeneralize(t *testing.T) {
+  tests := []struct {
+    seg, want string
+  }{
+    {"", ""},
+    {"1234", IntToken},
+    {"0", IntToken},
+    {"9f86d081884c7d65", HexToken},
+    {"123e4567-e89b-12d3-a456-426614174000", HexToken},
+    {"deadbeefcafe", "deadbeefcafe"}, // no digit, so a word
+    {"abc123", "abc123"}, // too short for hex
+    {"red-running-shoes", SlugToken},
+    {"item_42", SlugToken},
+    {"shoes.html", "shoes.html"},
+    {"-x", "-x"}, // a single word
+    {"products", "products"},
+    {"<int>", "<int>"},
+  }
+  for _,test := range tests {
+    if got := Generalize(test.seg); got != test.want {
+      t.Errorf("Generalize(%q) = %q, want %q", test.seg, got, test.want)
+    }
+  }
+}
+
+func TestFromURL(t *testing.T) {
+  tests := []struct {
+    url, want string
+  }{
+    {"http://Ex.com/product/1234", "ex.com/product/<int>"},
+    {"http://ex.com/", "ex.com/"},
+    {"http://ex.com", "ex.com/"},
+    {"http://ex.com//a//b/", "ex.com/a/b"},
+    {"http://ex.com/c/red-shoes?page=2&sort=price", "ex.com/c/<slug>?page=<int>&sort=price"},
+    {"http://ex.com/p?id=1&id=2", "ex.com/p?id=<int>"},
+    {"http://ex.com/a%20b/x%2Fy", "ex.com/a b/x/y"},
+    {"%zz", "/"},
+  }
+  for _,test := range tests {
+    if got := FromURL(test.url).String(); got != test.want {
+      t.Errorf("FromURL(%q) = %s, want %s", test.url, got, test.want)
+    }
+  }
+}
+
+func TestMerge(t *testing.T) {
+  tests := []struct {
+    a, b, want string
+  }{
+    {"http://ex.com/product/1", "http://ex.com/product/2", "ex.com/product/<int>"},
+    {"http://ex.com/product/1", "http://ex.com/product/abc", "ex.com/product/<*>"},
+    {"http://ex.com/c/red-shoes", "http://ex.com/c/blue-hats", "ex.com/c/<slug>"},
+    {"http://ex.com/a/b", "http://ex.com/a", "ex.com/a/<**>"},
+    {"http://ex.com/a", "http://other.com/a", "<*>/a"},
+    {"http://ex.com/s?q=x", "http://ex.com/s?q=y&page=2", "ex.com/s?page=<?>&q=<*>"},
+    {"http://ex.com/s?q=x", "http://ex.com/s?q=x", "ex.com/s?q=x"},
+  }
+  for _,test := range tests {
+    a, b := FromURL(test.a), FromURL(test.b)
+    if got := a.Merge(b).String(); got != test.want {
+      t.Errorf("%s merged with %s = %s, want %s", a, b, got, test.want)
+    }
+    if got := b.Merge(a).String(); got != test.want {
+      t.Errorf("%s merged with %s = %s, want %s", b, a, got, test.want)
+    }
+  }
+
+  // a literal joins a class it belongs to
+  merged := FromURL("http://ex.com/p/1").Merge(FromURL("http://ex.com/p/2"))
+  if got := merged.Merge(FromURL("http://ex.com/p/3")).String(); got != "ex.com/p/<int>" {
+    t.Errorf("merged with a third url: %s", got)
+  }
+}
+
+func TestDistance(t *testing.T) {
+  tests := []struct {
+    a, b string
+    want float64
+  }{
+    {"http://ex.com/product/1", "http://ex.com/product/2", 0},
+    {"http://ex.com/product/1", "http://ex.com/category/2", 1.0/3},
+    {"http://ex.com/a/b", "http://ex.com/a", 1.0/3},
+    {"http://ex.com/a", "http://other.org/b", 1},
+    {"http://ex.com/s?q=x", "http://ex.com/s?q=y", 0.5/2.5},
+    {"http://ex.com/s?q=x", "http://ex.com/s", 0.5/2.5},
+  }
+  for _,test := range tests {
+    a, b := FromURL(test.a), FromURL(test.b)
+    for _,d := range []float64{a.Distance(b), b.Distance(a)} {
+      if math.Abs(d - test.want) > 1e-9 {
+        t.Errorf("distance of %s and %s = %f, want %f", a, b, d, test.want)
+      }
+    }
+  }
+
+  // a merged pattern is at no distance from what it was merged from
+  a, b := FromURL("http://ex.com/s/1?q=x"), FromURL("http://ex.com/s/2/more?page=3")
+  merged := a.Merge(b)
+  for _,p := range []*Pattern{a, b} {
+    if d := merged.Distance(p); d != 0 {
+      t.Errorf("distance of %s and %s = %f, want 0", merged, p, d)
+    }
+  }
+}